@@ -0,0 +1,77 @@
+package acl
+
+import (
+	"com.sentry.dev/app/config"
+	"fmt"
+	"net/netip"
+)
+
+// Action taken for a client matched by an access-control list
+type Action int
+
+const (
+	Allow  Action = iota // Serve the client
+	Refuse               // Answer with REFUSED
+	Drop                 // Send nothing
+)
+
+func parseAction(name string) (Action, error) {
+	switch name {
+	case "", "allow":
+		return Allow, nil
+	case "refuse":
+		return Refuse, nil
+	case "drop":
+		return Drop, nil
+	default:
+		return Allow, fmt.Errorf("unknown ACL action %q", name)
+	}
+}
+
+type rule struct {
+	prefix netip.Prefix
+	action Action
+}
+
+// List maps client addresses to actions; the most specific matching prefix
+// wins and unmatched clients get the default action
+type List struct {
+	rules         []rule
+	defaultAction Action
+}
+
+// New builds a List from its configuration
+func New(cfg *config.ACLConfig) (*List, error) {
+	defaultAction, err := parseAction(cfg.Default)
+	if err != nil {
+		return nil, err
+	}
+	list := &List{defaultAction: defaultAction}
+	for _, r := range cfg.Rules {
+		prefix, err := netip.ParsePrefix(r.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACL range %q: %w", r.CIDR, err)
+		}
+		action, err := parseAction(r.Action)
+		if err != nil {
+			return nil, err
+		}
+		list.rules = append(list.rules, rule{prefix: prefix.Masked(), action: action})
+	}
+	return list, nil
+}
+
+// Check returns the action for a client address. The zone of a link-local
+// address is ignored, prefixes never contain zoned addresses.
+func (l *List) Check(addr netip.Addr) Action {
+	addr = addr.Unmap().WithZone("")
+	action := l.defaultAction
+	bits := -1
+	for _, r := range l.rules {
+		if r.prefix.Bits() > bits && r.prefix.Contains(addr) {
+			action = r.action
+			bits = r.prefix.Bits()
+		}
+	}
+	return action
+}
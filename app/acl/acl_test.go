@@ -0,0 +1,47 @@
+package acl
+
+import (
+	"com.sentry.dev/app/config"
+	"net/netip"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	l, err := New(&config.ACLConfig{
+		Default: "refuse",
+		Rules: []config.ACLRule{
+			{CIDR: "10.0.0.0/8", Action: "allow"},
+			{CIDR: "10.1.0.0/16", Action: "drop"},
+			{CIDR: "172.16.0.0/12", Action: "allow"},
+			{CIDR: "fe80::/10", Action: "allow"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]Action{
+		"10.2.3.4":        Allow,
+		"10.1.2.3":        Drop, // the most specific range wins
+		"172.31.255.1":    Allow,
+		"172.32.0.1":      Refuse,
+		"::ffff:10.2.3.4": Allow,
+		"fe80::1%eth0":    Allow,
+		"2001:db8::1":     Refuse,
+	} {
+		if got := l.Check(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("%s: %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestNewRejectsBadRules(t *testing.T) {
+	for _, cfg := range []config.ACLConfig{
+		{Default: "deny"},
+		{Rules: []config.ACLRule{{CIDR: "10.0.0.0", Action: "allow"}}},
+		{Rules: []config.ACLRule{{CIDR: "10.0.0.0/8", Action: "block"}}},
+	} {
+		if _, err := New(&cfg); err == nil {
+			t.Errorf("ACL %+v accepted", cfg)
+		}
+	}
+}
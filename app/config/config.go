@@ -28,20 +28,289 @@ type ServerConfig struct {
 	CacheTTLSec        uint32 `yaml:"cache_ttl_seconds"`
 	BlacklistFilePath  string `yaml:"blacklist_file_path"`
 	KnownHostsFilePath string `yaml:"known_hosts_file_path"`
+	TCPIdleTimeout     int    `yaml:"tcp_idle_timeout_seconds"`
+	// FlattenCNAMEs lists the aliases of known_hosts answered with the
+	// records of the name their chain ends at, without the CNAME records
+	FlattenCNAMEs []string `yaml:"flatten_cnames"`
+	// AnswerOrder orders the addresses of a name in each response:
+	// round_robin, random or fixed
+	AnswerOrder string `yaml:"answer_order"`
 }
 
 func (c *ServerConfig) CacheTTLDuration() time.Duration {
 	return time.Duration(c.CacheTTLSec) * time.Second
 }
 
+func (c *ServerConfig) TCPIdleTimeoutDuration() time.Duration {
+	return time.Duration(c.TCPIdleTimeout) * time.Second
+}
+
 func (c *ServerConfig) EventQueueTimeoutDuration() time.Duration {
 	return time.Duration(c.EventQueueTimeout) * time.Millisecond
 }
 
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+	Path    string `yaml:"path"`
+}
+
+type QueryLogConfig struct {
+	Enabled       bool    `yaml:"enabled"`
+	Sink          string  `yaml:"sink"`
+	FilePath      string  `yaml:"file_path"`
+	MaxSizeMB     int     `yaml:"max_size_mb"`
+	MaxBackups    int     `yaml:"max_backups"`
+	SyslogNetwork string  `yaml:"syslog_network"`
+	SyslogAddress string  `yaml:"syslog_address"`
+	SampleRate    float64 `yaml:"sample_rate"`
+	BufferSize    int     `yaml:"buffer_size"`
+}
+
+type DnstapConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	SocketPath string `yaml:"socket_path"`
+	FilePath   string `yaml:"file_path"`
+	Identity   string `yaml:"identity"`
+	Version    string `yaml:"version"`
+}
+
+type RateLimitConfig struct {
+	Enabled                bool    `yaml:"enabled"`
+	QueriesPerSecond       float64 `yaml:"queries_per_second"`
+	Burst                  int     `yaml:"burst"`
+	SubnetQueriesPerSecond float64 `yaml:"subnet_queries_per_second"`
+	SubnetBurst            int     `yaml:"subnet_burst"`
+	IPv4Prefix             int     `yaml:"ipv4_prefix"`
+	IPv6Prefix             int     `yaml:"ipv6_prefix"`
+}
+
+type RRLConfig struct {
+	Enabled            bool    `yaml:"enabled"`
+	ResponsesPerSecond float64 `yaml:"responses_per_second"`
+	Burst              int     `yaml:"burst"`
+	Slip               int     `yaml:"slip"`
+	IPv4Prefix         int     `yaml:"ipv4_prefix"`
+	IPv6Prefix         int     `yaml:"ipv6_prefix"`
+}
+
+type ACLRule struct {
+	CIDR   string `yaml:"cidr"`
+	Action string `yaml:"action"`
+}
+
+type ACLConfig struct {
+	Default string    `yaml:"default"`
+	Rules   []ACLRule `yaml:"rules"`
+}
+
+type AccessConfig struct {
+	Queries   ACLConfig `yaml:"queries"`
+	Recursion ACLConfig `yaml:"recursion"`
+	LocalData ACLConfig `yaml:"local_data"`
+	// ClientSubnet lists the clients, such as forwarding resolvers, whose
+	// EDNS Client Subnet option selects the view in place of their address
+	ClientSubnet ACLConfig `yaml:"client_subnet"`
+	// ClientID lists the clients, such as the DNS forwarder of a router,
+	// whose MAC and CPE-ID options name the device of a query
+	ClientID ACLConfig `yaml:"client_id"`
+}
+
+type ZoneConfig struct {
+	Origin        string   `yaml:"origin"`
+	File          string   `yaml:"file"`
+	Journal       string   `yaml:"journal"`
+	AllowTransfer []string `yaml:"allow_transfer"`
+	TransferKeys  []string `yaml:"transfer_keys"`
+	Primary       string   `yaml:"primary"`
+	PrimaryKey    string   `yaml:"primary_key"`
+	AllowNotify   []string `yaml:"allow_notify"`
+	NotifyKeys    []string `yaml:"notify_keys"`
+	AllowUpdate   []string `yaml:"allow_update"`
+	UpdateKeys    []string `yaml:"update_keys"`
+	// DNSSEC signs the answers of a zone served from its file on the fly
+	DNSSEC ZoneDNSSECConfig `yaml:"dnssec"`
+}
+
+type ZoneDNSSECConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// NSEC3 denies names with hashed NSEC3 records instead of NSEC records
+	NSEC3                 bool              `yaml:"nsec3"`
+	SignatureValidityDays int               `yaml:"signature_validity_days"`
+	Keys                  []DNSSECKeyConfig `yaml:"keys"`
+}
+
+// DNSSECKeyConfig is a signing key of a zone, kept in a PEM file created
+// when missing. The key is published from Publish until Remove and signs
+// from Activate until Retire; unset times leave a phase open.
+type DNSSECKeyConfig struct {
+	File      string    `yaml:"file"`
+	Role      string    `yaml:"role"`
+	Algorithm uint8     `yaml:"algorithm"`
+	Publish   time.Time `yaml:"publish"`
+	Activate  time.Time `yaml:"activate"`
+	Retire    time.Time `yaml:"retire"`
+	Remove    time.Time `yaml:"remove"`
+}
+
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type DoTConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Port    int  `yaml:"port"`
+}
+
+type DoHConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Port     int    `yaml:"port"`
+	Path     string `yaml:"path"`
+	JSONPath string `yaml:"json_path"`
+}
+
+type DoQConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Port    int  `yaml:"port"`
+}
+
+type UpstreamConfig struct {
+	URL        string   `yaml:"url"`
+	ServerName string   `yaml:"server_name"`
+	PinSHA256  []string `yaml:"pin_sha256"`
+	PoolSize   int      `yaml:"pool_size"`
+}
+
+type ForwarderConfig struct {
+	Domain    string           `yaml:"domain"`
+	Upstreams []UpstreamConfig `yaml:"upstreams"`
+}
+
+type ResolverConfig struct {
+	Enabled           bool   `yaml:"enabled"`
+	RootHints         string `yaml:"root_hints"`
+	Port              int    `yaml:"port"`
+	QNAMEMinimisation bool   `yaml:"qname_minimisation"`
+	CacheSize         int    `yaml:"cache_size"`
+	// DNSSEC validates the answers against the trust anchors
+	DNSSEC       bool                `yaml:"dnssec"`
+	TrustAnchors []TrustAnchorConfig `yaml:"trust_anchors"`
+}
+
+// TrustAnchorConfig is the DS record of a key the chain of trust of a zone
+// and the zones below it starts from
+type TrustAnchorConfig struct {
+	Domain     string `yaml:"domain"`
+	KeyTag     uint16 `yaml:"key_tag"`
+	Algorithm  uint8  `yaml:"algorithm"`
+	DigestType uint8  `yaml:"digest_type"`
+	Digest     string `yaml:"digest"`
+}
+
+// HealthCheckConfig checks every address known_hosts gives a name. Failing
+// addresses are left out of the answers, and Fallback is answered instead
+// when they all fail.
+type HealthCheckConfig struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	Port     int      `yaml:"port"`
+	Path     string   `yaml:"path"`
+	Interval int      `yaml:"interval_seconds"`
+	Timeout  int      `yaml:"timeout_seconds"`
+	Fall     int      `yaml:"fall"`
+	Rise     int      `yaml:"rise"`
+	Fallback []string `yaml:"fallback"`
+}
+
+// ViewConfig answers the clients in MatchClients with their own known
+// hosts, blacklist, zones and upstreams. Whatever a view leaves unset is
+// taken from the top-level configuration.
+type ViewConfig struct {
+	Name               string           `yaml:"name"`
+	MatchClients       []string         `yaml:"match_clients"`
+	KnownHostsFilePath string           `yaml:"known_hosts_file_path"`
+	BlacklistFilePath  string           `yaml:"blacklist_file_path"`
+	Zones              []ZoneConfig     `yaml:"zones"`
+	Upstreams          []UpstreamConfig `yaml:"upstreams"`
+}
+
+// DomainListConfig is a named file of domains, one per line
+type DomainListConfig struct {
+	Name string `yaml:"name"`
+	File string `yaml:"file"`
+}
+
+// ClientGroupConfig filters the queries of a group of clients, matched by
+// address range, MAC address or identifier, with its own blocklists and
+// allowlists in place of the blacklist. Names on an allowlist are never
+// blocked.
+type ClientGroupConfig struct {
+	Name        string           `yaml:"name"`
+	Clients     []string         `yaml:"clients"`
+	MACs        []string         `yaml:"macs"`
+	Identifiers []string         `yaml:"identifiers"`
+	Blocklists  []string         `yaml:"blocklists"`
+	Allowlists  []string         `yaml:"allowlists"`
+	Schedules   []ScheduleConfig `yaml:"schedules"`
+}
+
+// ScheduleConfig enables more blocklists of a group on Days, every day
+// when empty, from From to To in local time, written as 15:04. A window
+// ending before it starts runs over midnight, and one ending when it
+// starts lasts the whole day.
+type ScheduleConfig struct {
+	Days       []string `yaml:"days"`
+	From       string   `yaml:"from"`
+	To         string   `yaml:"to"`
+	Blocklists []string `yaml:"blocklists"`
+}
+
+// ClientSubnetConfig sends the network of the client to the upstreams in
+// an EDNS Client Subnet option (RFC 7871), the address truncated to the
+// prefix length of its family. Disabled, no option is sent and the ones of
+// clients are stripped.
+type ClientSubnetConfig struct {
+	Enabled    bool `yaml:"enabled"`
+	IPv4Prefix int  `yaml:"ipv4_prefix"`
+	IPv6Prefix int  `yaml:"ipv6_prefix"`
+}
+
+type TSIGKeyConfig struct {
+	Name      string `yaml:"name"`
+	Algorithm string `yaml:"algorithm"`
+	Secret    string `yaml:"secret"`
+}
+
 type Config struct {
-	Redis  RedisConfig  `yaml:"memcached"`
-	UDP    UDPConfig    `yaml:"udp"`
-	Server ServerConfig `yaml:"server"`
+	Redis      RedisConfig       `yaml:"memcached"`
+	UDP        UDPConfig         `yaml:"udp"`
+	Server     ServerConfig      `yaml:"server"`
+	Metrics    MetricsConfig     `yaml:"metrics"`
+	QueryLog   QueryLogConfig    `yaml:"query_log"`
+	Dnstap     DnstapConfig      `yaml:"dnstap"`
+	RateLimit  RateLimitConfig   `yaml:"rate_limit"`
+	RRL        RRLConfig         `yaml:"rrl"`
+	Access     AccessConfig      `yaml:"access"`
+	Zones      []ZoneConfig      `yaml:"zones"`
+	TSIGKeys   []TSIGKeyConfig   `yaml:"tsig_keys"`
+	TLS        TLSConfig         `yaml:"tls"`
+	DoT        DoTConfig         `yaml:"dot"`
+	DoH        DoHConfig         `yaml:"doh"`
+	DoQ        DoQConfig         `yaml:"doq"`
+	Upstreams  []UpstreamConfig  `yaml:"upstreams"`
+	Forwarders []ForwarderConfig `yaml:"forwarders"`
+	Resolver   ResolverConfig    `yaml:"resolver"`
+	// HealthChecks withdraw the failing addresses of known hosts
+	HealthChecks []HealthCheckConfig `yaml:"health_checks"`
+	// ClientSubnet is the EDNS Client Subnet sent to the upstreams
+	ClientSubnet ClientSubnetConfig `yaml:"client_subnet"`
+	// Blocklists and Allowlists are the domain lists client groups enable
+	Blocklists   []DomainListConfig  `yaml:"blocklists"`
+	Allowlists   []DomainListConfig  `yaml:"allowlists"`
+	ClientGroups []ClientGroupConfig `yaml:"client_groups"`
+	// Views split the answers by client, the first matching view wins
+	Views []ViewConfig `yaml:"views"`
 }
 
 func Load() *Config {
@@ -64,6 +333,94 @@ func Load() *Config {
 			CacheTTLSec:        300,
 			BlacklistFilePath:  "blacklist",
 			KnownHostsFilePath: "known_hosts",
+			TCPIdleTimeout:     10,
+			AnswerOrder:        "round_robin",
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+			Address: "127.0.0.1:9153",
+			Path:    "/metrics",
+		},
+		QueryLog: QueryLogConfig{
+			Enabled:    false,
+			Sink:       "stdout",
+			FilePath:   "query.log",
+			MaxSizeMB:  100,
+			MaxBackups: 5,
+			SampleRate: 1,
+			BufferSize: 1000,
+		},
+		Dnstap: DnstapConfig{
+			Enabled:  false,
+			FilePath: "mydns.dnstap",
+			Identity: hostname(),
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:                false,
+			QueriesPerSecond:       50,
+			Burst:                  100,
+			SubnetQueriesPerSecond: 200,
+			SubnetBurst:            400,
+			IPv4Prefix:             24,
+			IPv6Prefix:             56,
+		},
+		RRL: RRLConfig{
+			Enabled:            false,
+			ResponsesPerSecond: 5,
+			Burst:              5,
+			Slip:               2,
+			IPv4Prefix:         24,
+			IPv6Prefix:         56,
+		},
+		DoT: DoTConfig{
+			Enabled: false,
+			Port:    853,
+		},
+		DoH: DoHConfig{
+			Enabled:  false,
+			Port:     443,
+			Path:     "/dns-query",
+			JSONPath: "/resolve",
+		},
+		DoQ: DoQConfig{
+			Enabled: false,
+			Port:    853,
+		},
+		Resolver: ResolverConfig{
+			Enabled:           false,
+			Port:              53,
+			QNAMEMinimisation: true,
+			CacheSize:         10000,
+			DNSSEC:            false,
+			// The root key signing keys KSK-2017 and KSK-2024
+			TrustAnchors: []TrustAnchorConfig{
+				{
+					Domain:     ".",
+					KeyTag:     20326,
+					Algorithm:  8,
+					DigestType: 2,
+					Digest:     "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+				},
+				{
+					Domain:     ".",
+					KeyTag:     38696,
+					Algorithm:  8,
+					DigestType: 2,
+					Digest:     "683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+				},
+			},
+		},
+		ClientSubnet: ClientSubnetConfig{
+			Enabled:    false,
+			IPv4Prefix: 24,
+			IPv6Prefix: 56,
+		},
+		Access: AccessConfig{
+			Queries:      ACLConfig{Default: "allow"},
+			Recursion:    ACLConfig{Default: "allow"},
+			LocalData:    ACLConfig{Default: "allow"},
+			ClientSubnet: ACLConfig{Default: "refuse"},
+			ClientID:     ACLConfig{Default: "refuse"},
 		},
 	}
 	yamlFile, err := os.ReadFile("config.yaml")
@@ -77,3 +434,11 @@ func Load() *Config {
 	}
 	return config
 }
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "mydns"
+	}
+	return name
+}
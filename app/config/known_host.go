@@ -3,12 +3,15 @@ package config
 import (
 	"bufio"
 	"fmt"
+	"net"
 	"os"
 	"strings"
 )
 
-func GetKnownHosts(filePath string) map[string]string {
-	knownHosts := make(map[string]string)
+// GetKnownHosts reads the known_hosts file: each name maps to one or more
+// addresses, or to the single name it is an alias of
+func GetKnownHosts(filePath string) map[string][]string {
+	knownHosts := make(map[string][]string)
 	file, err := os.Open(filePath)
 	if err != nil {
 		fmt.Println("Error opening known_hosts file:", err)
@@ -28,11 +31,20 @@ func GetKnownHosts(filePath string) map[string]string {
 			continue
 		}
 		parts := strings.Fields(line)
-		if len(parts) != 2 {
+		if len(parts) < 2 || len(parts) > 2 && !allAddresses(parts[1:]) {
 			fmt.Println("Invalid known_hosts file format")
 			continue
 		}
-		knownHosts[parts[0]] = parts[1]
+		knownHosts[parts[0]] = parts[1:]
 	}
 	return knownHosts
 }
+
+func allAddresses(values []string) bool {
+	for _, value := range values {
+		if net.ParseIP(value) == nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package dns
+
+import (
+	_type "com.sentry.dev/app/dns/type"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// builder appends the wire form of a DNS message to a buffer, compressing
+// domain names against the ones already written. A canonical builder writes
+// every name uncompressed and lowercased instead (RFC 4034 6.2).
+type builder struct {
+	buf         []byte
+	compression map[string]int
+	canonical   bool
+}
+
+func newBuilder(capacity int) *builder {
+	return &builder{
+		buf:         make([]byte, 0, capacity),
+		compression: make(map[string]int),
+	}
+}
+
+func (b *builder) uint8(v uint8) {
+	b.buf = append(b.buf, v)
+}
+
+func (b *builder) uint16(v uint16) {
+	b.buf = binary.BigEndian.AppendUint16(b.buf, v)
+}
+
+func (b *builder) uint32(v uint32) {
+	b.buf = binary.BigEndian.AppendUint32(b.buf, v)
+}
+
+func (b *builder) bytes(v []byte) {
+	b.buf = append(b.buf, v...)
+}
+
+// characterString writes a length-prefixed <character-string>
+func (b *builder) characterString(s string) error {
+	if len(s) > 255 {
+		return errors.New("character string too long")
+	}
+	b.buf = append(b.buf, byte(len(s)))
+	b.buf = append(b.buf, s...)
+	return nil
+}
+
+// name writes a domain name, replacing its longest already written suffix
+// with a pointer when compress is set
+func (b *builder) name(name string, compress bool) error {
+	if b.canonical {
+		name = strings.ToLower(name)
+		compress = false
+	}
+	labels := SplitName(name)
+	size := 1
+	for i, label := range labels {
+		if len(label) == 0 || len(label) > 63 {
+			return errors.New("invalid label length in " + name)
+		}
+		size += len(label) + 1
+		suffix := strings.ToLower(strings.Join(labels[i:], "."))
+		if ptr, ok := b.compression[suffix]; ok && compress {
+			b.uint16(_type.ToPointer(uint16(ptr)))
+			return nil
+		}
+		if len(b.buf) < 0x3FFF {
+			b.compression[suffix] = len(b.buf)
+		}
+		b.buf = append(b.buf, byte(len(label)))
+		b.buf = append(b.buf, label...)
+	}
+	if size > 255 {
+		return errors.New("name too long: " + name)
+	}
+	b.buf = append(b.buf, 0)
+	return nil
+}
+
+// question writes a question entry
+func (b *builder) question(q *Question) error {
+	if err := b.name(q.Name.String, true); err != nil {
+		return err
+	}
+	b.uint16(uint16(q.Type))
+	b.uint16(uint16(q.Class))
+	return nil
+}
+
+// record writes a resource record, filling in RDLENGTH once RDATA is written
+func (b *builder) record(r *Record) error {
+	if err := b.name(r.Name, true); err != nil {
+		return err
+	}
+	b.uint16(uint16(r.Type))
+	b.uint16(uint16(r.Class))
+	b.uint32(r.TTL)
+	lengthAt := len(b.buf)
+	b.uint16(0)
+	if err := r.Data.pack(b); err != nil {
+		return err
+	}
+	rdLength := len(b.buf) - lengthAt - 2
+	if rdLength > 0xFFFF {
+		return errors.New("rdata too long")
+	}
+	binary.BigEndian.PutUint16(b.buf[lengthAt:], uint16(rdLength))
+	return nil
+}
@@ -0,0 +1,37 @@
+package dns
+
+import (
+	"net"
+	"strings"
+)
+
+// Options naming the device a query is forwarded for, as added by dnsmasq
+// (--add-mac and --add-cpe-id)
+const (
+	OptionMAC   = 65001
+	OptionCPEID = 65074
+)
+
+// FindMAC returns the hardware address of the MAC option of an OPT record,
+// sent as 6 octets or as text, nil when there is none or it is malformed
+func FindMAC(opt *Record) net.HardwareAddr {
+	data, ok := FindOption(opt, OptionMAC)
+	if !ok {
+		return nil
+	}
+	if len(data) == 6 {
+		return net.HardwareAddr(data)
+	}
+	mac, err := net.ParseMAC(string(data))
+	if err != nil || len(mac) != 6 {
+		return nil
+	}
+	return mac
+}
+
+// FindCPEID returns the identifier of the CPE-ID option of an OPT record,
+// empty when there is none
+func FindCPEID(opt *Record) string {
+	data, _ := FindOption(opt, OptionCPEID)
+	return strings.TrimSpace(string(data))
+}
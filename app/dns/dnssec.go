@@ -0,0 +1,405 @@
+package dns
+
+import (
+	_type "com.sentry.dev/app/dns/type"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Base32Hex is the encoding of hashed owner names in NSEC3 records
+// (RFC 5155 1.3), without padding
+var Base32Hex = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// DNSKEY flags (RFC 4034 2.1.1)
+const (
+	FlagZoneKey     uint16 = 0x0100
+	FlagSecureEntry uint16 = 0x0001
+)
+
+// FlagOptOut marks an NSEC3 record whose span may hold unsigned
+// delegations (RFC 5155 3.1.2.1)
+const FlagOptOut uint8 = 0x01
+
+// dnskeyProtocol is the only valid protocol of a DNSKEY (RFC 4034 2.1.2)
+const dnskeyProtocol uint8 = 3
+
+// signatureTimeFmt is the presentation form of RRSIG times
+const signatureTimeFmt = "20060102150405"
+
+// DS refers to a DNSKEY of a child zone by its digest (RFC 4034 5)
+type DS struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     []byte
+}
+
+func (d *DS) String() string {
+	return fmt.Sprintf("%d %d %d %s", d.KeyTag, d.Algorithm, d.DigestType, strings.ToUpper(hex.EncodeToString(d.Digest)))
+}
+
+func (d *DS) pack(b *builder) error {
+	b.uint16(d.KeyTag)
+	b.uint8(d.Algorithm)
+	b.uint8(d.DigestType)
+	b.bytes(d.Digest)
+	return nil
+}
+
+func (d *DS) unpack(r *reader, end int) (err error) {
+	if d.KeyTag, err = r.uint16(); err != nil {
+		return
+	}
+	if d.Algorithm, err = r.uint8(); err != nil {
+		return
+	}
+	if d.DigestType, err = r.uint8(); err != nil {
+		return
+	}
+	d.Digest, err = r.bytes(end - r.off)
+	return
+}
+
+// DNSKEY is a public key of a zone (RFC 4034 2)
+type DNSKEY struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey []byte
+}
+
+func (d *DNSKEY) String() string {
+	return fmt.Sprintf("%d %d %d %s", d.Flags, d.Protocol, d.Algorithm, base64.StdEncoding.EncodeToString(d.PublicKey))
+}
+
+func (d *DNSKEY) pack(b *builder) error {
+	b.uint16(d.Flags)
+	b.uint8(d.Protocol)
+	b.uint8(d.Algorithm)
+	b.bytes(d.PublicKey)
+	return nil
+}
+
+func (d *DNSKEY) unpack(r *reader, end int) (err error) {
+	if d.Flags, err = r.uint16(); err != nil {
+		return
+	}
+	if d.Protocol, err = r.uint8(); err != nil {
+		return
+	}
+	if d.Algorithm, err = r.uint8(); err != nil {
+		return
+	}
+	d.PublicKey, err = r.bytes(end - r.off)
+	return
+}
+
+// IsZoneKey reports whether the key may verify the signatures of a zone,
+// the only use of DNSKEY records
+func (d *DNSKEY) IsZoneKey() bool {
+	return d.Flags&FlagZoneKey != 0 && d.Protocol == dnskeyProtocol
+}
+
+// RRSIG is the signature of an RRset (RFC 4034 3). Expiration and Inception
+// are seconds since the epoch, compared in serial number arithmetic.
+type RRSIG struct {
+	TypeCovered _type.RecordType
+	Algorithm   uint8
+	Labels      uint8
+	OriginalTTL uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string
+	Signature   []byte
+}
+
+func (d *RRSIG) String() string {
+	return fmt.Sprintf("%s %d %d %d %s %s %d %s %s",
+		d.TypeCovered, d.Algorithm, d.Labels, d.OriginalTTL,
+		time.Unix(int64(d.Expiration), 0).UTC().Format(signatureTimeFmt),
+		time.Unix(int64(d.Inception), 0).UTC().Format(signatureTimeFmt),
+		d.KeyTag, Fqdn(d.SignerName), base64.StdEncoding.EncodeToString(d.Signature))
+}
+
+func (d *RRSIG) pack(b *builder) error {
+	b.uint16(uint16(d.TypeCovered))
+	b.uint8(d.Algorithm)
+	b.uint8(d.Labels)
+	b.uint32(d.OriginalTTL)
+	b.uint32(d.Expiration)
+	b.uint32(d.Inception)
+	b.uint16(d.KeyTag)
+	if err := b.name(d.SignerName, false); err != nil {
+		return err
+	}
+	b.bytes(d.Signature)
+	return nil
+}
+
+func (d *RRSIG) unpack(r *reader, end int) (err error) {
+	covered, err := r.uint16()
+	if err != nil {
+		return
+	}
+	d.TypeCovered = _type.RecordType(covered)
+	if d.Algorithm, err = r.uint8(); err != nil {
+		return
+	}
+	if d.Labels, err = r.uint8(); err != nil {
+		return
+	}
+	for _, v := range []*uint32{&d.OriginalTTL, &d.Expiration, &d.Inception} {
+		if *v, err = r.uint32(); err != nil {
+			return
+		}
+	}
+	if d.KeyTag, err = r.uint16(); err != nil {
+		return
+	}
+	if d.SignerName, err = r.name(); err != nil {
+		return
+	}
+	d.Signature, err = r.bytes(end - r.off)
+	return
+}
+
+// ParseSignatureTime parses an RRSIG time in its YYYYMMDDHHmmSS form or
+// as seconds since the epoch (RFC 4034 3.2)
+func ParseSignatureTime(s string) (uint32, error) {
+	if len(s) == len(signatureTimeFmt) {
+		t, err := time.Parse(signatureTimeFmt, s)
+		if err != nil {
+			return 0, err
+		}
+		return uint32(t.Unix()), nil
+	}
+	var n uint32
+	if _, err := fmt.Sscan(s, &n); err != nil {
+		return 0, fmt.Errorf("invalid signature time %q", s)
+	}
+	return n, nil
+}
+
+// NSEC links an owner name to the next one of the zone in canonical order
+// and lists the types present at the owner (RFC 4034 4)
+type NSEC struct {
+	NextDomain string
+	Types      []_type.RecordType
+}
+
+func (d *NSEC) String() string {
+	return Fqdn(d.NextDomain) + typesString(d.Types)
+}
+
+// pack never lowercases the next name, not even in canonical form
+// (RFC 6840 5.1)
+func (d *NSEC) pack(b *builder) error {
+	next, err := PackName(d.NextDomain)
+	if err != nil {
+		return err
+	}
+	b.bytes(next)
+	b.bytes(packTypeBitmap(d.Types))
+	return nil
+}
+
+func (d *NSEC) unpack(r *reader, end int) (err error) {
+	if d.NextDomain, err = r.name(); err != nil {
+		return
+	}
+	d.Types, err = unpackTypeBitmap(r, end)
+	return
+}
+
+// NSEC3 links the hash of an owner name to the next hash of the zone and
+// lists the types present at the owner (RFC 5155 3)
+type NSEC3 struct {
+	HashAlgorithm uint8
+	Flags         uint8
+	Iterations    uint16
+	Salt          []byte
+	NextHashed    []byte
+	Types         []_type.RecordType
+}
+
+func (d *NSEC3) String() string {
+	return fmt.Sprintf("%d %d %d %s %s%s", d.HashAlgorithm, d.Flags, d.Iterations, saltString(d.Salt),
+		Base32Hex.EncodeToString(d.NextHashed), typesString(d.Types))
+}
+
+func (d *NSEC3) pack(b *builder) error {
+	if len(d.Salt) > 255 || len(d.NextHashed) > 255 {
+		return errors.New("NSEC3 salt or hash too long")
+	}
+	b.uint8(d.HashAlgorithm)
+	b.uint8(d.Flags)
+	b.uint16(d.Iterations)
+	b.uint8(uint8(len(d.Salt)))
+	b.bytes(d.Salt)
+	b.uint8(uint8(len(d.NextHashed)))
+	b.bytes(d.NextHashed)
+	b.bytes(packTypeBitmap(d.Types))
+	return nil
+}
+
+func (d *NSEC3) unpack(r *reader, end int) (err error) {
+	if d.HashAlgorithm, err = r.uint8(); err != nil {
+		return
+	}
+	if d.Flags, err = r.uint8(); err != nil {
+		return
+	}
+	if d.Iterations, err = r.uint16(); err != nil {
+		return
+	}
+	size, err := r.uint8()
+	if err != nil {
+		return
+	}
+	if d.Salt, err = r.bytes(int(size)); err != nil {
+		return
+	}
+	if size, err = r.uint8(); err != nil {
+		return
+	}
+	if d.NextHashed, err = r.bytes(int(size)); err != nil {
+		return
+	}
+	d.Types, err = unpackTypeBitmap(r, end)
+	return
+}
+
+// NSEC3PARAM gives the parameters an authoritative server hashes names
+// with (RFC 5155 4)
+type NSEC3PARAM struct {
+	HashAlgorithm uint8
+	Flags         uint8
+	Iterations    uint16
+	Salt          []byte
+}
+
+func (d *NSEC3PARAM) String() string {
+	return fmt.Sprintf("%d %d %d %s", d.HashAlgorithm, d.Flags, d.Iterations, saltString(d.Salt))
+}
+
+func (d *NSEC3PARAM) pack(b *builder) error {
+	if len(d.Salt) > 255 {
+		return errors.New("NSEC3PARAM salt too long")
+	}
+	b.uint8(d.HashAlgorithm)
+	b.uint8(d.Flags)
+	b.uint16(d.Iterations)
+	b.uint8(uint8(len(d.Salt)))
+	b.bytes(d.Salt)
+	return nil
+}
+
+func (d *NSEC3PARAM) unpack(r *reader, end int) (err error) {
+	if d.HashAlgorithm, err = r.uint8(); err != nil {
+		return
+	}
+	if d.Flags, err = r.uint8(); err != nil {
+		return
+	}
+	if d.Iterations, err = r.uint16(); err != nil {
+		return
+	}
+	size, err := r.uint8()
+	if err != nil {
+		return
+	}
+	d.Salt, err = r.bytes(int(size))
+	return
+}
+
+// HasType reports whether t is among the types of an NSEC or NSEC3 bitmap
+func HasType(types []_type.RecordType, t _type.RecordType) bool {
+	for _, v := range types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// packTypeBitmap encodes types as windows of up to 256 bits (RFC 4034 4.1.2)
+func packTypeBitmap(types []_type.RecordType) []byte {
+	sorted := append([]_type.RecordType(nil), types...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var out []byte
+	var window [32]byte
+	current, length := -1, 0
+	flush := func() {
+		if current >= 0 {
+			out = append(out, byte(current), byte(length))
+			out = append(out, window[:length]...)
+		}
+		window = [32]byte{}
+		length = 0
+	}
+	for _, t := range sorted {
+		if int(t>>8) != current {
+			flush()
+			current = int(t >> 8)
+		}
+		bit := int(t & 0xFF)
+		window[bit/8] |= 0x80 >> (bit % 8)
+		length = max(length, bit/8+1)
+	}
+	flush()
+	return out
+}
+
+func unpackTypeBitmap(r *reader, end int) ([]_type.RecordType, error) {
+	var types []_type.RecordType
+	last := -1
+	for r.off < end {
+		window, err := r.uint8()
+		if err != nil {
+			return nil, err
+		}
+		length, err := r.uint8()
+		if err != nil {
+			return nil, err
+		}
+		if int(window) <= last || length == 0 || length > 32 {
+			return nil, errors.New("invalid type bitmap")
+		}
+		last = int(window)
+		bits, err := r.bytes(int(length))
+		if err != nil {
+			return nil, err
+		}
+		for i, octet := range bits {
+			for bit := 0; bit < 8; bit++ {
+				if octet&(0x80>>bit) != 0 {
+					types = append(types, _type.RecordType(int(window)<<8|i*8+bit))
+				}
+			}
+		}
+	}
+	return types, nil
+}
+
+func typesString(types []_type.RecordType) string {
+	var sb strings.Builder
+	for _, t := range types {
+		sb.WriteByte(' ')
+		sb.WriteString(t.String())
+	}
+	return sb.String()
+}
+
+func saltString(salt []byte) string {
+	if len(salt) == 0 {
+		return "-"
+	}
+	return strings.ToUpper(hex.EncodeToString(salt))
+}
@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// OptionClientSubnet is the code of the EDNS Client Subnet option (RFC 7871)
+const OptionClientSubnet = 8
+
+// Address families of the client subnet option
+const (
+	familyIPv4 = 1
+	familyIPv6 = 2
+)
+
+// ClientSubnet is the network a query is asked on behalf of (RFC 7871 6)
+type ClientSubnet struct {
+	// Prefix is the source address truncated to the source prefix length
+	Prefix netip.Prefix
+	// Scope is the prefix length the answer holds for, set in responses
+	Scope int
+}
+
+// FindClientSubnet returns the client subnet option of an OPT record, nil
+// when it has none or a malformed one
+func FindClientSubnet(opt *Record) *ClientSubnet {
+	data, ok := FindOption(opt, OptionClientSubnet)
+	if !ok {
+		return nil
+	}
+	return parseClientSubnet(data)
+}
+
+// parseClientSubnet decodes the data of a client subnet option. The address
+// must take no more octets than the source prefix length needs, with the
+// bits past it cleared.
+func parseClientSubnet(b []byte) *ClientSubnet {
+	if len(b) < 4 {
+		return nil
+	}
+	source, scope := int(b[2]), int(b[3])
+	var size int
+	switch binary.BigEndian.Uint16(b) {
+	case familyIPv4:
+		size = 4
+	case familyIPv6:
+		size = 16
+	default:
+		return nil
+	}
+	address := b[4:]
+	if source > size*8 || scope > size*8 || len(address) != (source+7)/8 {
+		return nil
+	}
+	var ip [16]byte
+	copy(ip[:], address)
+	addr := netip.AddrFrom16(ip)
+	if size == 4 {
+		addr = netip.AddrFrom4([4]byte(ip[:4]))
+	}
+	prefix := netip.PrefixFrom(addr, source)
+	if prefix.Masked() != prefix {
+		return nil
+	}
+	return &ClientSubnet{Prefix: prefix, Scope: scope}
+}
+
+// Pack encodes c as an EDNS option, the address cut to the octets its
+// source prefix length needs
+func (c *ClientSubnet) Pack() []byte {
+	prefix := c.Prefix.Masked()
+	addr := prefix.Addr()
+	family := familyIPv6
+	if addr.Is4() {
+		family = familyIPv4
+	}
+	n := (prefix.Bits() + 7) / 8
+	b := make([]byte, 8+n)
+	binary.BigEndian.PutUint16(b, OptionClientSubnet)
+	binary.BigEndian.PutUint16(b[2:], uint16(4+n))
+	binary.BigEndian.PutUint16(b[4:], uint16(family))
+	b[6], b[7] = byte(prefix.Bits()), byte(c.Scope)
+	copy(b[8:], addr.AsSlice()[:n])
+	return b
+}
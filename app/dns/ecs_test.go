@@ -0,0 +1,67 @@
+package dns
+
+import (
+	_type "com.sentry.dev/app/dns/type"
+	"net/netip"
+	"testing"
+)
+
+func TestClientSubnetRoundTrip(t *testing.T) {
+	for _, c := range []ClientSubnet{
+		{Prefix: netip.MustParsePrefix("192.0.2.0/24")},
+		{Prefix: netip.MustParsePrefix("198.51.100.128/25"), Scope: 24},
+		{Prefix: netip.MustParsePrefix("2001:db8:1200::/40"), Scope: 56},
+		{Prefix: netip.MustParsePrefix("0.0.0.0/0")},
+	} {
+		opt := NewOPT(EDNSPayload, false)
+		opt.Data = &Unknown{Data: c.Pack()}
+		msg := &Message{
+			Header: &Header{ID: 1},
+			Questions: []*Question{{
+				Name:  &Addr{String: "example"},
+				Type:  _type.TypeA,
+				Class: _type.ClassIN,
+			}},
+			Additional: []*Record{opt},
+		}
+		wire, err := msg.Pack(512)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unpacked, err := UnpackMessage(wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := FindClientSubnet(FindOPT(unpacked.Additional))
+		if got == nil || *got != c {
+			t.Errorf("%v scope %d came back as %v", c.Prefix, c.Scope, got)
+		}
+	}
+}
+
+func TestClientSubnetPackTruncatesAddress(t *testing.T) {
+	c := &ClientSubnet{Prefix: netip.MustParsePrefix("192.0.2.77/20")}
+	want := []byte{0, 8, 0, 7, 0, 1, 20, 0, 192, 0, 0}
+	if got := c.Pack(); string(got) != string(want) {
+		t.Errorf("packed %v, want %v", got, want)
+	}
+}
+
+func TestParseClientSubnetRejectsMalformed(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"short":                {0, 1, 24},
+		"unknown family":       {0, 3, 8, 0, 10},
+		"source too long":      {0, 1, 33, 0, 1, 2, 3, 4, 5},
+		"scope too long":       {0, 1, 24, 33, 192, 0, 2},
+		"address too long":     {0, 1, 16, 0, 192, 0, 2},
+		"address too short":    {0, 1, 24, 0, 192, 0},
+		"bits past the prefix": {0, 1, 20, 0, 192, 0, 18},
+	} {
+		if c := parseClientSubnet(data); c != nil {
+			t.Errorf("%s: parsed as %v", name, c.Prefix)
+		}
+	}
+	if FindClientSubnet(nil) != nil || FindClientSubnet(NewOPT(EDNSPayload, false)) != nil {
+		t.Error("found a client subnet without the option")
+	}
+}
@@ -0,0 +1,68 @@
+package dns
+
+import (
+	_type "com.sentry.dev/app/dns/type"
+	"encoding/binary"
+)
+
+const (
+	// EDNSPayload is the UDP payload size advertised in OPT records, small
+	// enough to avoid IP fragmentation
+	EDNSPayload = 1232
+	// ednsDO is the DNSSEC OK bit of the OPT record TTL (RFC 3225)
+	ednsDO = 0x8000
+)
+
+// NewOPT returns the OPT pseudo-record of EDNS(0) (RFC 6891) advertising
+// payload, asking for the DNSSEC records when dnssecOK is set
+func NewOPT(payload uint16, dnssecOK bool) *Record {
+	opt := &Record{
+		Type:  _type.TypeOPT,
+		Class: _type.RecordClass(payload),
+		Data:  &Unknown{},
+	}
+	if dnssecOK {
+		opt.TTL |= ednsDO
+	}
+	return opt
+}
+
+// FindOPT returns the OPT record among records, nil when there is none
+func FindOPT(records []*Record) *Record {
+	for _, r := range records {
+		if r.Type == _type.TypeOPT {
+			return r
+		}
+	}
+	return nil
+}
+
+// DNSSECOK reports whether the DO bit of an OPT record is set, false for a
+// message without one
+func DNSSECOK(opt *Record) bool {
+	return opt != nil && opt.TTL&ednsDO != 0
+}
+
+// FindOption returns the data of the first option of an OPT record with
+// code, false when there is none or the options are malformed
+func FindOption(opt *Record, code uint16) ([]byte, bool) {
+	if opt == nil {
+		return nil, false
+	}
+	data, ok := opt.Data.(*Unknown)
+	if !ok {
+		return nil, false
+	}
+	options := data.Data
+	for len(options) >= 4 {
+		length := int(binary.BigEndian.Uint16(options[2:]))
+		if len(options) < 4+length {
+			return nil, false
+		}
+		if binary.BigEndian.Uint16(options) == code {
+			return options[4 : 4+length], true
+		}
+		options = options[4+length:]
+	}
+	return nil, false
+}
@@ -22,6 +22,13 @@ type Header struct {
 	AdditionalCount     uint16 // 16 bits: Number of additional records
 }
 
+// Bits of Header.Reserved defined by DNSSEC (RFC 4035 3.2), the third
+// one, Z, staying zero
+const (
+	FlagAD uint8 = 0b010 // Authentic Data: the answer was validated
+	FlagCD uint8 = 0b001 // Checking Disabled: the client validates itself
+)
+
 func (h *Header) Size() int {
 	return 12
 }
@@ -1,66 +1,248 @@
 package dns
 
 import (
+	_type "com.sentry.dev/app/dns/type"
+	"encoding/binary"
 	"errors"
 )
 
 // Message represents a DNS message
 type Message struct {
-	Header    *Header
-	Questions []*Question
-	Answers   []*Answer
-	calcSize  int
+	Header     *Header
+	Questions  []*Question
+	Answers    []*Record
+	Authority  []*Record
+	Additional []*Record
 }
 
-// Size in byte
-func (m *Message) Size() int {
-	if m.calcSize == 0 {
-		m.calcSize = m.Header.Size()
-		for _, q := range m.Questions {
-			m.calcSize += q.Size()
-		}
-		for _, a := range m.Answers {
-			m.calcSize += a.Size()
+// UnpackMessage parses a complete DNS message, resource records included
+func UnpackMessage(buf []byte) (*Message, error) {
+	header, _, err := parseHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+	m := &Message{Header: header}
+	r := &reader{msg: buf, off: header.Size()}
+	for i := uint16(0); i < header.QuestionCount; i++ {
+		q, err := r.question()
+		if err != nil {
+			return nil, err
 		}
+		m.Questions = append(m.Questions, q)
+	}
+	if m.Answers, err = r.records(header.AnswerCount); err != nil {
+		return nil, err
+	}
+	if m.Authority, err = r.records(header.AuthorityCount); err != nil {
+		return nil, err
+	}
+	if m.Additional, err = r.records(header.AdditionalCount); err != nil {
+		return nil, err
 	}
-	return m.calcSize
+	return m, nil
 }
 
-// ParseMessage parses a complete DNS message
-func ParseMessage(buf []byte, bufLimit int) (*Header, []*Question, error) {
-	header, remaining, err := parseHeader(buf)
-	if err != nil {
-		return nil, nil, err
+// Pack encodes the message with name compression, keeping it within limit
+// bytes. When the answer or authority section does not fit, both are left
+// out and the Truncation bit is set; additional records that do not fit are
+// silently dropped. The section counts of the Header are updated.
+func (m *Message) Pack(limit int) ([]byte, error) {
+	b := newBuilder(limit)
+	b.bytes(make([]byte, m.Header.Size()))
+
+	for _, q := range m.Questions {
+		if err := b.question(q); err != nil {
+			return nil, err
+		}
+	}
+	if len(b.buf) > limit {
+		return nil, errors.New("buffer Size too small")
 	}
+	m.Header.QuestionCount = uint16(len(m.Questions))
+	m.Header.AnswerCount = 0
+	m.Header.AuthorityCount = 0
+	m.Header.AdditionalCount = 0
 
-	questions, err := parseQuestions(remaining, bufLimit, header.QuestionCount)
-	if err != nil {
-		return header, nil, err
+	questionsEnd := len(b.buf)
+	fits := true
+	for _, r := range m.Answers {
+		if err := b.record(r); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range m.Authority {
+		if err := b.record(r); err != nil {
+			return nil, err
+		}
+	}
+	if len(b.buf) > limit {
+		b.buf = b.buf[:questionsEnd]
+		m.Header.Truncation = true
+		fits = false
+	} else {
+		m.Header.AnswerCount = uint16(len(m.Answers))
+		m.Header.AuthorityCount = uint16(len(m.Authority))
+	}
+
+	for _, r := range m.Additional {
+		if !fits {
+			break
+		}
+		end := len(b.buf)
+		if err := b.record(r); err != nil {
+			return nil, err
+		}
+		if len(b.buf) > limit {
+			b.buf = b.buf[:end]
+			break
+		}
+		m.Header.AdditionalCount++
 	}
 
-	return header, questions, nil
+	if _, err := m.Header.WriteTo(b.buf); err != nil {
+		return nil, err
+	}
+	return b.buf, nil
 }
 
-// WriteTo a byte buffer with content of a DNS Message
-func (m *Message) WriteTo(buf []byte) (remaining []byte, err error) {
-	if len(buf) < m.Size() {
-		return buf, errors.New("buffer Size too small")
+// PackStream encodes records as the answer section of as many messages of at
+// most limit bytes as needed, the way zone transfers are streamed. Only the
+// first message carries the questions.
+func (m *Message) PackStream(records []*Record, limit int) ([][]byte, error) {
+	var (
+		messages [][]byte
+		b        *builder
+		count    uint16
+	)
+	start := func(questions []*Question) error {
+		b = newBuilder(limit)
+		b.bytes(make([]byte, m.Header.Size()))
+		for _, q := range questions {
+			if err := b.question(q); err != nil {
+				return err
+			}
+		}
+		count = 0
+		return nil
 	}
-	remaining, err = m.Header.WriteTo(buf)
+	finish := func(questions []*Question) error {
+		header := *m.Header
+		header.QuestionCount = uint16(len(questions))
+		header.AnswerCount = count
+		header.AuthorityCount = 0
+		header.AdditionalCount = 0
+		if _, err := header.WriteTo(b.buf); err != nil {
+			return err
+		}
+		messages = append(messages, b.buf)
+		return nil
+	}
+
+	questions := m.Questions
+	if err := start(questions); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		end := len(b.buf)
+		if err := b.record(r); err != nil {
+			return nil, err
+		}
+		if len(b.buf) <= limit && count < 0xFFFF {
+			count++
+			continue
+		}
+		if count == 0 {
+			return nil, errors.New("record too large for a message: " + Fqdn(r.Name))
+		}
+		b.buf = b.buf[:end]
+		if err := finish(questions); err != nil {
+			return nil, err
+		}
+		questions = nil
+		if err := start(questions); err != nil {
+			return nil, err
+		}
+		if err := b.record(r); err != nil {
+			return nil, err
+		}
+		if len(b.buf) > limit {
+			return nil, errors.New("record too large for a message: " + Fqdn(r.Name))
+		}
+		count = 1
+	}
+	if err := finish(questions); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// StripTSIG splits a message into its TSIG record and the message as it was
+// before signing: without the record and with the additional count
+// decreased. The record is nil when the message is not signed, and a TSIG
+// record anywhere but last is an error.
+func StripTSIG(buf []byte) ([]byte, *Record, error) {
+	header, _, err := parseHeader(buf)
 	if err != nil {
-		return remaining, err
+		return nil, nil, err
 	}
-	for _, q := range m.Questions {
-		remaining, err = q.WriteTo(remaining)
-		if err != nil {
-			return remaining, err
+	r := &reader{msg: buf, off: header.Size()}
+	for i := uint16(0); i < header.QuestionCount; i++ {
+		if _, err = r.question(); err != nil {
+			return nil, nil, err
 		}
 	}
-	for _, a := range m.Answers {
-		remaining, err = a.WriteTo(remaining)
+	count := int(header.AnswerCount) + int(header.AuthorityCount) + int(header.AdditionalCount)
+	for i := 0; i < count; i++ {
+		start := r.off
+		record, err := r.record()
 		if err != nil {
-			return remaining, err
+			return nil, nil, err
+		}
+		if record.Type != _type.TypeTSIG {
+			continue
 		}
+		if i != count-1 || header.AdditionalCount == 0 {
+			return nil, nil, errors.New("TSIG record is not the last of the message")
+		}
+		stripped := append([]byte(nil), buf[:start]...)
+		binary.BigEndian.PutUint16(stripped[10:], header.AdditionalCount-1)
+		return stripped, record, nil
+	}
+	return buf, nil, nil
+}
+
+// AppendRecord appends r, uncompressed, to the additional section of an
+// encoded message
+func AppendRecord(buf []byte, r *Record) ([]byte, error) {
+	if len(buf) < 12 {
+		return nil, errors.New("buffer too small for Header")
+	}
+	b := newBuilder(len(buf) + 512)
+	b.bytes(buf)
+	if err := b.record(r); err != nil {
+		return nil, err
+	}
+	count := binary.BigEndian.Uint16(b.buf[10:])
+	binary.BigEndian.PutUint16(b.buf[10:], count+1)
+	return b.buf, nil
+}
+
+// PackName returns the uncompressed wire form of a name
+func PackName(name string) ([]byte, error) {
+	b := newBuilder(len(name) + 2)
+	if err := b.name(name, false); err != nil {
+		return nil, err
+	}
+	return b.buf, nil
+}
+
+// PackRData returns the wire form of RDATA with uncompressed names, the
+// names lowercased in canonical form as signatures cover them (RFC 4034 6.2)
+func PackRData(data RData, canonical bool) ([]byte, error) {
+	b := newBuilder(256)
+	b.canonical = canonical
+	if err := data.pack(b); err != nil {
+		return nil, err
 	}
-	return remaining, nil
+	return b.buf, nil
 }
@@ -0,0 +1,67 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// CanonicalName lower-cases a domain name and strips its trailing dot. The
+// root is the empty string.
+func CanonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Fqdn returns the presentation form of a name, with its trailing dot
+func Fqdn(name string) string {
+	return name + "."
+}
+
+// SplitName returns the labels of a name, none for the root
+func SplitName(name string) []string {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
+// Parent strips the first label of a name
+func Parent(name string) string {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
+// IsSubDomain reports whether child equals parent or lies below it,
+// comparing names case-insensitively
+func IsSubDomain(child, parent string) bool {
+	child = CanonicalName(child)
+	parent = CanonicalName(parent)
+	if parent == "" || child == parent {
+		return true
+	}
+	return strings.HasSuffix(child, "."+parent)
+}
+
+// ReverseName returns the name under in-addr.arpa or ip6.arpa that PTR
+// records of an address are found at (RFC 1035 3.5, RFC 3596 2.5)
+func ReverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", v4[3], v4[2], v4[1], v4[0])
+	}
+	if len(ip) != net.IPv6len {
+		return ""
+	}
+	const hex = "0123456789abcdef"
+	var b strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(hex[ip[i]&0xF])
+		b.WriteByte('.')
+		b.WriteByte(hex[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa")
+	return b.String()
+}
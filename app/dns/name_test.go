@@ -0,0 +1,26 @@
+package dns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReverseName(t *testing.T) {
+	for _, c := range []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.1", "1.2.0.192.in-addr.arpa"},
+		{"::ffff:192.0.2.1", "1.2.0.192.in-addr.arpa"},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+		{"2001:DB8:ABCD:12::F0", "0.f.0.0.0.0.0.0.0.0.0.0.0.0.0.0.2.1.0.0.d.c.b.a.8.b.d.0.1.0.0.2.ip6.arpa"},
+		{"::", "0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa"},
+	} {
+		if got := ReverseName(net.ParseIP(c.ip)); got != c.want {
+			t.Errorf("%s: %s, want %s", c.ip, got, c.want)
+		}
+	}
+	if got := ReverseName(net.IP{192, 0, 2}); got != "" {
+		t.Errorf("malformed address: %q", got)
+	}
+}
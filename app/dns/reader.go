@@ -0,0 +1,200 @@
+package dns
+
+import (
+	_type "com.sentry.dev/app/dns/type"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// maxPointers bounds the compression pointers followed while reading a name
+const maxPointers = 32
+
+// reader decodes the wire form of a DNS message, following compression
+// pointers back into the whole message
+type reader struct {
+	msg []byte
+	off int
+}
+
+var errShortBuffer = errors.New("buffer too small")
+
+func (r *reader) uint8() (uint8, error) {
+	if r.off+1 > len(r.msg) {
+		return 0, errShortBuffer
+	}
+	v := r.msg[r.off]
+	r.off++
+	return v, nil
+}
+
+func (r *reader) uint16() (uint16, error) {
+	if r.off+2 > len(r.msg) {
+		return 0, errShortBuffer
+	}
+	v := binary.BigEndian.Uint16(r.msg[r.off:])
+	r.off += 2
+	return v, nil
+}
+
+func (r *reader) uint32() (uint32, error) {
+	if r.off+4 > len(r.msg) {
+		return 0, errShortBuffer
+	}
+	v := binary.BigEndian.Uint32(r.msg[r.off:])
+	r.off += 4
+	return v, nil
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.off+n > len(r.msg) {
+		return nil, errShortBuffer
+	}
+	v := make([]byte, n)
+	copy(v, r.msg[r.off:])
+	r.off += n
+	return v, nil
+}
+
+// characterString reads a length-prefixed <character-string>
+func (r *reader) characterString() (string, error) {
+	n, err := r.uint8()
+	if err != nil {
+		return "", err
+	}
+	v, err := r.bytes(int(n))
+	return string(v), err
+}
+
+// name reads a possibly compressed domain name, without its trailing dot
+func (r *reader) name() (string, error) {
+	var labels []string
+	off := r.off
+	jumped := false
+	for pointers := 0; ; {
+		if off >= len(r.msg) {
+			return "", errShortBuffer
+		}
+		length := r.msg[off]
+		if _type.ToLabel(length) == _type.POINTER {
+			if off+2 > len(r.msg) {
+				return "", errors.New("buffer too small for pointer")
+			}
+			target := int(binary.BigEndian.Uint16(r.msg[off:]) & 0x3FFF)
+			if target >= off {
+				return "", errors.New("invalid pointer offset")
+			}
+			if pointers++; pointers > maxPointers {
+				return "", errors.New("too many compression pointers")
+			}
+			if !jumped {
+				r.off = off + 2
+				jumped = true
+			}
+			off = target
+			continue
+		}
+		if length > 63 {
+			return "", errors.New("unsupported label type")
+		}
+		if length == 0 {
+			if !jumped {
+				r.off = off + 1
+			}
+			break
+		}
+		if off+1+int(length) > len(r.msg) {
+			return "", errors.New("buffer too small for label")
+		}
+		labels = append(labels, string(r.msg[off+1:off+1+int(length)]))
+		off += 1 + int(length)
+	}
+	name := strings.Join(labels, ".")
+	if len(name) > 254 {
+		return "", errors.New("name too long")
+	}
+	return name, nil
+}
+
+// question reads a question entry
+func (r *reader) question() (*Question, error) {
+	start := r.off
+	name, err := r.name()
+	if err != nil {
+		return nil, err
+	}
+	encoded := append([]byte(nil), r.msg[start:r.off]...)
+	qtype, err := r.uint16()
+	if err != nil {
+		return nil, err
+	}
+	qclass, err := r.uint16()
+	if err != nil {
+		return nil, err
+	}
+	return &Question{
+		Name:  &Addr{Encoded: encoded, String: name},
+		Type:  _type.RecordType(qtype),
+		Class: _type.RecordClass(qclass),
+	}, nil
+}
+
+// record reads a resource record. Empty RDATA, as used by dynamic update to
+// delete whole sets, is returned as Unknown whatever the type.
+func (r *reader) record() (*Record, error) {
+	name, err := r.name()
+	if err != nil {
+		return nil, err
+	}
+	rrType, err := r.uint16()
+	if err != nil {
+		return nil, err
+	}
+	class, err := r.uint16()
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	length, err := r.uint16()
+	if err != nil {
+		return nil, err
+	}
+	end := r.off + int(length)
+	if end > len(r.msg) {
+		return nil, errors.New("buffer too small for rdata")
+	}
+
+	var data RData = &Unknown{}
+	if length > 0 {
+		data = newRData(_type.RecordType(rrType))
+	}
+	if err = data.unpack(r, end); err != nil {
+		return nil, err
+	}
+	if r.off != end {
+		return nil, errors.New("rdata length mismatch for " + _type.RecordType(rrType).String())
+	}
+	return &Record{
+		Name:  name,
+		Type:  _type.RecordType(rrType),
+		Class: _type.RecordClass(class),
+		TTL:   ttl,
+		Data:  data,
+	}, nil
+}
+
+// records reads count resource records
+func (r *reader) records(count uint16) ([]*Record, error) {
+	records := make([]*Record, 0, count)
+	for i := uint16(0); i < count; i++ {
+		record, err := r.record()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,538 @@
+package dns
+
+import (
+	_type "com.sentry.dev/app/dns/type"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Record represents a resource record. Names are stored without their
+// trailing dot.
+type Record struct {
+	Name  string
+	Type  _type.RecordType
+	Class _type.RecordClass
+	TTL   uint32
+	Data  RData
+}
+
+// String returns the record in master file format
+func (r *Record) String() string {
+	return fmt.Sprintf("%s\t%d\t%s\t%s\t%s", Fqdn(r.Name), r.TTL, r.Class, r.Type, r.Data)
+}
+
+// RData is the type specific data of a resource record
+type RData interface {
+	// String returns the data in master file format
+	String() string
+	pack(b *builder) error
+	unpack(r *reader, end int) error
+}
+
+// newRData returns an empty RData structure for records of type t
+func newRData(t _type.RecordType) RData {
+	switch t {
+	case _type.TypeA:
+		return &A{}
+	case _type.TypeAAAA:
+		return &AAAA{}
+	case _type.TypeNS:
+		return &NS{}
+	case _type.TypeCNAME:
+		return &CNAME{}
+	case _type.TypeDNAME:
+		return &DNAME{}
+	case _type.TypePTR:
+		return &PTR{}
+	case _type.TypeMX:
+		return &MX{}
+	case _type.TypeSOA:
+		return &SOA{}
+	case _type.TypeTXT:
+		return &TXT{}
+	case _type.TypeHINFO:
+		return &HINFO{}
+	case _type.TypeMINFO:
+		return &MINFO{}
+	case _type.TypeSRV:
+		return &SRV{}
+	case _type.TypeCAA:
+		return &CAA{}
+	case _type.TypeDS:
+		return &DS{}
+	case _type.TypeDNSKEY:
+		return &DNSKEY{}
+	case _type.TypeRRSIG:
+		return &RRSIG{}
+	case _type.TypeNSEC:
+		return &NSEC{}
+	case _type.TypeNSEC3:
+		return &NSEC3{}
+	case _type.TypeNSEC3PARAM:
+		return &NSEC3PARAM{}
+	case _type.TypeTSIG:
+		return &TSIG{}
+	}
+	return &Unknown{}
+}
+
+// IsKnownType reports whether records of type t have a dedicated RData
+// structure rather than Unknown
+func IsKnownType(t _type.RecordType) bool {
+	switch t {
+	case _type.TypeA, _type.TypeAAAA, _type.TypeNS, _type.TypeCNAME, _type.TypeDNAME,
+		_type.TypePTR, _type.TypeMX, _type.TypeSOA, _type.TypeTXT, _type.TypeHINFO,
+		_type.TypeMINFO, _type.TypeSRV, _type.TypeCAA, _type.TypeDS, _type.TypeDNSKEY,
+		_type.TypeRRSIG, _type.TypeNSEC, _type.TypeNSEC3, _type.TypeNSEC3PARAM:
+		return true
+	}
+	return false
+}
+
+// A is an IPv4 host address
+type A struct {
+	IP net.IP
+}
+
+func (d *A) String() string {
+	return d.IP.String()
+}
+
+func (d *A) pack(b *builder) error {
+	ip := d.IP.To4()
+	if ip == nil {
+		return errors.New("invalid IPv4 address")
+	}
+	b.bytes(ip)
+	return nil
+}
+
+func (d *A) unpack(r *reader, end int) (err error) {
+	d.IP, err = r.bytes(net.IPv4len)
+	return
+}
+
+// AAAA is an IPv6 host address
+type AAAA struct {
+	IP net.IP
+}
+
+func (d *AAAA) String() string {
+	return d.IP.String()
+}
+
+func (d *AAAA) pack(b *builder) error {
+	ip := d.IP.To16()
+	if ip == nil {
+		return errors.New("invalid IPv6 address")
+	}
+	b.bytes(ip)
+	return nil
+}
+
+func (d *AAAA) unpack(r *reader, end int) (err error) {
+	d.IP, err = r.bytes(net.IPv6len)
+	return
+}
+
+// NS names an authoritative name server
+type NS struct {
+	Host string
+}
+
+func (d *NS) String() string {
+	return Fqdn(d.Host)
+}
+
+func (d *NS) pack(b *builder) error {
+	return b.name(d.Host, true)
+}
+
+func (d *NS) unpack(r *reader, end int) (err error) {
+	d.Host, err = r.name()
+	return
+}
+
+// CNAME is the canonical name of an alias
+type CNAME struct {
+	Target string
+}
+
+func (d *CNAME) String() string {
+	return Fqdn(d.Target)
+}
+
+func (d *CNAME) pack(b *builder) error {
+	return b.name(d.Target, true)
+}
+
+func (d *CNAME) unpack(r *reader, end int) (err error) {
+	d.Target, err = r.name()
+	return
+}
+
+// DNAME redirects a whole subtree to another name (RFC 6672)
+type DNAME struct {
+	Target string
+}
+
+func (d *DNAME) String() string {
+	return Fqdn(d.Target)
+}
+
+func (d *DNAME) pack(b *builder) error {
+	return b.name(d.Target, false)
+}
+
+func (d *DNAME) unpack(r *reader, end int) (err error) {
+	d.Target, err = r.name()
+	return
+}
+
+// PTR points to another domain name
+type PTR struct {
+	Target string
+}
+
+func (d *PTR) String() string {
+	return Fqdn(d.Target)
+}
+
+func (d *PTR) pack(b *builder) error {
+	return b.name(d.Target, true)
+}
+
+func (d *PTR) unpack(r *reader, end int) (err error) {
+	d.Target, err = r.name()
+	return
+}
+
+// MX names a mail exchange
+type MX struct {
+	Preference uint16
+	Exchange   string
+}
+
+func (d *MX) String() string {
+	return strconv.Itoa(int(d.Preference)) + " " + Fqdn(d.Exchange)
+}
+
+func (d *MX) pack(b *builder) error {
+	b.uint16(d.Preference)
+	return b.name(d.Exchange, true)
+}
+
+func (d *MX) unpack(r *reader, end int) (err error) {
+	if d.Preference, err = r.uint16(); err != nil {
+		return
+	}
+	d.Exchange, err = r.name()
+	return
+}
+
+// SOA marks the start of a zone of authority
+type SOA struct {
+	MName   string
+	RName   string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+func (d *SOA) String() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d",
+		Fqdn(d.MName), Fqdn(d.RName), d.Serial, d.Refresh, d.Retry, d.Expire, d.Minimum)
+}
+
+func (d *SOA) pack(b *builder) error {
+	if err := b.name(d.MName, true); err != nil {
+		return err
+	}
+	if err := b.name(d.RName, true); err != nil {
+		return err
+	}
+	b.uint32(d.Serial)
+	b.uint32(d.Refresh)
+	b.uint32(d.Retry)
+	b.uint32(d.Expire)
+	b.uint32(d.Minimum)
+	return nil
+}
+
+func (d *SOA) unpack(r *reader, end int) (err error) {
+	if d.MName, err = r.name(); err != nil {
+		return
+	}
+	if d.RName, err = r.name(); err != nil {
+		return
+	}
+	for _, v := range []*uint32{&d.Serial, &d.Refresh, &d.Retry, &d.Expire, &d.Minimum} {
+		if *v, err = r.uint32(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// TXT holds one or more text strings
+type TXT struct {
+	Strings []string
+}
+
+func (d *TXT) String() string {
+	quoted := make([]string, len(d.Strings))
+	for i, s := range d.Strings {
+		quoted[i] = quote(s)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (d *TXT) pack(b *builder) error {
+	for _, s := range d.Strings {
+		if err := b.characterString(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *TXT) unpack(r *reader, end int) error {
+	for r.off < end {
+		s, err := r.characterString()
+		if err != nil {
+			return err
+		}
+		d.Strings = append(d.Strings, s)
+	}
+	return nil
+}
+
+// HINFO describes the host hardware and operating system
+type HINFO struct {
+	CPU string
+	OS  string
+}
+
+func (d *HINFO) String() string {
+	return quote(d.CPU) + " " + quote(d.OS)
+}
+
+func (d *HINFO) pack(b *builder) error {
+	if err := b.characterString(d.CPU); err != nil {
+		return err
+	}
+	return b.characterString(d.OS)
+}
+
+func (d *HINFO) unpack(r *reader, end int) (err error) {
+	if d.CPU, err = r.characterString(); err != nil {
+		return
+	}
+	d.OS, err = r.characterString()
+	return
+}
+
+// MINFO names the mailboxes responsible for a mailing list
+type MINFO struct {
+	RMailBx string
+	EMailBx string
+}
+
+func (d *MINFO) String() string {
+	return Fqdn(d.RMailBx) + " " + Fqdn(d.EMailBx)
+}
+
+func (d *MINFO) pack(b *builder) error {
+	if err := b.name(d.RMailBx, true); err != nil {
+		return err
+	}
+	return b.name(d.EMailBx, true)
+}
+
+func (d *MINFO) unpack(r *reader, end int) (err error) {
+	if d.RMailBx, err = r.name(); err != nil {
+		return
+	}
+	d.EMailBx, err = r.name()
+	return
+}
+
+// SRV locates a service (RFC 2782)
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+func (d *SRV) String() string {
+	return fmt.Sprintf("%d %d %d %s", d.Priority, d.Weight, d.Port, Fqdn(d.Target))
+}
+
+func (d *SRV) pack(b *builder) error {
+	b.uint16(d.Priority)
+	b.uint16(d.Weight)
+	b.uint16(d.Port)
+	return b.name(d.Target, false)
+}
+
+func (d *SRV) unpack(r *reader, end int) (err error) {
+	for _, v := range []*uint16{&d.Priority, &d.Weight, &d.Port} {
+		if *v, err = r.uint16(); err != nil {
+			return
+		}
+	}
+	d.Target, err = r.name()
+	return
+}
+
+// CAA restricts the certification authorities for a domain (RFC 8659)
+type CAA struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+func (d *CAA) String() string {
+	return fmt.Sprintf("%d %s %s", d.Flag, d.Tag, quote(d.Value))
+}
+
+func (d *CAA) pack(b *builder) error {
+	b.uint8(d.Flag)
+	if err := b.characterString(d.Tag); err != nil {
+		return err
+	}
+	b.bytes([]byte(d.Value))
+	return nil
+}
+
+func (d *CAA) unpack(r *reader, end int) (err error) {
+	if d.Flag, err = r.uint8(); err != nil {
+		return
+	}
+	if d.Tag, err = r.characterString(); err != nil {
+		return
+	}
+	value, err := r.bytes(end - r.off)
+	d.Value = string(value)
+	return
+}
+
+// TSIG authenticates a message with a shared key (RFC 8945). It only
+// appears as the last record of a message, never in zones.
+type TSIG struct {
+	Algorithm  string
+	TimeSigned uint64 // seconds since the epoch, 48 bits on the wire
+	Fudge      uint16
+	MAC        []byte
+	OriginalID uint16
+	Error      uint16
+	OtherData  []byte
+}
+
+func (d *TSIG) String() string {
+	return fmt.Sprintf("%s %d %d %d %s %d %s %d %s",
+		Fqdn(d.Algorithm), d.TimeSigned, d.Fudge, len(d.MAC), base64.StdEncoding.EncodeToString(d.MAC),
+		d.OriginalID, _type.ResponseCode(d.Error), len(d.OtherData), hex.EncodeToString(d.OtherData))
+}
+
+func (d *TSIG) pack(b *builder) error {
+	if err := b.name(d.Algorithm, false); err != nil {
+		return err
+	}
+	b.uint16(uint16(d.TimeSigned >> 32))
+	b.uint32(uint32(d.TimeSigned))
+	b.uint16(d.Fudge)
+	b.uint16(uint16(len(d.MAC)))
+	b.bytes(d.MAC)
+	b.uint16(d.OriginalID)
+	b.uint16(d.Error)
+	b.uint16(uint16(len(d.OtherData)))
+	b.bytes(d.OtherData)
+	return nil
+}
+
+func (d *TSIG) unpack(r *reader, end int) (err error) {
+	if d.Algorithm, err = r.name(); err != nil {
+		return
+	}
+	high, err := r.uint16()
+	if err != nil {
+		return
+	}
+	low, err := r.uint32()
+	if err != nil {
+		return
+	}
+	d.TimeSigned = uint64(high)<<32 | uint64(low)
+	if d.Fudge, err = r.uint16(); err != nil {
+		return
+	}
+	size, err := r.uint16()
+	if err != nil {
+		return
+	}
+	if d.MAC, err = r.bytes(int(size)); err != nil {
+		return
+	}
+	if d.OriginalID, err = r.uint16(); err != nil {
+		return
+	}
+	if d.Error, err = r.uint16(); err != nil {
+		return
+	}
+	if size, err = r.uint16(); err != nil {
+		return
+	}
+	d.OtherData, err = r.bytes(int(size))
+	return
+}
+
+// Unknown holds the opaque data of a type without a dedicated structure (RFC 3597)
+type Unknown struct {
+	Data []byte
+}
+
+func (d *Unknown) String() string {
+	if len(d.Data) == 0 {
+		return `\# 0`
+	}
+	return `\# ` + strconv.Itoa(len(d.Data)) + " " + hex.EncodeToString(d.Data)
+}
+
+func (d *Unknown) pack(b *builder) error {
+	b.bytes(d.Data)
+	return nil
+}
+
+func (d *Unknown) unpack(r *reader, end int) (err error) {
+	d.Data, err = r.bytes(end - r.off)
+	return
+}
+
+// quote returns s as a quoted master file string
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || c > 0x7E:
+			fmt.Fprintf(&sb, "\\%03d", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,10 @@
+package _type
+
+// OpCode represents the kind of a DNS message
+type OpCode uint8
+
+const (
+	OpCodeQuery  OpCode = 0 // Standard query
+	OpCodeNotify OpCode = 4 // Zone change notification (RFC 1996)
+	OpCodeUpdate OpCode = 5 // Dynamic update (RFC 2136)
+)
@@ -1,10 +1,55 @@
 package _type
 
+import "strconv"
+
 // RecordClass represents DNS record classes
 type RecordClass uint16
 
 const (
-	ClassIN RecordClass = 1 // Internet
-	ClassCH RecordClass = 3 // CHAOS class
-	ClassHS RecordClass = 4 // Hesiod
+	ClassIN   RecordClass = 1   // Internet
+	ClassCH   RecordClass = 3   // CHAOS class
+	ClassHS   RecordClass = 4   // Hesiod
+	ClassNONE RecordClass = 254 // No class (dynamic update only)
+	ClassANY  RecordClass = 255 // Any class (QCLASS only)
 )
+
+var recordClassNames = map[RecordClass]string{
+	ClassIN:   "IN",
+	ClassCH:   "CH",
+	ClassHS:   "HS",
+	ClassNONE: "NONE",
+	ClassANY:  "ANY",
+}
+
+// String returns the mnemonic of the record class
+func (c RecordClass) String() string {
+	if name, ok := recordClassNames[c]; ok {
+		return name
+	}
+	return "CLASS" + strconv.Itoa(int(c))
+}
+
+// ParseRecordClass returns the class for a mnemonic such as IN or CLASS3
+func ParseRecordClass(name string) (RecordClass, bool) {
+	for class, mnemonic := range recordClassNames {
+		if mnemonic == name {
+			return class, true
+		}
+	}
+	if n, found := cutPrefixNumber(name, "CLASS"); found {
+		return RecordClass(n), true
+	}
+	return 0, false
+}
+
+// cutPrefixNumber parses the 16-bit number following prefix in s
+func cutPrefixNumber(s, prefix string) (uint16, bool) {
+	if len(s) <= len(prefix) || s[:len(prefix)] != prefix {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s[len(prefix):], 10, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint16(n), true
+}
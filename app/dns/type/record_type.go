@@ -1,17 +1,83 @@
 package _type
 
+import "strconv"
+
 // RecordType represents DNS record types
 type RecordType uint16
 
 const (
-	TypeA     RecordType = 1  // Host address
-	TypeNS    RecordType = 2  // Authoritative name server
-	TypeCNAME RecordType = 5  // Canonical name for an alias
-	TypeSOA   RecordType = 6  // Start of zone of authority
-	TypeWKS   RecordType = 11 // Well known service description
-	TypePTR   RecordType = 12 // Domain name pointer
-	TypeHINFO RecordType = 13 // Host information
-	TypeMINFO RecordType = 14 // Mailbox or mail list information
-	TypeMX    RecordType = 15 // Mail exchange
-	TypeTXT   RecordType = 16 // Text strings
+	TypeA          RecordType = 1   // Host address
+	TypeNS         RecordType = 2   // Authoritative name server
+	TypeCNAME      RecordType = 5   // Canonical name for an alias
+	TypeSOA        RecordType = 6   // Start of zone of authority
+	TypeWKS        RecordType = 11  // Well known service description
+	TypePTR        RecordType = 12  // Domain name pointer
+	TypeHINFO      RecordType = 13  // Host information
+	TypeMINFO      RecordType = 14  // Mailbox or mail list information
+	TypeMX         RecordType = 15  // Mail exchange
+	TypeTXT        RecordType = 16  // Text strings
+	TypeAAAA       RecordType = 28  // IPv6 host address
+	TypeSRV        RecordType = 33  // Service locator
+	TypeDNAME      RecordType = 39  // Delegation name
+	TypeOPT        RecordType = 41  // EDNS(0) pseudo-record (RFC 6891)
+	TypeDS         RecordType = 43  // Delegation signer (RFC 4034)
+	TypeRRSIG      RecordType = 46  // Signature over an RRset (RFC 4034)
+	TypeNSEC       RecordType = 47  // Next secure name (RFC 4034)
+	TypeDNSKEY     RecordType = 48  // Zone signing key (RFC 4034)
+	TypeNSEC3      RecordType = 50  // Hashed next secure name (RFC 5155)
+	TypeNSEC3PARAM RecordType = 51  // Parameters of the NSEC3 chain (RFC 5155)
+	TypeTSIG       RecordType = 250 // Transaction signature (RFC 8945)
+	TypeIXFR       RecordType = 251 // Incremental zone transfer (QTYPE only)
+	TypeAXFR       RecordType = 252 // Full zone transfer (QTYPE only)
+	TypeANY        RecordType = 255 // All records (QTYPE only)
+	TypeCAA        RecordType = 257 // Certification authority authorization
 )
+
+var recordTypeNames = map[RecordType]string{
+	TypeA:          "A",
+	TypeNS:         "NS",
+	TypeCNAME:      "CNAME",
+	TypeSOA:        "SOA",
+	TypeWKS:        "WKS",
+	TypePTR:        "PTR",
+	TypeHINFO:      "HINFO",
+	TypeMINFO:      "MINFO",
+	TypeMX:         "MX",
+	TypeTXT:        "TXT",
+	TypeAAAA:       "AAAA",
+	TypeSRV:        "SRV",
+	TypeDNAME:      "DNAME",
+	TypeOPT:        "OPT",
+	TypeDS:         "DS",
+	TypeRRSIG:      "RRSIG",
+	TypeNSEC:       "NSEC",
+	TypeDNSKEY:     "DNSKEY",
+	TypeNSEC3:      "NSEC3",
+	TypeNSEC3PARAM: "NSEC3PARAM",
+	TypeTSIG:       "TSIG",
+	TypeIXFR:       "IXFR",
+	TypeAXFR:       "AXFR",
+	TypeANY:        "ANY",
+	TypeCAA:        "CAA",
+}
+
+// String returns the mnemonic of the record type
+func (t RecordType) String() string {
+	if name, ok := recordTypeNames[t]; ok {
+		return name
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// ParseRecordType returns the type for a mnemonic such as AAAA or TYPE65
+func ParseRecordType(name string) (RecordType, bool) {
+	for t, mnemonic := range recordTypeNames {
+		if mnemonic == name {
+			return t, true
+		}
+	}
+	if n, found := cutPrefixNumber(name, "TYPE"); found {
+		return RecordType(n), true
+	}
+	return 0, false
+}
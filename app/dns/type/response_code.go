@@ -0,0 +1,48 @@
+package _type
+
+import "strconv"
+
+// ResponseCode represents DNS response codes
+type ResponseCode uint8
+
+const (
+	RCodeNoError  ResponseCode = 0  // No error condition
+	RCodeFormErr  ResponseCode = 1  // Format error
+	RCodeServFail ResponseCode = 2  // Server failure
+	RCodeNXDomain ResponseCode = 3  // Name does not exist
+	RCodeNotImp   ResponseCode = 4  // Not implemented
+	RCodeRefused  ResponseCode = 5  // Query refused
+	RCodeYXDomain ResponseCode = 6  // Name exists when it should not
+	RCodeYXRRSet  ResponseCode = 7  // RRset exists when it should not
+	RCodeNXRRSet  ResponseCode = 8  // RRset that should exist does not
+	RCodeNotAuth  ResponseCode = 9  // Server not authoritative for the zone
+	RCodeNotZone  ResponseCode = 10 // Name not contained in the zone
+	RCodeBadSig   ResponseCode = 16 // TSIG signature failure, in the TSIG error field
+	RCodeBadKey   ResponseCode = 17 // TSIG key not recognized
+	RCodeBadTime  ResponseCode = 18 // TSIG signature out of time window
+)
+
+var responseCodeNames = map[ResponseCode]string{
+	RCodeNoError:  "NOERROR",
+	RCodeFormErr:  "FORMERR",
+	RCodeServFail: "SERVFAIL",
+	RCodeNXDomain: "NXDOMAIN",
+	RCodeNotImp:   "NOTIMP",
+	RCodeRefused:  "REFUSED",
+	RCodeYXDomain: "YXDOMAIN",
+	RCodeYXRRSet:  "YXRRSET",
+	RCodeNXRRSet:  "NXRRSET",
+	RCodeNotAuth:  "NOTAUTH",
+	RCodeNotZone:  "NOTZONE",
+	RCodeBadSig:   "BADSIG",
+	RCodeBadKey:   "BADKEY",
+	RCodeBadTime:  "BADTIME",
+}
+
+// String returns the mnemonic of the response code
+func (c ResponseCode) String() string {
+	if name, ok := responseCodeNames[c]; ok {
+		return name
+	}
+	return "RCODE" + strconv.Itoa(int(c))
+}
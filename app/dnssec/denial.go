@@ -0,0 +1,331 @@
+package dnssec
+
+import (
+	"bytes"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"crypto/sha1"
+	"strings"
+)
+
+const (
+	// HashSHA1 is the only hash algorithm of NSEC3 (RFC 5155 11)
+	HashSHA1 uint8 = 1
+	// MaxIterations is the most NSEC3 iterations trusted; chains hashed
+	// more often are treated as insecure (RFC 9276 3.2)
+	MaxIterations = 150
+)
+
+// Compare orders names canonically (RFC 4034 6.1): label by label from the
+// root, each label compared as lowercase octets
+func Compare(a, b string) int {
+	la := dns.SplitName(strings.ToLower(a))
+	lb := dns.SplitName(strings.ToLower(b))
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		if c := strings.Compare(la[len(la)-i], lb[len(lb)-i]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// HashName hashes a name for an NSEC3 chain (RFC 5155 5)
+func HashName(name string, iterations uint16, salt []byte) []byte {
+	wire, err := dns.PackName(strings.ToLower(name))
+	if err != nil {
+		return nil
+	}
+	h := sha1.New()
+	h.Write(wire)
+	h.Write(salt)
+	digest := h.Sum(nil)
+	for i := uint16(0); i < iterations; i++ {
+		h.Reset()
+		h.Write(digest)
+		h.Write(salt)
+		digest = h.Sum(digest[:0])
+	}
+	return digest
+}
+
+// Proof holds the validated NSEC or NSEC3 records a zone sent along a
+// negative answer or a wildcard expansion, to check what they prove
+type Proof struct {
+	zone  string
+	nsec  []*dns.Record
+	nsec3 []*dns.Record
+}
+
+// NewProof keeps the NSEC and NSEC3 records among records, which must all
+// have been validated with the keys of zone
+func NewProof(zone string, records []*dns.Record) *Proof {
+	p := &Proof{zone: dns.CanonicalName(zone)}
+	for _, r := range records {
+		switch data := r.Data.(type) {
+		case *dns.NSEC:
+			p.nsec = append(p.nsec, r)
+		case *dns.NSEC3:
+			if data.HashAlgorithm == HashSHA1 && dns.CanonicalName(dns.Parent(r.Name)) == p.zone {
+				p.nsec3 = append(p.nsec3, r)
+			}
+		}
+	}
+	return p
+}
+
+// NameError checks the proof that name does not exist: nor the name itself
+// nor a wildcard that would have matched it (RFC 4035 5.4, RFC 5155 8.4)
+func (p *Proof) NameError(name string) Security {
+	if len(p.nsec) > 0 {
+		cover := p.nsecCovering(name)
+		if cover == nil {
+			return Bogus
+		}
+		ce := p.nsecClosestEncloser(name, cover)
+		if p.nsecCovering(wildcard(ce)) == nil {
+			return Bogus
+		}
+		return Secure
+	}
+	if insecure, ok := p.nsec3Usable(); !ok {
+		return Bogus
+	} else if insecure {
+		return Insecure
+	}
+	ce, nextCloser, ok := p.closestEncloser(name)
+	if !ok || p.nsec3Covering(wildcard(ce)) == nil {
+		return Bogus
+	}
+	if nextCloser.Data.(*dns.NSEC3).Flags&dns.FlagOptOut != 0 {
+		return Insecure
+	}
+	return Secure
+}
+
+// NoData checks the proof that name exists without records of type t, or
+// that a wildcard matching it does (RFC 4035 5.4, RFC 5155 8.5 to 8.7). An
+// unsigned delegation covered by an opt-out span proves no DS insecurely.
+func (p *Proof) NoData(name string, t _type.RecordType) Security {
+	if len(p.nsec) > 0 {
+		if match := p.nsecMatching(name); match != nil {
+			return noDataIn(match.Data.(*dns.NSEC).Types, name, t)
+		}
+		cover := p.nsecCovering(name)
+		if cover == nil {
+			return Bogus
+		}
+		// an empty non-terminal sorts right before the names below it
+		next := cover.Data.(*dns.NSEC).NextDomain
+		if dns.IsSubDomain(next, name) && dns.CanonicalName(next) != dns.CanonicalName(name) {
+			return Secure
+		}
+		ce := p.nsecClosestEncloser(name, cover)
+		if match := p.nsecMatching(wildcard(ce)); match != nil {
+			return noDataIn(match.Data.(*dns.NSEC).Types, ce, t)
+		}
+		return Bogus
+	}
+	if insecure, ok := p.nsec3Usable(); !ok {
+		return Bogus
+	} else if insecure {
+		return Insecure
+	}
+	if match := p.nsec3Matching(name); match != nil {
+		return noDataIn(match.Data.(*dns.NSEC3).Types, name, t)
+	}
+	ce, nextCloser, ok := p.closestEncloser(name)
+	if !ok {
+		return Bogus
+	}
+	if t == _type.TypeDS && nextCloser.Data.(*dns.NSEC3).Flags&dns.FlagOptOut != 0 {
+		return Insecure
+	}
+	if match := p.nsec3Matching(wildcard(ce)); match != nil {
+		return noDataIn(match.Data.(*dns.NSEC3).Types, ce, t)
+	}
+	return Bogus
+}
+
+// Wildcard checks the proof that an answer synthesized from the wildcard
+// at labels labels below the root was due: no closer name existed
+// (RFC 4035 5.3.4, RFC 5155 8.8)
+func (p *Proof) Wildcard(name string, labels int) Security {
+	if len(p.nsec) > 0 {
+		if p.nsecCovering(name) == nil {
+			return Bogus
+		}
+		return Secure
+	}
+	if insecure, ok := p.nsec3Usable(); !ok {
+		return Bogus
+	} else if insecure {
+		return Insecure
+	}
+	parts := dns.SplitName(name)
+	if labels >= len(parts) {
+		return Bogus
+	}
+	if p.nsec3Covering(strings.Join(parts[len(parts)-labels-1:], ".")) == nil {
+		return Bogus
+	}
+	return Secure
+}
+
+// noDataIn checks the type bitmap of the record matching name. At a
+// delegation the parent only holds NS, DS and NSEC records, and may only
+// deny DS, while the child apex cannot deny it (RFC 6840 4.4).
+func noDataIn(types []_type.RecordType, name string, t _type.RecordType) Security {
+	if dns.HasType(types, t) || dns.HasType(types, _type.TypeCNAME) {
+		return Bogus
+	}
+	delegation := dns.HasType(types, _type.TypeNS) && !dns.HasType(types, _type.TypeSOA)
+	switch {
+	case t == _type.TypeDS && dns.HasType(types, _type.TypeSOA) && name != "":
+		return Bogus
+	case t != _type.TypeDS && delegation:
+		return Bogus
+	}
+	return Secure
+}
+
+func wildcard(name string) string {
+	if name == "" {
+		return "*"
+	}
+	return "*." + name
+}
+
+func (p *Proof) nsecMatching(name string) *dns.Record {
+	for _, r := range p.nsec {
+		if dns.CanonicalName(r.Name) == dns.CanonicalName(name) {
+			return r
+		}
+	}
+	return nil
+}
+
+// nsecCovering returns the NSEC record whose span holds name, the last one
+// of the zone wrapping around to its apex. The NSEC of a delegation, or of
+// a DNAME, says nothing of the names below it (RFC 6840 4.1).
+func (p *Proof) nsecCovering(name string) *dns.Record {
+	for _, r := range p.nsec {
+		nsec := r.Data.(*dns.NSEC)
+		var covers bool
+		if Compare(r.Name, nsec.NextDomain) < 0 {
+			covers = Compare(r.Name, name) < 0 && Compare(name, nsec.NextDomain) < 0
+		} else {
+			covers = Compare(r.Name, name) < 0 || Compare(name, nsec.NextDomain) < 0
+		}
+		if !covers || !dns.IsSubDomain(name, p.zone) {
+			continue
+		}
+		delegation := dns.HasType(nsec.Types, _type.TypeNS) && !dns.HasType(nsec.Types, _type.TypeSOA)
+		if (delegation || dns.HasType(nsec.Types, _type.TypeDNAME)) && dns.IsSubDomain(name, r.Name) {
+			continue
+		}
+		return r
+	}
+	return nil
+}
+
+// nsecClosestEncloser derives the closest existing ancestor of name from
+// the NSEC record covering it: the longest ancestor shared with either end
+// of its span
+func (p *Proof) nsecClosestEncloser(name string, cover *dns.Record) string {
+	ce := commonAncestor(name, cover.Name)
+	if next := commonAncestor(name, cover.Data.(*dns.NSEC).NextDomain); len(next) > len(ce) {
+		ce = next
+	}
+	return ce
+}
+
+func commonAncestor(a, b string) string {
+	la := dns.SplitName(strings.ToLower(a))
+	lb := dns.SplitName(strings.ToLower(b))
+	n := 0
+	for n < len(la) && n < len(lb) && la[len(la)-1-n] == lb[len(lb)-1-n] {
+		n++
+	}
+	return strings.Join(la[len(la)-n:], ".")
+}
+
+// nsec3Usable reports whether the NSEC3 records can be checked: there must
+// be some, and a chain hashed too many times is insecure
+func (p *Proof) nsec3Usable() (insecure, ok bool) {
+	if len(p.nsec3) == 0 {
+		return false, false
+	}
+	for _, r := range p.nsec3 {
+		if r.Data.(*dns.NSEC3).Iterations > MaxIterations {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// hashFor hashes name with the parameters of an NSEC3 record
+func hashFor(name string, r *dns.Record) []byte {
+	nsec3 := r.Data.(*dns.NSEC3)
+	return HashName(name, nsec3.Iterations, nsec3.Salt)
+}
+
+// ownerHash decodes the hash an NSEC3 record is owned by
+func ownerHash(r *dns.Record) []byte {
+	label, _, _ := strings.Cut(r.Name, ".")
+	h, err := dns.Base32Hex.DecodeString(strings.ToUpper(label))
+	if err != nil {
+		return nil
+	}
+	return h
+}
+
+func (p *Proof) nsec3Matching(name string) *dns.Record {
+	for _, r := range p.nsec3 {
+		owner := ownerHash(r)
+		if owner != nil && bytes.Equal(owner, hashFor(name, r)) {
+			return r
+		}
+	}
+	return nil
+}
+
+// nsec3Covering returns the NSEC3 record whose span holds the hash of name,
+// the last one of the chain wrapping around to the first
+func (p *Proof) nsec3Covering(name string) *dns.Record {
+	for _, r := range p.nsec3 {
+		nsec3 := r.Data.(*dns.NSEC3)
+		owner := ownerHash(r)
+		if owner == nil {
+			continue
+		}
+		h := HashName(name, nsec3.Iterations, nsec3.Salt)
+		var covers bool
+		if bytes.Compare(owner, nsec3.NextHashed) < 0 {
+			covers = bytes.Compare(owner, h) < 0 && bytes.Compare(h, nsec3.NextHashed) < 0
+		} else {
+			covers = bytes.Compare(owner, h) < 0 || bytes.Compare(h, nsec3.NextHashed) < 0
+		}
+		if covers {
+			return r
+		}
+	}
+	return nil
+}
+
+// closestEncloser finds the closest existing ancestor of name, whose hash
+// is matched while the hash of the next closer name, one label longer, is
+// covered (RFC 5155 8.3). It returns the record covering the latter.
+func (p *Proof) closestEncloser(name string) (string, *dns.Record, bool) {
+	nextCloser := dns.CanonicalName(name)
+	for candidate := dns.Parent(nextCloser); dns.IsSubDomain(candidate, p.zone); candidate = dns.Parent(candidate) {
+		if p.nsec3Matching(candidate) != nil {
+			cover := p.nsec3Covering(nextCloser)
+			return candidate, cover, cover != nil
+		}
+		if candidate == "" {
+			break
+		}
+		nextCloser = candidate
+	}
+	return "", nil, false
+}
@@ -0,0 +1,359 @@
+// Package dnssec signs DNS data and verifies its signatures and the proofs
+// that names or types do not exist (RFC 4033, 4034, 4035 and 5155).
+package dnssec
+
+import (
+	"bytes"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Signing algorithms (RFC 8624)
+const (
+	AlgorithmRSASHA1         uint8 = 5
+	AlgorithmRSASHA1NSEC3    uint8 = 7
+	AlgorithmRSASHA256       uint8 = 8
+	AlgorithmRSASHA512       uint8 = 10
+	AlgorithmECDSAP256SHA256 uint8 = 13
+	AlgorithmECDSAP384SHA384 uint8 = 14
+	AlgorithmED25519         uint8 = 15
+)
+
+// Digest types of DS records
+const (
+	DigestSHA1   uint8 = 1
+	DigestSHA256 uint8 = 2
+	DigestSHA384 uint8 = 4
+)
+
+// Security is the outcome of validating data (RFC 4035 4.3)
+type Security int
+
+const (
+	// Insecure data has no chain of trust, for lack of a trust anchor or
+	// below a delegation proven unsigned
+	Insecure Security = iota
+	// Secure data is signed by keys chained to a trust anchor
+	Secure
+	// Bogus data should be signed but its signatures or proofs do not hold
+	Bogus
+)
+
+func (s Security) String() string {
+	switch s {
+	case Secure:
+		return "secure"
+	case Bogus:
+		return "bogus"
+	}
+	return "insecure"
+}
+
+var (
+	errAlgorithm = errors.New("unsupported algorithm")
+	errKey       = errors.New("malformed public key")
+	errSignature = errors.New("signature does not verify")
+)
+
+// SupportsAlgorithm reports whether signatures of the algorithm can be
+// verified. Zones signed with others are treated as unsigned.
+func SupportsAlgorithm(algorithm uint8) bool {
+	switch algorithm {
+	case AlgorithmRSASHA1, AlgorithmRSASHA1NSEC3, AlgorithmRSASHA256, AlgorithmRSASHA512,
+		AlgorithmECDSAP256SHA256, AlgorithmECDSAP384SHA384, AlgorithmED25519:
+		return true
+	}
+	return false
+}
+
+// SupportsDigest reports whether DS records of the digest type can be checked
+func SupportsDigest(digestType uint8) bool {
+	return newDigest(digestType) != nil
+}
+
+func newDigest(digestType uint8) hash.Hash {
+	switch digestType {
+	case DigestSHA1:
+		return sha1.New()
+	case DigestSHA256:
+		return sha256.New()
+	case DigestSHA384:
+		return sha512.New384()
+	}
+	return nil
+}
+
+// KeyTag computes the tag RRSIG and DS records refer to a key by
+// (RFC 4034 Appendix B)
+func KeyTag(key *dns.DNSKEY) uint16 {
+	rdata, err := dns.PackRData(key, false)
+	if err != nil {
+		return 0
+	}
+	var ac uint32
+	for i, b := range rdata {
+		if i&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += ac >> 16 & 0xFFFF
+	return uint16(ac)
+}
+
+// NewDS returns the DS record of the key of zone owner (RFC 4034 5.1.4)
+func NewDS(owner string, key *dns.DNSKEY, digestType uint8) (*dns.DS, error) {
+	h := newDigest(digestType)
+	if h == nil {
+		return nil, errors.New("unsupported digest type")
+	}
+	name, err := dns.PackName(strings.ToLower(owner))
+	if err != nil {
+		return nil, err
+	}
+	rdata, err := dns.PackRData(key, false)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(name)
+	h.Write(rdata)
+	return &dns.DS{
+		KeyTag:     KeyTag(key),
+		Algorithm:  key.Algorithm,
+		DigestType: digestType,
+		Digest:     h.Sum(nil),
+	}, nil
+}
+
+// MatchesDS reports whether ds refers to key, a DNSKEY of zone owner
+func MatchesDS(owner string, ds *dns.DS, key *dns.DNSKEY) bool {
+	if ds.Algorithm != key.Algorithm || ds.KeyTag != KeyTag(key) {
+		return false
+	}
+	expected, err := NewDS(owner, key, ds.DigestType)
+	return err == nil && bytes.Equal(expected.Digest, ds.Digest)
+}
+
+// SignedData returns the data a signature covers: its RDATA without the
+// signature, then the records of the RRset in canonical form and order
+// (RFC 4034 3.1.8.1 and 6). A wildcard expansion is signed under the
+// wildcard name.
+func SignedData(sig *dns.RRSIG, rrset []*dns.Record) ([]byte, error) {
+	if len(rrset) == 0 {
+		return nil, errors.New("empty RRset")
+	}
+	unsigned := *sig
+	unsigned.Signature = nil
+	data, err := dns.PackRData(&unsigned, true)
+	if err != nil {
+		return nil, err
+	}
+	labels := dns.SplitName(strings.ToLower(rrset[0].Name))
+	owner := strings.Join(labels, ".")
+	if int(sig.Labels) < len(labels) {
+		owner = strings.Join(append([]string{"*"}, labels[len(labels)-int(sig.Labels):]...), ".")
+	}
+	name, err := dns.PackName(owner)
+	if err != nil {
+		return nil, err
+	}
+	var rdatas [][]byte
+	for _, r := range rrset {
+		rdata, err := dns.PackRData(r.Data, true)
+		if err != nil {
+			return nil, err
+		}
+		rdatas = append(rdatas, rdata)
+	}
+	sort.Slice(rdatas, func(i, j int) bool { return bytes.Compare(rdatas[i], rdatas[j]) < 0 })
+	for i, rdata := range rdatas {
+		if i > 0 && bytes.Equal(rdata, rdatas[i-1]) {
+			continue
+		}
+		data = append(data, name...)
+		data = binary.BigEndian.AppendUint16(data, uint16(rrset[0].Type))
+		data = binary.BigEndian.AppendUint16(data, uint16(rrset[0].Class))
+		data = binary.BigEndian.AppendUint32(data, sig.OriginalTTL)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(rdata)))
+		data = append(data, rdata...)
+	}
+	return data, nil
+}
+
+// Verify checks the RRSIG record sig of rrset against key, a DNSKEY of the
+// signer zone, at time now (RFC 4035 5.3)
+func Verify(rrset []*dns.Record, sig *dns.Record, key *dns.DNSKEY, now time.Time) error {
+	rrsig, ok := sig.Data.(*dns.RRSIG)
+	if !ok || len(rrset) == 0 {
+		return errors.New("not a signature")
+	}
+	owner := rrset[0]
+	switch {
+	case dns.CanonicalName(sig.Name) != dns.CanonicalName(owner.Name) ||
+		sig.Class != owner.Class || rrsig.TypeCovered != owner.Type:
+		return errors.New("signature does not cover the RRset")
+	case !dns.IsSubDomain(owner.Name, rrsig.SignerName):
+		return errors.New("signer is not an ancestor of the owner")
+	case int(rrsig.Labels) > LabelCount(owner.Name):
+		return errors.New("signature label count exceeds the owner")
+	case rrsig.Algorithm != key.Algorithm || rrsig.KeyTag != KeyTag(key) || !key.IsZoneKey():
+		return errors.New("signature is not made by the key")
+	}
+	if err := CheckValidity(rrsig, now); err != nil {
+		return err
+	}
+	data, err := SignedData(rrsig, rrset)
+	if err != nil {
+		return err
+	}
+	return verifySignature(key, data, rrsig.Signature)
+}
+
+// CheckValidity checks that now lies in the validity period of a signature,
+// in serial number arithmetic (RFC 4034 3.1.5)
+func CheckValidity(sig *dns.RRSIG, now time.Time) error {
+	t := uint32(now.Unix())
+	if t-sig.Inception >= 1<<31 {
+		return errors.New("signature not yet valid")
+	}
+	if sig.Expiration-t >= 1<<31 {
+		return errors.New("signature expired")
+	}
+	return nil
+}
+
+// LabelCount is the number of labels of a name as RRSIG records count
+// them, without the root nor a leading wildcard
+func LabelCount(name string) int {
+	labels := dns.SplitName(name)
+	if len(labels) > 0 && labels[0] == "*" {
+		return len(labels) - 1
+	}
+	return len(labels)
+}
+
+func verifySignature(key *dns.DNSKEY, data, signature []byte) error {
+	switch key.Algorithm {
+	case AlgorithmRSASHA1, AlgorithmRSASHA1NSEC3, AlgorithmRSASHA256, AlgorithmRSASHA512:
+		pub, err := rsaPublicKey(key.PublicKey)
+		if err != nil {
+			return err
+		}
+		h := map[uint8]crypto.Hash{
+			AlgorithmRSASHA1:      crypto.SHA1,
+			AlgorithmRSASHA1NSEC3: crypto.SHA1,
+			AlgorithmRSASHA256:    crypto.SHA256,
+			AlgorithmRSASHA512:    crypto.SHA512,
+		}[key.Algorithm]
+		digest := h.New()
+		digest.Write(data)
+		if rsa.VerifyPKCS1v15(pub, h, digest.Sum(nil), signature) != nil {
+			return errSignature
+		}
+		return nil
+	case AlgorithmECDSAP256SHA256, AlgorithmECDSAP384SHA384:
+		curve, h := elliptic.P256(), crypto.SHA256
+		if key.Algorithm == AlgorithmECDSAP384SHA384 {
+			curve, h = elliptic.P384(), crypto.SHA384
+		}
+		size := curve.Params().BitSize / 8
+		if len(key.PublicKey) != 2*size {
+			return errKey
+		}
+		if len(signature) != 2*size {
+			return errSignature
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(key.PublicKey[:size]),
+			Y:     new(big.Int).SetBytes(key.PublicKey[size:]),
+		}
+		digest := h.New()
+		digest.Write(data)
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest.Sum(nil), r, s) {
+			return errSignature
+		}
+		return nil
+	case AlgorithmED25519:
+		if len(key.PublicKey) != ed25519.PublicKeySize {
+			return errKey
+		}
+		if !ed25519.Verify(key.PublicKey, data, signature) {
+			return errSignature
+		}
+		return nil
+	}
+	return errAlgorithm
+}
+
+// rsaPublicKey decodes an RSA key: the exponent length on one octet, or on
+// three starting with zero, the exponent, then the modulus (RFC 3110 2)
+func rsaPublicKey(key []byte) (*rsa.PublicKey, error) {
+	if len(key) < 3 {
+		return nil, errKey
+	}
+	size, key := int(key[0]), key[1:]
+	if size == 0 {
+		size, key = int(binary.BigEndian.Uint16(key)), key[2:]
+	}
+	if size == 0 || size > 4 || len(key) <= size {
+		return nil, errKey
+	}
+	e := 0
+	for _, b := range key[:size] {
+		e = e<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(key[size:]), E: e}, nil
+}
+
+// RRsets groups records by owner name and type, in order of appearance,
+// leaving the signatures out
+func RRsets(records []*dns.Record) [][]*dns.Record {
+	var sets [][]*dns.Record
+	index := make(map[string]int)
+	for _, r := range records {
+		if r.Type == _type.TypeRRSIG {
+			continue
+		}
+		key := dns.CanonicalName(r.Name) + "|" + r.Type.String()
+		i, ok := index[key]
+		if !ok {
+			i = len(sets)
+			index[key] = i
+			sets = append(sets, nil)
+		}
+		sets[i] = append(sets[i], r)
+	}
+	return sets
+}
+
+// Signatures returns the RRSIG records among records covering the RRset
+// of name and type t
+func Signatures(records []*dns.Record, name string, t _type.RecordType) []*dns.Record {
+	var sigs []*dns.Record
+	for _, r := range records {
+		if sig, ok := r.Data.(*dns.RRSIG); ok && sig.TypeCovered == t &&
+			dns.CanonicalName(r.Name) == dns.CanonicalName(name) {
+			sigs = append(sigs, r)
+		}
+	}
+	return sigs
+}
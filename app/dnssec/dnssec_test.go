@@ -0,0 +1,168 @@
+package dnssec
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Unix(1700000000, 0)
+
+// The root KSK-2017 and its DS record, as published by IANA
+const (
+	rootKey = "AwEAAaz/tAm8yTn4Mfeh5eyI96WSVexTBAvkMgJzkKTOiW1vkIbzxeF3+/4RgWOq" +
+		"7HrxRixHlFlExOLAJr5emLvN7SWXgnLh4+B5xQlNVz8Og8kvArMtNROxVQuCaSnI" +
+		"DdD5LKyWbRd2n9WGe2R8PzgCmr3EgVLrjyBxWezF0jLHwVN8efS3rCj/EWgvIWgb" +
+		"9tarpVUDK/b58Da+sqqls3eNbuv7pr+eoZG+SrDK6nWeL3c6H5Apxz7LjVc1uTId" +
+		"sIXxuOLYA4/ilBmSVIzuDWfdRUfhHdY6+cn8HFRm+2hM8AnXGXws9555KrUB5qih" +
+		"ylGa8subX2Nn6UwNR1AkUTV74bU="
+	rootDS = "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"
+)
+
+func TestKeyTagAndDS(t *testing.T) {
+	public, err := base64.StdEncoding.DecodeString(rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &dns.DNSKEY{Flags: 257, Protocol: 3, Algorithm: AlgorithmRSASHA256, PublicKey: public}
+	if tag := KeyTag(key); tag != 20326 {
+		t.Errorf("key tag %d, want 20326", tag)
+	}
+	ds, err := NewDS("", key, DigestSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.ToUpper(hex.EncodeToString(ds.Digest)); got != rootDS {
+		t.Errorf("DS digest %s, want %s", got, rootDS)
+	}
+	if !MatchesDS("", ds, key) {
+		t.Error("DS does not match its key")
+	}
+	if MatchesDS("com", ds, key) {
+		t.Error("DS matches the key under another owner")
+	}
+}
+
+// The hashes of RFC 5155 Appendix A
+func TestHashName(t *testing.T) {
+	salt, _ := hex.DecodeString("aabbccdd")
+	for name, want := range map[string]string{
+		"example":   "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom",
+		"a.example": "35mthgpgcu1qg68fab165klnsnk3dpvl",
+	} {
+		got := strings.ToLower(base32.HexEncoding.EncodeToString(HashName(name, 12, salt)))
+		if got != want {
+			t.Errorf("hash of %s is %s, want %s", name, got, want)
+		}
+	}
+}
+
+// The canonical order of RFC 4034 6.1
+func TestCompare(t *testing.T) {
+	names := []string{
+		"example", "a.example", "yljkjljk.a.example", "Z.a.example",
+		"zABC.a.EXAMPLE", "z.example", "\001.z.example", "*.z.example", "\200.z.example",
+	}
+	for i := 1; i < len(names); i++ {
+		if Compare(names[i-1], names[i]) >= 0 || Compare(names[i], names[i-1]) <= 0 {
+			t.Errorf("%q does not sort before %q", names[i-1], names[i])
+		}
+	}
+	if Compare("A.Example", "a.example") != 0 {
+		t.Error("names differing in case are not equal")
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	rrset := []*dns.Record{
+		{Name: "www.example", Type: _type.TypeA, Class: _type.ClassIN, TTL: 300, Data: &dns.A{IP: net.IPv4(192, 0, 2, 1)}},
+		{Name: "www.example", Type: _type.TypeA, Class: _type.ClassIN, TTL: 300, Data: &dns.A{IP: net.IPv4(192, 0, 2, 2)}},
+	}
+	for _, algorithm := range []uint8{AlgorithmRSASHA256, AlgorithmECDSAP256SHA256, AlgorithmECDSAP384SHA384, AlgorithmED25519} {
+		private, err := GenerateKey(algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public, err := NewDNSKEY(private, algorithm, dns.FlagZoneKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := &Key{DNSKEY: public, Tag: KeyTag(public), ZSK: true, private: private}
+		sig, err := Sign(rrset, key, "example", now.Add(-time.Hour), now.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The order of the records does not matter
+		reversed := []*dns.Record{rrset[1], rrset[0]}
+		if err := Verify(reversed, sig, public, now); err != nil {
+			t.Errorf("algorithm %d: %v", algorithm, err)
+		}
+		if err := Verify(rrset, sig, public, now.Add(2*time.Hour)); err == nil {
+			t.Errorf("algorithm %d: expired signature accepted", algorithm)
+		}
+		altered := []*dns.Record{rrset[0], {Name: "www.example", Type: _type.TypeA, Class: _type.ClassIN, TTL: 300, Data: &dns.A{IP: net.IPv4(192, 0, 2, 3)}}}
+		if err := Verify(altered, sig, public, now); err == nil {
+			t.Errorf("algorithm %d: altered RRset accepted", algorithm)
+		}
+	}
+}
+
+func TestVerifyWildcardExpansion(t *testing.T) {
+	private, _ := GenerateKey(AlgorithmED25519)
+	public, _ := NewDNSKEY(private, AlgorithmED25519, dns.FlagZoneKey)
+	key := &Key{DNSKEY: public, Tag: KeyTag(public), ZSK: true, private: private}
+	wildcard := []*dns.Record{{Name: "*.example", Type: _type.TypeTXT, Class: _type.ClassIN, TTL: 300, Data: &dns.TXT{Strings: []string{"any"}}}}
+	sig, err := Sign(wildcard, key, "example", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expanded := *wildcard[0]
+	expanded.Name = "host.example"
+	expandedSig := *sig
+	expandedSig.Name = "host.example"
+	if err := Verify([]*dns.Record{&expanded}, &expandedSig, public, now); err != nil {
+		t.Error(err)
+	}
+}
+
+func nsec(owner, next string, types ..._type.RecordType) *dns.Record {
+	return &dns.Record{
+		Name:  owner,
+		Type:  _type.TypeNSEC,
+		Class: _type.ClassIN,
+		TTL:   300,
+		Data:  &dns.NSEC{NextDomain: next, Types: types},
+	}
+}
+
+func TestNSECProofs(t *testing.T) {
+	apex := nsec("example", "a.example", _type.TypeSOA, _type.TypeNS, _type.TypeNSEC, _type.TypeRRSIG)
+	a := nsec("a.example", "c.example", _type.TypeA, _type.TypeNSEC, _type.TypeRRSIG)
+	delegation := nsec("c.example", "example", _type.TypeNS, _type.TypeNSEC, _type.TypeRRSIG)
+
+	full := NewProof("example", []*dns.Record{apex, a, delegation})
+	for _, c := range []struct {
+		name  string
+		check Security
+		want  Security
+	}{
+		{"b.example name error", full.NameError("b.example"), Secure},
+		{"b.example without the wildcard proof", NewProof("example", []*dns.Record{a}).NameError("b.example"), Bogus},
+		{"a.example name error", full.NameError("a.example"), Bogus},
+		{"a.example MX", full.NoData("a.example", _type.TypeMX), Secure},
+		{"a.example A", full.NoData("a.example", _type.TypeA), Bogus},
+		{"c.example DS", full.NoData("c.example", _type.TypeDS), Secure},
+		{"c.example A at a delegation", full.NoData("c.example", _type.TypeA), Bogus},
+		{"example DS from the child apex", full.NoData("example", _type.TypeDS), Bogus},
+	} {
+		if c.check != c.want {
+			t.Errorf("%s: %s, want %s", c.name, c.check, c.want)
+		}
+	}
+}
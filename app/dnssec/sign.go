@@ -0,0 +1,256 @@
+package dnssec
+
+import (
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"time"
+)
+
+// Roles of the keys of a signed zone
+const (
+	RoleKSK = "ksk"
+	RoleZSK = "zsk"
+	RoleCSK = "csk"
+)
+
+// rsaBits is the size of the RSA keys generated
+const rsaBits = 2048
+
+// Key is a private key signing a zone, with its rollover schedule
+// (RFC 7583): published in the DNSKEY RRset from Publish until Remove, and
+// signing from Activate until Retire. A zero time leaves its phase open.
+type Key struct {
+	DNSKEY *dns.DNSKEY
+	Tag    uint16
+	// A KSK signs the DNSKEY RRset and a ZSK the other RRsets, a combined
+	// signing key does both
+	KSK, ZSK bool
+
+	Publish, Activate, Retire, Remove time.Time
+
+	private crypto.Signer
+}
+
+// LoadKey reads the private key of a zone from its PEM file, generating and
+// saving a new one when the file does not exist
+func LoadKey(c *config.DNSSECKeyConfig) (*Key, error) {
+	algorithm := c.Algorithm
+	if algorithm == 0 {
+		algorithm = AlgorithmECDSAP256SHA256
+	}
+	k := &Key{
+		Publish:  c.Publish,
+		Activate: c.Activate,
+		Retire:   c.Retire,
+		Remove:   c.Remove,
+	}
+	switch c.Role {
+	case RoleKSK:
+		k.KSK = true
+	case RoleZSK:
+		k.ZSK = true
+	case RoleCSK, "":
+		k.KSK, k.ZSK = true, true
+	default:
+		return nil, fmt.Errorf("unknown role %q of key %s", c.Role, c.File)
+	}
+
+	private, err := readPrivateKey(c.File)
+	if errors.Is(err, fs.ErrNotExist) {
+		if private, err = GenerateKey(algorithm); err == nil {
+			err = writePrivateKey(c.File, private)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", c.File, err)
+	}
+	flags := dns.FlagZoneKey
+	if k.KSK {
+		flags |= dns.FlagSecureEntry
+	}
+	if k.DNSKEY, err = NewDNSKEY(private, algorithm, flags); err != nil {
+		return nil, fmt.Errorf("key %s: %w", c.File, err)
+	}
+	k.Tag = KeyTag(k.DNSKEY)
+	k.private = private
+	return k, nil
+}
+
+// GenerateKey returns a new private key for the algorithm
+func GenerateKey(algorithm uint8) (crypto.Signer, error) {
+	switch algorithm {
+	case AlgorithmRSASHA256, AlgorithmRSASHA512:
+		return rsa.GenerateKey(rand.Reader, rsaBits)
+	case AlgorithmECDSAP256SHA256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmECDSAP384SHA384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case AlgorithmED25519:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	}
+	return nil, errAlgorithm
+}
+
+// NewDNSKEY returns the public DNSKEY of a private key, which must be of
+// the kind the algorithm signs with
+func NewDNSKEY(private crypto.Signer, algorithm uint8, flags uint16) (*dns.DNSKEY, error) {
+	key := &dns.DNSKEY{Flags: flags, Protocol: 3, Algorithm: algorithm}
+	switch p := private.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != AlgorithmRSASHA256 && algorithm != AlgorithmRSASHA512 {
+			return nil, errAlgorithm
+		}
+		// RFC 3110 2
+		e := big.NewInt(int64(p.E)).Bytes()
+		if len(e) < 256 {
+			key.PublicKey = append(key.PublicKey, byte(len(e)))
+		} else {
+			key.PublicKey = binary.BigEndian.AppendUint16([]byte{0}, uint16(len(e)))
+		}
+		key.PublicKey = append(key.PublicKey, e...)
+		key.PublicKey = append(key.PublicKey, p.N.Bytes()...)
+	case *ecdsa.PrivateKey:
+		curve := elliptic.P256()
+		if algorithm == AlgorithmECDSAP384SHA384 {
+			curve = elliptic.P384()
+		} else if algorithm != AlgorithmECDSAP256SHA256 {
+			return nil, errAlgorithm
+		}
+		if p.Curve != curve {
+			return nil, errKey
+		}
+		size := curve.Params().BitSize / 8
+		key.PublicKey = make([]byte, 2*size)
+		p.X.FillBytes(key.PublicKey[:size])
+		p.Y.FillBytes(key.PublicKey[size:])
+	case ed25519.PrivateKey:
+		if algorithm != AlgorithmED25519 {
+			return nil, errAlgorithm
+		}
+		key.PublicKey = append([]byte(nil), p.Public().(ed25519.PublicKey)...)
+	default:
+		return nil, errKey
+	}
+	return key, nil
+}
+
+// Published reports whether the key is in the DNSKEY RRset at now
+func (k *Key) Published(now time.Time) bool {
+	return !now.Before(k.Publish) && (k.Remove.IsZero() || now.Before(k.Remove))
+}
+
+// Active reports whether the key signs at now
+func (k *Key) Active(now time.Time) bool {
+	return k.Published(now) && !now.Before(k.Activate) && (k.Retire.IsZero() || now.Before(k.Retire))
+}
+
+// Sign returns the RRSIG record of rrset made with key for zone signer,
+// valid from inception to expiration (RFC 4034 3). A wildcard RRset is
+// signed under its wildcard owner name.
+func Sign(rrset []*dns.Record, key *Key, signer string, inception, expiration time.Time) (*dns.Record, error) {
+	if len(rrset) == 0 {
+		return nil, errors.New("empty RRset")
+	}
+	owner := rrset[0]
+	sig := &dns.RRSIG{
+		TypeCovered: owner.Type,
+		Algorithm:   key.DNSKEY.Algorithm,
+		Labels:      uint8(LabelCount(owner.Name)),
+		OriginalTTL: owner.TTL,
+		Expiration:  uint32(expiration.Unix()),
+		Inception:   uint32(inception.Unix()),
+		KeyTag:      key.Tag,
+		SignerName:  signer,
+	}
+	data, err := SignedData(sig, rrset)
+	if err != nil {
+		return nil, err
+	}
+	if sig.Signature, err = key.sign(data); err != nil {
+		return nil, err
+	}
+	return &dns.Record{
+		Name:  owner.Name,
+		Type:  _type.TypeRRSIG,
+		Class: owner.Class,
+		TTL:   owner.TTL,
+		Data:  sig,
+	}, nil
+}
+
+func (k *Key) sign(data []byte) ([]byte, error) {
+	switch p := k.private.(type) {
+	case *rsa.PrivateKey:
+		h := crypto.SHA256
+		if k.DNSKEY.Algorithm == AlgorithmRSASHA512 {
+			h = crypto.SHA512
+		}
+		digest := h.New()
+		digest.Write(data)
+		return rsa.SignPKCS1v15(rand.Reader, p, h, digest.Sum(nil))
+	case *ecdsa.PrivateKey:
+		h := crypto.SHA256
+		if k.DNSKEY.Algorithm == AlgorithmECDSAP384SHA384 {
+			h = crypto.SHA384
+		}
+		digest := h.New()
+		digest.Write(data)
+		r, s, err := ecdsa.Sign(rand.Reader, p, digest.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		// r and s each padded to the size of the curve (RFC 6605 4)
+		size := p.Curve.Params().BitSize / 8
+		signature := make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+		return signature, nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(p, data), nil
+	}
+	return nil, errKey
+}
+
+func readPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, errKey
+	}
+	return signer, nil
+}
+
+func writePrivateKey(path string, private crypto.Signer) error {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
@@ -0,0 +1,245 @@
+// Package health checks the addresses of local names over TCP or HTTP, so
+// that failing ones are left out of the answers until they recover.
+package health
+
+import (
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	"com.sentry.dev/app/metrics"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kinds of checks
+const (
+	TypeTCP  = "tcp"
+	TypeHTTP = "http"
+)
+
+// Defaults of the optional settings of a check
+const (
+	defaultInterval = 10 * time.Second
+	defaultTimeout  = 2 * time.Second
+	defaultFall     = 3
+	defaultRise     = 2
+)
+
+// Checker holds the health of the addresses of every checked name
+type Checker struct {
+	checks map[string]*check
+}
+
+// check probes the addresses of one name
+type check struct {
+	name     string
+	kind     string
+	port     int
+	path     string
+	interval time.Duration
+	timeout  time.Duration
+	fall     int
+	rise     int
+	fallback []net.IP
+	client   *http.Client
+
+	mu      sync.RWMutex
+	targets map[string]*target
+}
+
+// target is an address of a name and its recent results. It takes fall
+// failures in a row to withdraw it and rise successes to restore it.
+type target struct {
+	ip        net.IP
+	healthy   bool
+	successes int
+	failures  int
+}
+
+// New prepares the checks of the configured names. Their addresses are
+// given to Watch.
+func New(configs []config.HealthCheckConfig) (*Checker, error) {
+	checker := &Checker{checks: make(map[string]*check)}
+	for _, c := range configs {
+		name := dns.CanonicalName(c.Name)
+		if _, ok := checker.checks[name]; ok {
+			return nil, fmt.Errorf("health check of %s configured twice", c.Name)
+		}
+		if c.Type != TypeTCP && c.Type != TypeHTTP {
+			return nil, fmt.Errorf("unknown health check type %q of %s", c.Type, c.Name)
+		}
+		if c.Port <= 0 || c.Port > 65535 {
+			return nil, fmt.Errorf("invalid health check port %d of %s", c.Port, c.Name)
+		}
+		chk := &check{
+			name:     name,
+			kind:     c.Type,
+			port:     c.Port,
+			path:     c.Path,
+			interval: orDefault(time.Duration(c.Interval)*time.Second, defaultInterval),
+			timeout:  orDefault(time.Duration(c.Timeout)*time.Second, defaultTimeout),
+			fall:     orDefault(c.Fall, defaultFall),
+			rise:     orDefault(c.Rise, defaultRise),
+			targets:  make(map[string]*target),
+			client: &http.Client{
+				// every probe opens a new connection, as clients would
+				Transport: &http.Transport{DisableKeepAlives: true},
+				// a redirect is an answer of a live server
+				CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			},
+		}
+		if chk.path == "" {
+			chk.path = "/"
+		}
+		for _, value := range c.Fallback {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid fallback address %q of %s", value, c.Name)
+			}
+			chk.fallback = append(chk.fallback, ip)
+		}
+		checker.checks[name] = chk
+	}
+	return checker, nil
+}
+
+func orDefault[T comparable](value, fallback T) T {
+	var zero T
+	if value == zero {
+		return fallback
+	}
+	return value
+}
+
+// Names returns the checked names
+func (c *Checker) Names() []string {
+	var names []string
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Watch checks the addresses of name until ctx is done. They are served
+// until their checks fail.
+func (c *Checker) Watch(ctx context.Context, name string, ips []net.IP) {
+	chk := c.checks[dns.CanonicalName(name)]
+	if chk == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	chk.mu.Lock()
+	for _, ip := range ips {
+		t := &target{ip: ip, healthy: true}
+		chk.targets[ip.String()] = t
+		metrics.HealthyTargets.WithLabelValues(chk.name, ip.String()).Set(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chk.run(ctx, t)
+		}()
+	}
+	chk.mu.Unlock()
+	wg.Wait()
+}
+
+// Filter returns the healthy addresses among ips, the ones a view gives
+// name, the fallback addresses when none is, or all of them without a
+// fallback.
+// Addresses of names not checked are returned as is.
+func (c *Checker) Filter(name string, ips []net.IP) []net.IP {
+	if c == nil {
+		return ips
+	}
+	chk := c.checks[dns.CanonicalName(name)]
+	if chk == nil {
+		return ips
+	}
+	chk.mu.RLock()
+	defer chk.mu.RUnlock()
+	var healthy []net.IP
+	for _, ip := range ips {
+		if t := chk.targets[ip.String()]; t == nil || t.healthy {
+			healthy = append(healthy, ip)
+		}
+	}
+	switch {
+	case len(healthy) > 0:
+		return healthy
+	case len(chk.fallback) > 0:
+		return chk.fallback
+	}
+	return ips
+}
+
+// run probes a target every interval until ctx is done
+func (chk *check) run(ctx context.Context, t *target) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		chk.record(t, chk.probe(ctx, t.ip))
+		timer.Reset(chk.interval)
+	}
+}
+
+// record counts the result of a probe and changes the health of the
+// target once enough results in a row agree
+func (chk *check) record(t *target, err error) {
+	chk.mu.Lock()
+	defer chk.mu.Unlock()
+	if err == nil {
+		t.successes, t.failures = t.successes+1, 0
+		if !t.healthy && t.successes >= chk.rise {
+			t.healthy = true
+			log.Println("Health check of", dns.Fqdn(chk.name), t.ip, "passes, address restored")
+			metrics.HealthyTargets.WithLabelValues(chk.name, t.ip.String()).Set(1)
+		}
+		return
+	}
+	t.successes, t.failures = 0, t.failures+1
+	if t.healthy && t.failures >= chk.fall {
+		t.healthy = false
+		log.Println("Health check of", dns.Fqdn(chk.name), t.ip, "fails, address withdrawn:", err)
+		metrics.HealthyTargets.WithLabelValues(chk.name, t.ip.String()).Set(0)
+	}
+}
+
+// probe connects to the address, then for HTTP asks for the path under
+// the checked name and expects a 2xx or 3xx status
+func (chk *check) probe(ctx context.Context, ip net.IP) error {
+	ctx, cancel := context.WithTimeout(ctx, chk.timeout)
+	defer cancel()
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(chk.port))
+	if chk.kind == TypeTCP {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+chk.path, nil)
+	if err != nil {
+		return err
+	}
+	req.Host = chk.name
+	resp, err := chk.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package health
+
+import (
+	"com.sentry.dev/app/config"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newChecker(t *testing.T, configs ...config.HealthCheckConfig) *Checker {
+	t.Helper()
+	checker, err := New(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return checker
+}
+
+func ips(values ...string) []net.IP {
+	var ips []net.IP
+	for _, value := range values {
+		ips = append(ips, net.ParseIP(value))
+	}
+	return ips
+}
+
+func equal(a, b []net.IP) bool {
+	return slices.EqualFunc(a, b, net.IP.Equal)
+}
+
+// eventually waits for cond to hold, polling it
+func eventually(t *testing.T, cond func() bool, what string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("timed out waiting for", what)
+}
+
+func TestNewRejectsBadChecks(t *testing.T) {
+	for _, c := range [][]config.HealthCheckConfig{
+		{{Name: "app.example", Type: "icmp", Port: 80}},
+		{{Name: "app.example", Type: TypeTCP}},
+		{{Name: "app.example", Type: TypeTCP, Port: 80, Fallback: []string{"not an address"}}},
+		{{Name: "app.example", Type: TypeTCP, Port: 80}, {Name: "APP.example.", Type: TypeTCP, Port: 81}},
+	} {
+		if _, err := New(c); err == nil {
+			t.Errorf("checks %+v accepted", c)
+		}
+	}
+}
+
+func TestFallAndRise(t *testing.T) {
+	checker := newChecker(t, config.HealthCheckConfig{Name: "app.example", Type: TypeTCP, Port: 80, Fall: 3, Rise: 2})
+	chk := checker.checks["app.example"]
+	tg := &target{ip: net.ParseIP("192.0.2.1"), healthy: true}
+	chk.targets[tg.ip.String()] = tg
+	failure := errors.New("connection refused")
+
+	for i, c := range []struct {
+		err     error
+		healthy bool
+	}{
+		{failure, true},
+		{failure, true},
+		// a success resets the failures counted
+		{nil, true},
+		{failure, true},
+		{failure, true},
+		{failure, false},
+		{nil, false},
+		{failure, false},
+		{nil, false},
+		{nil, true},
+	} {
+		chk.record(tg, c.err)
+		if tg.healthy != c.healthy {
+			t.Fatalf("result %d: healthy %v, want %v", i, tg.healthy, c.healthy)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	checker := newChecker(t,
+		config.HealthCheckConfig{Name: "app.example", Type: TypeTCP, Port: 80, Fallback: []string{"198.51.100.1"}},
+		config.HealthCheckConfig{Name: "db.example", Type: TypeTCP, Port: 5432},
+	)
+	for name, values := range map[string][]string{
+		"app.example": {"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+		"db.example":  {"192.0.2.10", "192.0.2.11"},
+	} {
+		chk := checker.checks[name]
+		for _, ip := range ips(values...) {
+			chk.targets[ip.String()] = &target{ip: ip, healthy: true}
+		}
+	}
+	setHealthy := func(name, ip string, healthy bool) {
+		checker.checks[name].targets[ip].healthy = healthy
+	}
+
+	all := ips("192.0.2.1", "192.0.2.2", "192.0.2.3")
+	if got := checker.Filter("App.Example.", all); !equal(got, all) {
+		t.Errorf("all healthy: %v", got)
+	}
+	setHealthy("app.example", "192.0.2.2", false)
+	if got := checker.Filter("app.example", all); !equal(got, ips("192.0.2.1", "192.0.2.3")) {
+		t.Errorf("one failing: %v", got)
+	}
+	// The addresses a view gives the name are filtered on their own
+	if got := checker.Filter("app.example", ips("192.0.2.2")); !equal(got, ips("198.51.100.1")) {
+		t.Errorf("view with its only address failing: %v", got)
+	}
+	setHealthy("app.example", "192.0.2.1", false)
+	setHealthy("app.example", "192.0.2.3", false)
+	if got := checker.Filter("app.example", all); !equal(got, ips("198.51.100.1")) {
+		t.Errorf("all failing: %v, want the fallback", got)
+	}
+
+	setHealthy("db.example", "192.0.2.10", false)
+	setHealthy("db.example", "192.0.2.11", false)
+	db := ips("192.0.2.10", "192.0.2.11")
+	if got := checker.Filter("db.example", db); !equal(got, db) {
+		t.Errorf("all failing without a fallback: %v, want them all", got)
+	}
+
+	// Names and addresses not checked are answered as is
+	if got := checker.Filter("www.example", db); !equal(got, db) {
+		t.Errorf("unchecked name: %v", got)
+	}
+	if got := checker.Filter("app.example", ips("192.0.2.99")); !equal(got, ips("192.0.2.99")) {
+		t.Errorf("unchecked address: %v", got)
+	}
+	var none *Checker
+	if got := none.Filter("app.example", db); !equal(got, db) {
+		t.Errorf("without health checks: %v", got)
+	}
+}
+
+func TestWatchTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	checker := newChecker(t, config.HealthCheckConfig{Name: "app.example", Type: TypeTCP, Port: port, Fall: 1, Rise: 1})
+	checker.checks["app.example"].interval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// 127.0.0.2 listens on nothing
+	addrs := ips("127.0.0.1", "127.0.0.2")
+	go checker.Watch(ctx, "app.example", addrs)
+
+	eventually(t, func() bool {
+		return equal(checker.Filter("app.example", addrs), ips("127.0.0.1"))
+	}, "the address without a listener to be withdrawn")
+
+	ln.Close()
+	eventually(t, func() bool {
+		// Filter answers both once both fail, without a fallback
+		chk := checker.checks["app.example"]
+		chk.mu.RLock()
+		defer chk.mu.RUnlock()
+		return !chk.targets["127.0.0.1"].healthy
+	}, "the closed listener to be withdrawn")
+}
+
+func TestWatchHTTP(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	var host atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host.Store(r.Host)
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+	addr := netip.MustParseAddrPort(srv.Listener.Addr().String())
+
+	checker := newChecker(t, config.HealthCheckConfig{
+		Name:     "app.example",
+		Type:     TypeHTTP,
+		Port:     int(addr.Port()),
+		Path:     "/healthz",
+		Fall:     2,
+		Rise:     2,
+		Fallback: []string{"198.51.100.1"},
+	})
+	checker.checks["app.example"].interval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addrs := ips(addr.Addr().String())
+	go checker.Watch(ctx, "app.example.", addrs)
+
+	eventually(t, func() bool { return host.Load() != nil }, "a probe")
+	if got := host.Load(); got != "app.example" {
+		t.Errorf("probe with Host %q, want the checked name", got)
+	}
+	status.Store(http.StatusServiceUnavailable)
+	eventually(t, func() bool {
+		return equal(checker.Filter("app.example", addrs), ips("198.51.100.1"))
+	}, "the failing address to give way to the fallback")
+
+	// a redirect is the answer of a live server
+	status.Store(http.StatusFound)
+	eventually(t, func() bool {
+		return equal(checker.Filter("app.example", addrs), addrs)
+	}, "the address to be restored")
+}
+
+func TestWatchUncheckedName(t *testing.T) {
+	checker := newChecker(t)
+	done := make(chan struct{})
+	go func() {
+		checker.Watch(context.Background(), "app.example", ips("192.0.2.1"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Watch of a name without a check did not return")
+	}
+	if len(checker.Names()) != 0 {
+		t.Errorf("names %v", checker.Names())
+	}
+}
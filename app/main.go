@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/metrics"
 	"com.sentry.dev/app/server"
 	"com.sentry.dev/app/utils"
 	"context"
@@ -16,6 +17,9 @@ var Version string
 
 func main() {
 	appConfig := config.Load()
+	if appConfig.Dnstap.Version == "" {
+		appConfig.Dnstap.Version = Version
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 
 	udpServer := server.UDPServer{
@@ -24,8 +28,16 @@ func main() {
 		CancelFunc: cancel,
 	}
 
+	metricsServer := metrics.Server{
+		Config: appConfig,
+	}
+
 	utils.PrintBanner()
 	udpServer.Start()
+	if appConfig.Metrics.Enabled {
+		metricsServer.Start()
+		defer metricsServer.Stop()
+	}
 	fmt.Println("Binary version: ", Version)
 	fmt.Println("Server started successfully!")
 	utils.PrintSeparator()
@@ -48,6 +60,8 @@ func main() {
 			case "stop":
 				stopServer(&udpServer)
 				return
+			case "reload":
+				udpServer.ReloadZones()
 			default:
 				fmt.Println("Unknown command ", cmd)
 			}
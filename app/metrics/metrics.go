@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "mydns"
+
+var (
+	// Queries counts answered questions by record type, response code and transport
+	Queries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queries_total",
+		Help:      "Answered DNS questions by record type, response code and transport.",
+	}, []string{"type", "rcode", "transport"})
+
+	// CacheHits counts lookups answered from the cache
+	CacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_hits_total",
+		Help:      "Lookups answered from the cache.",
+	})
+
+	// CacheMisses counts lookups not found in the cache
+	CacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_misses_total",
+		Help:      "Lookups not found in the cache.",
+	})
+
+	// BlockedQueries counts questions refused by a blocklist, by list name
+	BlockedQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blocked_queries_total",
+		Help:      "Questions blocked by a blocklist, by list.",
+	}, []string{"list"})
+
+	// UpstreamDuration observes the latency of upstream lookups
+	UpstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Latency of upstream lookups.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"upstream"})
+
+	// UpstreamErrors counts failed upstream lookups
+	UpstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Failed upstream lookups.",
+	}, []string{"upstream"})
+
+	// EventQueueDropped counts requests dropped because the event queue is full
+	EventQueueDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_queue_dropped_total",
+		Help:      "Requests dropped because the event queue was full.",
+	})
+
+	// RateLimitedQueries counts queries dropped by the per-client rate limits
+	RateLimitedQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_queries_total",
+		Help:      "Queries dropped by the rate limits, by limit (client, subnet).",
+	}, []string{"reason"})
+
+	// RRLResponses counts responses limited by Response Rate Limiting
+	RRLResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rrl_responses_total",
+		Help:      "Responses limited by Response Rate Limiting, by action (slip, drop).",
+	}, []string{"action"})
+
+	// DeniedQueries counts queries refused or dropped by the access-control lists
+	DeniedQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "denied_queries_total",
+		Help:      "Queries denied by the access-control lists, by action (refuse, drop).",
+	}, []string{"action"})
+
+	// HealthyTargets reports whether each health-checked address of a name
+	// is served
+	HealthyTargets = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "healthy_targets",
+		Help:      "Health-checked addresses of a name, 1 while healthy and served.",
+	}, []string{"name", "address"})
+
+	// WorkersSaturated counts requests that had to wait for a free worker
+	WorkersSaturated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workers_saturated_total",
+		Help:      "Requests that had to wait because every worker was busy.",
+	})
+)
+
+// WatchEventQueue exports the depth and capacity of the event queue
+func WatchEventQueue(depth func() int, capacity int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "event_queue_depth",
+		Help:      "Requests waiting in the event queue.",
+	}, func() float64 { return float64(depth()) })
+	promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "event_queue_capacity",
+		Help:      "Capacity of the event queue.",
+	}).Set(float64(capacity))
+}
+
+// WatchWorkers exports the number of busy workers and the pool size
+func WatchWorkers(busy func() int, capacity int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_busy",
+		Help:      "Workers currently handling a request.",
+	}, func() float64 { return float64(busy()) })
+	promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_capacity",
+		Help:      "Size of the worker pool.",
+	}).Set(float64(capacity))
+}
+
+// WatchCacheEvictions exports the cache eviction counters reported by stats,
+// keyed by reason (expired, evicted)
+func WatchCacheEvictions(stats func() map[string]float64) {
+	prometheus.MustRegister(&evictionCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cache", "evictions_total"),
+			"Entries removed from the cache, by reason.",
+			[]string{"reason"},
+			nil,
+		),
+		stats: stats,
+	})
+}
+
+type evictionCollector struct {
+	desc  *prometheus.Desc
+	stats func() map[string]float64
+}
+
+func (c *evictionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *evictionCollector) Collect(ch chan<- prometheus.Metric) {
+	for reason, value := range c.stats() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, value, reason)
+	}
+}
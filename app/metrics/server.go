@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"com.sentry.dev/app/config"
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+type Server struct {
+	Config *config.Config
+
+	httpServer *http.Server
+}
+
+// Start serving the metrics endpoint
+func (server *Server) Start() {
+	listener, err := net.Listen("tcp", server.Config.Metrics.Address)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(server.Config.Metrics.Path, promhttp.Handler())
+	server.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := server.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("metrics server:", err)
+		}
+	}()
+}
+
+// Stop the metrics endpoint gracefully
+func (server *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.httpServer.Shutdown(ctx); err != nil {
+		log.Println("Error closing metrics server:", err)
+	}
+}
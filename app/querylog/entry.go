@@ -0,0 +1,29 @@
+package querylog
+
+import "time"
+
+// Answer sources recorded in the query log
+const (
+	SourceLocal    = "local"
+	SourceCache    = "cache"
+	SourceUpstream = "upstream"
+	SourceForward  = "forward"
+	SourceResolver = "resolver"
+	SourceBlocked  = "blocked"
+	SourceACL      = "acl"
+	SourceNone     = "none"
+	SourceUpdate   = "update"
+)
+
+// Entry is a single record of the query log, one per answered question
+type Entry struct {
+	Time      time.Time `json:"timestamp"`
+	Client    string    `json:"client"`
+	QName     string    `json:"qname"`
+	QType     string    `json:"qtype"`
+	RCode     string    `json:"rcode"`
+	Answers   int       `json:"answers"`
+	Source    string    `json:"source"`
+	LatencyMs float64   `json:"latency_ms"`
+	Transport string    `json:"transport"`
+}
@@ -0,0 +1,92 @@
+package querylog
+
+import (
+	"com.sentry.dev/app/config"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+)
+
+// Logger writes sampled query log entries to a sink in the background
+type Logger struct {
+	sampleRate float64
+	sink       Sink
+	entries    chan *Entry
+	wg         sync.WaitGroup
+}
+
+// New creates a Logger from the query log configuration. It returns nil when
+// query logging is disabled; a nil Logger discards every entry.
+func New(cfg *config.QueryLogConfig) (*Logger, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	sink, err := newSink(cfg)
+	if err != nil {
+		return nil, err
+	}
+	logger := &Logger{
+		sampleRate: cfg.SampleRate,
+		sink:       sink,
+		entries:    make(chan *Entry, cfg.BufferSize),
+	}
+	logger.wg.Add(1)
+	go logger.run()
+	return logger, nil
+}
+
+func newSink(cfg *config.QueryLogConfig) (Sink, error) {
+	switch cfg.Sink {
+	case "stdout":
+		return stdoutSink{}, nil
+	case "file":
+		return newFileSink(cfg.FilePath, int64(cfg.MaxSizeMB)<<20, cfg.MaxBackups)
+	case "syslog":
+		return newSyslogSink(cfg.SyslogNetwork, cfg.SyslogAddress)
+	default:
+		return nil, fmt.Errorf("unknown query log sink %q", cfg.Sink)
+	}
+}
+
+// Log queues an entry unless it is sampled out or the buffer is full
+func (logger *Logger) Log(entry *Entry) {
+	if logger == nil {
+		return
+	}
+	if logger.sampleRate < 1 && rand.Float64() >= logger.sampleRate {
+		return
+	}
+	select {
+	case logger.entries <- entry:
+	default:
+		log.Println("query log buffer is full")
+	}
+}
+
+// Close flushes the queued entries and closes the sink
+func (logger *Logger) Close() {
+	if logger == nil {
+		return
+	}
+	close(logger.entries)
+	logger.wg.Wait()
+	if err := logger.sink.Close(); err != nil {
+		log.Println("Error closing query log:", err)
+	}
+}
+
+func (logger *Logger) run() {
+	defer logger.wg.Done()
+	for entry := range logger.entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Println("query log:", err)
+			continue
+		}
+		if err = logger.sink.Write(append(line, '\n')); err != nil {
+			log.Println("query log:", err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package querylog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Sink receives encoded query log lines
+type Sink interface {
+	Write(line []byte) error
+	Close() error
+}
+
+type stdoutSink struct{}
+
+func (s stdoutSink) Write(line []byte) error {
+	_, err := os.Stdout.Write(line)
+	return err
+}
+
+func (s stdoutSink) Close() error {
+	return nil
+}
+
+// fileSink appends lines to a file and rotates it once it grows past maxSize,
+// keeping at most maxBackups rotated copies as path.1, path.2, ...
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newFileSink(path string, maxSize int64, maxBackups int) (*fileSink, error) {
+	if path == "" {
+		return nil, errors.New("query log file path is empty")
+	}
+	s := &fileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if s.maxBackups > 0 {
+		for i := s.maxBackups - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) Write(line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxSize > 0 && s.size+int64(len(line)) > s.maxSize && s.size > 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
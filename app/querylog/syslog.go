@@ -0,0 +1,27 @@
+//go:build !windows && !plan9
+
+package querylog
+
+import "log/syslog"
+
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+// newSyslogSink connects to the syslog daemon at address over network,
+// or to the local daemon when both are empty
+func newSyslogSink(network, address string) (Sink, error) {
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, "mydns")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) Write(line []byte) error {
+	return s.writer.Info(string(line))
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package querylog
+
+import "errors"
+
+func newSyslogSink(network, address string) (Sink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
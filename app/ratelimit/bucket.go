@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle buckets are dropped from a Limiter
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// limited counts the requests refused, for the slip of RRL
+	limited int
+}
+
+// Limiter keeps one token bucket per key, refilled at rate tokens per second
+// up to burst tokens
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewLimiter creates a Limiter; it returns nil, which allows everything, when
+// rate is not positive
+func NewLimiter(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of key and reports whether one was available
+func (l *Limiter) Allow(key string) bool {
+	b := l.reserve(key, time.Now())
+	defer l.release()
+	if !b.available() {
+		return false
+	}
+	b.take()
+	return true
+}
+
+// reserve locks l and returns the bucket of key refilled up to now, until
+// release. A nil Limiter has no bucket, which never runs out.
+func (l *Limiter) reserve(key string, now time.Time) *bucket {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	return b
+}
+
+// release unlocks l after reserve
+func (l *Limiter) release() {
+	if l != nil {
+		l.mu.Unlock()
+	}
+}
+
+func (b *bucket) available() bool {
+	return b == nil || b.tokens >= 1
+}
+
+func (b *bucket) take() {
+	if b != nil {
+		b.tokens--
+	}
+}
+
+// sweep drops the buckets that have refilled completely, they behave exactly
+// like a new bucket
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"com.sentry.dev/app/config"
+	"net/netip"
+	"time"
+)
+
+// Reasons reported when a query is rejected
+const (
+	ReasonClient = "client"
+	ReasonSubnet = "subnet"
+)
+
+// QueryLimiter limits incoming queries per source address and per source subnet
+type QueryLimiter struct {
+	client     *Limiter
+	subnet     *Limiter
+	ipv4Prefix int
+	ipv6Prefix int
+}
+
+// NewQueryLimiter creates a QueryLimiter; it returns nil, which allows
+// everything, when rate limiting is disabled
+func NewQueryLimiter(cfg *config.RateLimitConfig) *QueryLimiter {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &QueryLimiter{
+		client:     NewLimiter(cfg.QueriesPerSecond, cfg.Burst),
+		subnet:     NewLimiter(cfg.SubnetQueriesPerSecond, cfg.SubnetBurst),
+		ipv4Prefix: cfg.IPv4Prefix,
+		ipv6Prefix: cfg.IPv6Prefix,
+	}
+}
+
+// Allow reports whether a query from addr may be processed, and if not,
+// which limit rejected it. Tokens are only taken when both limits allow
+// the query, so that queries rejected by one do not drain the other.
+func (l *QueryLimiter) Allow(addr netip.Addr) (ok bool, reason string) {
+	if l == nil {
+		return true, ""
+	}
+	addr = addr.Unmap()
+	now := time.Now()
+	client := l.client.reserve(addr.String(), now)
+	defer l.client.release()
+	subnet := l.subnet.reserve(subnetKey(addr, l.ipv4Prefix, l.ipv6Prefix), now)
+	defer l.subnet.release()
+	switch {
+	case !client.available():
+		return false, ReasonClient
+	case !subnet.available():
+		return false, ReasonSubnet
+	}
+	client.take()
+	subnet.take()
+	return true, ""
+}
+
+func subnetKey(addr netip.Addr, ipv4Prefix, ipv6Prefix int) string {
+	bits := ipv6Prefix
+	if addr.Is4() {
+		bits = ipv4Prefix
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr.String()
+	}
+	return prefix.String()
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"com.sentry.dev/app/config"
+	"net/netip"
+	"testing"
+)
+
+func TestQueryLimiterTakesTokensOnlyWhenBothAllow(t *testing.T) {
+	l := NewQueryLimiter(&config.RateLimitConfig{
+		Enabled:                true,
+		QueriesPerSecond:       0.001,
+		Burst:                  3,
+		SubnetQueriesPerSecond: 0.001,
+		SubnetBurst:            2,
+		IPv4Prefix:             24,
+	})
+	client := netip.MustParseAddr("192.0.2.1")
+	for i := 0; i < 2; i++ {
+		if ok, reason := l.Allow(client); !ok {
+			t.Fatalf("query %d rejected by the %s limit", i, reason)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if ok, reason := l.Allow(client); ok || reason != ReasonSubnet {
+			t.Fatalf("query over the subnet limit: allowed %v, reason %q", ok, reason)
+		}
+	}
+	// The queries rejected by the subnet limit left the client its token
+	if tokens := l.client.buckets[client.String()].tokens; tokens < 1 {
+		t.Errorf("client left with %.2f tokens, want 1", tokens)
+	}
+	if tokens := l.subnet.buckets["192.0.2.0/24"].tokens; tokens >= 1 {
+		t.Errorf("subnet left with %.2f tokens, want none", tokens)
+	}
+}
+
+func TestQueryLimiterDisabled(t *testing.T) {
+	l := NewQueryLimiter(&config.RateLimitConfig{QueriesPerSecond: 1, Burst: 1})
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.Allow(netip.MustParseAddr("192.0.2.1")); !ok {
+			t.Fatal("query rejected with rate limiting disabled")
+		}
+	}
+}
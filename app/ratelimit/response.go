@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"com.sentry.dev/app/config"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Action decided by Response Rate Limiting for a response
+type Action int
+
+const (
+	Send Action = iota // Send the response as is
+	Slip               // Send a truncated (TC=1) response so legitimate clients retry over TCP
+	Drop               // Send nothing
+)
+
+// ResponseLimiter implements Response Rate Limiting: identical responses
+// (same client subnet, QNAME and RCODE) are limited to a number per second,
+// and every slip-th limited one of them is sent truncated instead of
+// dropped
+type ResponseLimiter struct {
+	limiter    *Limiter
+	slip       int
+	ipv4Prefix int
+	ipv6Prefix int
+}
+
+// NewResponseLimiter creates a ResponseLimiter; it returns nil, which sends
+// everything, when RRL is disabled
+func NewResponseLimiter(cfg *config.RRLConfig) *ResponseLimiter {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &ResponseLimiter{
+		limiter:    NewLimiter(cfg.ResponsesPerSecond, cfg.Burst),
+		slip:       cfg.Slip,
+		ipv4Prefix: cfg.IPv4Prefix,
+		ipv6Prefix: cfg.IPv6Prefix,
+	}
+}
+
+// Check decides what to do with a response to client for qname with rcode
+func (l *ResponseLimiter) Check(client netip.Addr, qname string, rcode uint8) Action {
+	if l == nil {
+		return Send
+	}
+	key := subnetKey(client.Unmap(), l.ipv4Prefix, l.ipv6Prefix) +
+		"/" + strings.ToLower(qname) +
+		"/" + strconv.Itoa(int(rcode))
+	b := l.limiter.reserve(key, time.Now())
+	defer l.limiter.release()
+	if b.available() {
+		b.take()
+		return Send
+	}
+	b.limited++
+	if l.slip > 0 && b.limited%l.slip == 0 {
+		return Slip
+	}
+	return Drop
+}
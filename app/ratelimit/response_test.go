@@ -0,0 +1,36 @@
+package ratelimit
+
+import (
+	"com.sentry.dev/app/config"
+	"net/netip"
+	"testing"
+)
+
+func TestResponseLimiterSlipsPerBucket(t *testing.T) {
+	l := NewResponseLimiter(&config.RRLConfig{
+		Enabled:            true,
+		ResponsesPerSecond: 0.001,
+		Burst:              1,
+		Slip:               2,
+		IPv4Prefix:         24,
+	})
+	client := netip.MustParseAddr("192.0.2.1")
+	for _, name := range []string{"a.example", "b.example"} {
+		if action := l.Check(client, name, 0); action != Send {
+			t.Fatalf("first response for %s: %v", name, action)
+		}
+	}
+	// Limited responses for both names interleave, each name slipping
+	// every second one of its own
+	for i, want := range []Action{Drop, Slip, Drop, Slip} {
+		for _, name := range []string{"a.example", "b.example"} {
+			if action := l.Check(client, name, 0); action != want {
+				t.Errorf("limited response %d for %s: %v, want %v", i, name, action, want)
+			}
+		}
+	}
+	// Another RCODE is another response
+	if action := l.Check(client, "a.example", 3); action != Send {
+		t.Errorf("NXDOMAIN for a.example: %v", action)
+	}
+}
@@ -0,0 +1,217 @@
+package resolver
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/dnssec"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// maxTTL caps how long any answer is kept
+	maxTTL = 24 * time.Hour
+	// lameTTL is how long a server that failed to answer for a zone is
+	// left aside
+	lameTTL = 10 * time.Minute
+	// bogusTTL is how long a zone whose keys failed to validate is
+	// answered SERVFAIL for (RFC 4035 4.7)
+	bogusTTL = time.Minute
+)
+
+// nxDomain is the type under which names found not to exist are cached
+const nxDomain _type.RecordType = 0
+
+type cacheKey struct {
+	name  string
+	qtype _type.RecordType
+}
+
+// cacheEntry is an RRset, or a negative answer with the SOA it came with.
+// Checked entries went through DNSSEC validation, and secure ones passed it.
+type cacheEntry struct {
+	records []*dns.Record
+	rcode   _type.ResponseCode
+	soa     *dns.Record
+	secure  bool
+	checked bool
+	expires time.Time
+}
+
+// keyEntry is the outcome of building the chain of trust of a zone, with
+// its validated keys when secure
+type keyEntry struct {
+	keys     []*dns.DNSKEY
+	security dnssec.Security
+	expires  time.Time
+}
+
+// cache keeps the RRsets and negative answers learned while resolving for
+// their TTL
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[cacheKey]*cacheEntry
+	lame    map[string]time.Time
+	keys    map[string]*keyEntry
+}
+
+func newCache(size int) *cache {
+	return &cache{
+		size:    size,
+		entries: make(map[cacheKey]*cacheEntry),
+		lame:    make(map[string]time.Time),
+		keys:    make(map[string]*keyEntry),
+	}
+}
+
+// get returns a copy of an entry whose TTLs count down to its expiry, nil
+// when there is none or it expired
+func (c *cache) get(name string, qtype _type.RecordType) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey{name: name, qtype: qtype}
+	e := c.entries[key]
+	if e == nil {
+		return nil
+	}
+	left := time.Until(e.expires)
+	if left <= 0 {
+		delete(c.entries, key)
+		return nil
+	}
+	ttl := uint32(left / time.Second)
+	entry := &cacheEntry{rcode: e.rcode, secure: e.secure, checked: e.checked, expires: e.expires}
+	for _, r := range e.records {
+		entry.records = append(entry.records, withTTL(r, ttl))
+	}
+	if e.soa != nil {
+		entry.soa = withTTL(e.soa, ttl)
+	}
+	return entry
+}
+
+// put stores records by owner name and type, each set expiring with its
+// lowest TTL
+func (c *cache) put(records []*dns.Record, secure, checked bool) {
+	sets := make(map[cacheKey][]*dns.Record)
+	var keys []cacheKey
+	for _, r := range records {
+		key := cacheKey{name: dns.CanonicalName(r.Name), qtype: r.Type}
+		if sets[key] == nil {
+			keys = append(keys, key)
+		}
+		sets[key] = append(sets[key], r)
+	}
+	for _, key := range keys {
+		ttl := sets[key][0].TTL
+		for _, r := range sets[key] {
+			ttl = min(ttl, r.TTL)
+		}
+		c.store(key, &cacheEntry{records: sets[key], secure: secure, checked: checked}, ttl)
+	}
+}
+
+// putNegative stores that name has no record of qtype, or does not exist
+// at all for nxDomain, for as long as the SOA allows (RFC 2308 5)
+func (c *cache) putNegative(name string, qtype _type.RecordType, rcode _type.ResponseCode, soa *dns.Record, secure, checked bool) {
+	if soa == nil {
+		return
+	}
+	entry := &cacheEntry{rcode: rcode, soa: soa, secure: secure, checked: checked}
+	c.store(cacheKey{name: name, qtype: qtype}, entry, negativeTTL(soa))
+}
+
+// negativeTTL is how long a negative answer holds, the lower of the TTL
+// and the minimum of its SOA
+func negativeTTL(soa *dns.Record) uint32 {
+	ttl := soa.TTL
+	if data, ok := soa.Data.(*dns.SOA); ok {
+		ttl = min(ttl, data.Minimum)
+	}
+	return ttl
+}
+
+func (c *cache) store(key cacheKey, e *cacheEntry, ttl uint32) {
+	if ttl == 0 {
+		return
+	}
+	e.expires = time.Now().Add(min(time.Duration(ttl)*time.Second, maxTTL))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.size {
+		c.evict()
+	}
+	c.entries[key] = e
+}
+
+// evict drops the expired entries, then arbitrary ones until there is
+// room again
+func (c *cache) evict() {
+	now := time.Now()
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.entries {
+		if len(c.entries) < c.size {
+			break
+		}
+		delete(c.entries, key)
+	}
+}
+
+// isLame reports whether addr recently failed to answer for zone
+func (c *cache) isLame(zone string, addr netip.Addr) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := zone + "|" + addr.String()
+	until, ok := c.lame[key]
+	if ok && time.Now().After(until) {
+		delete(c.lame, key)
+		return false
+	}
+	return ok
+}
+
+func (c *cache) setLame(zone string, addr netip.Addr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.lame) >= c.size {
+		clear(c.lame)
+	}
+	c.lame[zone+"|"+addr.String()] = time.Now().Add(lameTTL)
+}
+
+// getKeys returns the chain of trust built for zone, nil when there is
+// none or it expired
+func (c *cache) getKeys(zone string) *keyEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.keys[zone]
+	if e != nil && time.Now().After(e.expires) {
+		delete(c.keys, zone)
+		return nil
+	}
+	return e
+}
+
+func (c *cache) putKeys(zone string, keys []*dns.DNSKEY, security dnssec.Security, ttl time.Duration) {
+	if security == dnssec.Bogus {
+		ttl = bogusTTL
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.keys) >= c.size {
+		clear(c.keys)
+	}
+	c.keys[zone] = &keyEntry{keys: keys, security: security, expires: time.Now().Add(min(ttl, maxTTL))}
+}
+
+func withTTL(r *dns.Record, ttl uint32) *dns.Record {
+	cp := *r
+	cp.TTL = ttl
+	return &cp
+}
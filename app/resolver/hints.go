@@ -0,0 +1,76 @@
+package resolver
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/zone"
+	"errors"
+	"net/netip"
+)
+
+// rootServers are the root name servers and their addresses as published
+// by IANA (named.root), used when no root hints file is configured
+var rootServers = []struct {
+	host  string
+	addrs []string
+}{
+	{"a.root-servers.net", []string{"198.41.0.4", "2001:503:ba3e::2:30"}},
+	{"b.root-servers.net", []string{"170.247.170.2", "2801:1b8:10::b"}},
+	{"c.root-servers.net", []string{"192.33.4.12", "2001:500:2::c"}},
+	{"d.root-servers.net", []string{"199.7.91.13", "2001:500:2d::d"}},
+	{"e.root-servers.net", []string{"192.203.230.10", "2001:500:a8::e"}},
+	{"f.root-servers.net", []string{"192.5.5.241", "2001:500:2f::f"}},
+	{"g.root-servers.net", []string{"192.112.36.4", "2001:500:12::d0d"}},
+	{"h.root-servers.net", []string{"198.97.190.53", "2001:500:1::53"}},
+	{"i.root-servers.net", []string{"192.36.148.17", "2001:7fe::53"}},
+	{"j.root-servers.net", []string{"192.58.128.30", "2001:503:c27::2:30"}},
+	{"k.root-servers.net", []string{"193.0.14.129", "2001:7fd::1"}},
+	{"l.root-servers.net", []string{"199.7.83.42", "2001:500:9f::42"}},
+	{"m.root-servers.net", []string{"202.12.27.33", "2001:dc3::35"}},
+}
+
+// loadHints returns the root servers listed in a root hints file, in the
+// master file format of named.root, or the built-in ones without a file
+func loadHints(path string) ([]*nameserver, error) {
+	if path == "" {
+		var hints []*nameserver
+		for _, root := range rootServers {
+			ns := &nameserver{host: root.host}
+			for _, addr := range root.addrs {
+				ns.addrs = append(ns.addrs, netip.MustParseAddr(addr))
+			}
+			hints = append(hints, ns)
+		}
+		return hints, nil
+	}
+
+	records, err := zone.ParseRecords(path, "")
+	if err != nil {
+		return nil, err
+	}
+	addrs := make(map[string][]netip.Addr)
+	for _, r := range records {
+		switch data := r.Data.(type) {
+		case *dns.A:
+			addr, _ := netip.AddrFromSlice(data.IP.To4())
+			addrs[dns.CanonicalName(r.Name)] = append(addrs[dns.CanonicalName(r.Name)], addr)
+		case *dns.AAAA:
+			addr, _ := netip.AddrFromSlice(data.IP.To16())
+			addrs[dns.CanonicalName(r.Name)] = append(addrs[dns.CanonicalName(r.Name)], addr)
+		}
+	}
+	var hints []*nameserver
+	for _, r := range records {
+		if r.Type != _type.TypeNS || dns.CanonicalName(r.Name) != "" {
+			continue
+		}
+		host := dns.CanonicalName(r.Data.(*dns.NS).Host)
+		if len(addrs[host]) > 0 {
+			hints = append(hints, &nameserver{host: host, addrs: addrs[host]})
+		}
+	}
+	if len(hints) == 0 {
+		return nil, errors.New(path + ": no root server with an address")
+	}
+	return hints, nil
+}
@@ -0,0 +1,516 @@
+package resolver
+
+import (
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/dnssec"
+	"com.sentry.dev/app/tap"
+	"com.sentry.dev/app/upstream"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// maxDepth bounds the aliases followed and the nested lookups of name
+	// server addresses within one resolution
+	maxDepth = 8
+	// maxQueries bounds the queries sent for one resolution
+	maxQueries = 64
+	// queryTimeout bounds the wait for one server
+	queryTimeout = 2 * time.Second
+	// queryLimit is the size of the queries sent
+	queryLimit = 512
+)
+
+// Result is the answer to a question, along with the SOA of negative
+// answers. With DNSSEC, a secure result was validated all along, while a
+// bogus one failed to and should only reach clients that disabled checking.
+type Result struct {
+	Answer    []*dns.Record
+	Authority []*dns.Record
+	RCode     _type.ResponseCode
+	Secure    bool
+	Bogus     bool
+}
+
+// nameserver is a server of a zone, its addresses unknown until looked up
+// when the delegation came without glue
+type nameserver struct {
+	host  string
+	addrs []netip.Addr
+}
+
+// Resolver resolves names iteratively, from the root servers down the
+// referrals to the servers of the zone holding the answer
+type Resolver struct {
+	hints    []*nameserver
+	port     uint16
+	minimise bool
+	cache    *cache
+	// referrals keeps the NS records and glue of delegations, for finding
+	// servers only: they come unsigned from the parent zone and are never
+	// answered
+	referrals *cache
+	tap       *tap.Tapper
+	// anchors are the DS records trusted by zone, nil without DNSSEC
+	anchors map[string][]*dns.DS
+}
+
+// state is the budget of one resolution, shared by the lookups it nests,
+// and the zones whose chain of trust is being built
+type state struct {
+	ctx      context.Context
+	queries  int
+	building map[string]bool
+}
+
+// New builds a resolver from its configuration
+func New(cfg *config.ResolverConfig, tapper *tap.Tapper) (*Resolver, error) {
+	hints, err := loadHints(cfg.RootHints)
+	if err != nil {
+		return nil, err
+	}
+	port := uint16(cfg.Port)
+	if port == 0 {
+		port = 53
+	}
+	r := &Resolver{
+		hints:     hints,
+		port:      port,
+		minimise:  cfg.QNAMEMinimisation,
+		cache:     newCache(max(cfg.CacheSize, 1)),
+		referrals: newCache(max(cfg.CacheSize, 1)),
+		tap:       tapper,
+	}
+	if cfg.DNSSEC {
+		if r.anchors, err = loadAnchors(cfg.TrustAnchors); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Resolve answers a question of class IN, following CNAME and DNAME
+// records to their target
+func (r *Resolver) Resolve(ctx context.Context, name string, qtype _type.RecordType) (*Result, error) {
+	return r.resolve(&state{ctx: ctx}, dns.CanonicalName(name), qtype, 0)
+}
+
+func (r *Resolver) resolve(s *state, name string, qtype _type.RecordType, depth int) (*Result, error) {
+	res := &Result{Secure: r.anchors != nil}
+	for link := 0; ; link++ {
+		if link > maxDepth {
+			return nil, errors.New("alias chain of " + dns.Fqdn(name) + " too long")
+		}
+		if e := r.answer(name, nxDomain); e != nil {
+			res.RCode = _type.RCodeNXDomain
+			res.Authority = []*dns.Record{e.soa}
+			res.Secure = res.Secure && e.secure
+			return res, nil
+		}
+		if e := r.answer(name, qtype); e != nil {
+			if e.soa != nil {
+				res.Authority = []*dns.Record{e.soa}
+			}
+			res.Answer = append(res.Answer, e.records...)
+			res.Secure = res.Secure && e.secure
+			return res, nil
+		}
+		if qtype != _type.TypeCNAME {
+			if e := r.answer(name, _type.TypeCNAME); e != nil && len(e.records) > 0 {
+				res.Answer = append(res.Answer, e.records[0])
+				res.Secure = res.Secure && e.secure
+				name = dns.CanonicalName(e.records[0].Data.(*dns.CNAME).Target)
+				continue
+			}
+		}
+
+		msg, zone, err := r.iterate(s, name, qtype, depth)
+		if err != nil {
+			return nil, err
+		}
+		records, final, found := follow(msg.Answers, name, qtype, zone)
+		soa := negativeSOA(msg, zone)
+		// The alias leads out of the zone of the server, start over from
+		// its target
+		restart := !found && final != name && (soa == nil || !dns.IsSubDomain(final, zone))
+		security := r.validate(s, msg, zone, qtype, records, final, !found && !restart, depth)
+		res.Secure = res.Secure && security == dnssec.Secure
+		res.Bogus = res.Bogus || security == dnssec.Bogus
+		secure := security == dnssec.Secure
+		if security != dnssec.Bogus {
+			r.cache.put(records, secure, r.anchors != nil)
+		}
+		res.Answer = append(res.Answer, records...)
+		if found {
+			return res, nil
+		}
+		if restart {
+			name = final
+			continue
+		}
+		res.RCode = _type.ResponseCode(msg.Header.ResponseCode)
+		if soa != nil {
+			res.Authority = []*dns.Record{soa}
+		}
+		switch {
+		case security == dnssec.Bogus:
+		case res.RCode == _type.RCodeNXDomain:
+			r.cache.putNegative(final, nxDomain, res.RCode, soa, secure, r.anchors != nil)
+		default:
+			r.cache.putNegative(final, qtype, res.RCode, soa, secure, r.anchors != nil)
+		}
+		return res, nil
+	}
+}
+
+// answer returns the cached entry answering name and qtype, nil when there
+// is none. With DNSSEC, entries that were never validated are no answer.
+func (r *Resolver) answer(name string, qtype _type.RecordType) *cacheEntry {
+	e := r.cache.get(name, qtype)
+	if e == nil || (r.anchors != nil && !e.checked) {
+		return nil
+	}
+	return e
+}
+
+// iterate follows the referrals leading to the servers of the zone of name,
+// starting from the closest delegation known, and returns their answer.
+// With QNAME minimisation (RFC 9156), the servers of each zone are only
+// asked for one label more than their zone until the last one. DS records
+// are asked to the servers of the parent zone, which hold them.
+func (r *Resolver) iterate(s *state, name string, qtype _type.RecordType, depth int) (*dns.Message, string, error) {
+	above := name
+	if qtype == _type.TypeDS && name != "" {
+		above = dns.Parent(name)
+	}
+	zone, servers := r.closest(above)
+	known := zone
+	minimise := r.minimise
+	for {
+		qname, qt := name, qtype
+		if minimise && known != name {
+			qname = child(known, name)
+		}
+		if qname != name {
+			qt = _type.TypeA
+		}
+		msg, err := r.ask(s, zone, servers, qname, qt, depth)
+		if err != nil {
+			return nil, "", err
+		}
+		if cut, ns := referral(msg, zone, qname); cut != "" && (qtype != _type.TypeDS || cut != name) {
+			servers = r.delegate(cut, zone, ns, msg.Additional)
+			zone, known = cut, cut
+			continue
+		}
+		if qname == name {
+			return msg, zone, nil
+		}
+		// Servers answering NXDOMAIN for empty non-terminals, or aliases
+		// above the name, are asked the full question instead
+		if _type.ResponseCode(msg.Header.ResponseCode) == _type.RCodeNXDomain || hasAlias(msg.Answers) {
+			minimise = false
+			continue
+		}
+		known = qname
+	}
+}
+
+// closest returns the deepest zone above name whose servers are cached,
+// the root and its hints at worst
+func (r *Resolver) closest(name string) (string, []*nameserver) {
+	for n := name; ; n = dns.Parent(n) {
+		if e := r.cachedNS(n); e != nil {
+			var servers []*nameserver
+			for _, rr := range e.records {
+				host := dns.CanonicalName(rr.Data.(*dns.NS).Host)
+				servers = append(servers, &nameserver{host: host, addrs: r.cachedAddrs(host)})
+			}
+			return n, servers
+		}
+		if n == "" {
+			return "", r.hints
+		}
+	}
+}
+
+// cachedNS returns the NS records of zone, answered by the zone itself or
+// else given by a referral, nil when there is none
+func (r *Resolver) cachedNS(zone string) *cacheEntry {
+	for _, c := range []*cache{r.cache, r.referrals} {
+		if e := c.get(zone, _type.TypeNS); e != nil && len(e.records) > 0 {
+			return e
+		}
+	}
+	return nil
+}
+
+// delegate keeps the NS records of a referral to cut along with their
+// glue, accepted for names inside the zone of the referring server only
+func (r *Resolver) delegate(cut, parent string, ns []*dns.Record, additional []*dns.Record) []*nameserver {
+	r.referrals.put(ns, false, false)
+	var servers []*nameserver
+	for _, rr := range ns {
+		host := dns.CanonicalName(rr.Data.(*dns.NS).Host)
+		server := &nameserver{host: host}
+		if dns.IsSubDomain(host, parent) {
+			var glue []*dns.Record
+			for _, a := range additional {
+				if dns.CanonicalName(a.Name) == host && (a.Type == _type.TypeA || a.Type == _type.TypeAAAA) {
+					glue = append(glue, a)
+				}
+			}
+			r.referrals.put(glue, false, false)
+			server.addrs = addrs(glue)
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// ask sends the question to the servers of zone in random order until one
+// gives an answer or a referral. Servers failing are left aside for a
+// while, and only tried again when no other one answers.
+func (r *Resolver) ask(s *state, zone string, servers []*nameserver, qname string, qtype _type.RecordType, depth int) (*dns.Message, error) {
+	query := &dns.Message{
+		Header: &dns.Header{ID: uint16(rand.Uint32())},
+		Questions: []*dns.Question{{
+			Name:  &dns.Addr{String: qname},
+			Type:  qtype,
+			Class: _type.ClassIN,
+		}},
+	}
+	if r.anchors != nil {
+		query.Additional = []*dns.Record{dns.NewOPT(dns.EDNSPayload, true)}
+	}
+	packed, err := query.Pack(queryLimit)
+	if err != nil {
+		return nil, err
+	}
+	servers = slices.Clone(servers)
+	rand.Shuffle(len(servers), func(i, j int) { servers[i], servers[j] = servers[j], servers[i] })
+
+	for _, lame := range []bool{false, true} {
+		for _, ns := range servers {
+			if ns.addrs == nil {
+				ns.addrs = r.lookUpAddrs(s, ns.host, zone, depth)
+			}
+			for _, addr := range ns.addrs {
+				if r.cache.isLame(zone, addr) != lame {
+					continue
+				}
+				if s.queries >= maxQueries {
+					return nil, errors.New("too many queries resolving " + dns.Fqdn(qname))
+				}
+				if err := s.ctx.Err(); err != nil {
+					return nil, err
+				}
+				s.queries++
+				msg, err := r.query(s.ctx, addr, packed, query.Questions[0])
+				if err == nil && usable(msg, zone, qname) {
+					return msg, nil
+				}
+				r.cache.setLame(zone, addr)
+			}
+		}
+	}
+	return nil, fmt.Errorf("no server of %s answered for %s", dns.Fqdn(zone), dns.Fqdn(qname))
+}
+
+// query sends a packed query to one server and checks that the response
+// answers its question
+func (r *Resolver) query(ctx context.Context, addr netip.Addr, packed []byte, question *dns.Question) (*dns.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	server := netip.AddrPortFrom(addr, r.port)
+	start := time.Now()
+	r.tap.ResolverQuery(server, upstream.TransportUDP, packed, start)
+	resp, err := upstream.NewPlain(server.String()).Exchange(ctx, packed)
+	if err != nil {
+		return nil, err
+	}
+	r.tap.ResolverResponse(server, upstream.TransportUDP, resp, start, time.Now())
+	msg, err := dns.UnpackMessage(resp)
+	if err != nil {
+		return nil, err
+	}
+	if !msg.Header.QueryResponse || len(msg.Questions) != 1 ||
+		dns.CanonicalName(msg.Questions[0].Name.String) != question.Name.String ||
+		msg.Questions[0].Type != question.Type {
+		return nil, errors.New("response does not match the query")
+	}
+	return msg, nil
+}
+
+// lookUpAddrs resolves the addresses of a name server the referral gave
+// no glue for. Servers named inside their own zone cannot be looked up.
+func (r *Resolver) lookUpAddrs(s *state, host, zone string, depth int) []netip.Addr {
+	if depth >= maxDepth || dns.IsSubDomain(host, zone) {
+		return []netip.Addr{}
+	}
+	if cached := r.cachedAddrs(host); cached != nil {
+		return cached
+	}
+	res, err := r.resolve(s, host, _type.TypeA, depth+1)
+	if err != nil || res.Bogus {
+		return []netip.Addr{}
+	}
+	return addrs(res.Answer)
+}
+
+// cachedAddrs returns the cached addresses of host, resolved or from glue,
+// nil when there is none
+func (r *Resolver) cachedAddrs(host string) []netip.Addr {
+	for _, c := range []*cache{r.cache, r.referrals} {
+		var records []*dns.Record
+		for _, qtype := range []_type.RecordType{_type.TypeA, _type.TypeAAAA} {
+			if e := c.get(host, qtype); e != nil {
+				records = append(records, e.records...)
+			}
+		}
+		if len(records) > 0 {
+			return addrs(records)
+		}
+	}
+	return nil
+}
+
+// follow walks the answer from name through its aliases, keeping the
+// records of the chain within zone. It returns the last name reached and
+// whether records of qtype were found there.
+func follow(answer []*dns.Record, name string, qtype _type.RecordType, zone string) ([]*dns.Record, string, bool) {
+	var records []*dns.Record
+	for link := 0; link <= maxDepth && dns.IsSubDomain(name, zone); link++ {
+		var matched []*dns.Record
+		var cname, dname *dns.Record
+		for _, rr := range answer {
+			owner := dns.CanonicalName(rr.Name)
+			switch {
+			case owner == name && (rr.Type == qtype || qtype == _type.TypeANY):
+				matched = append(matched, rr)
+			case owner == name && rr.Type == _type.TypeCNAME:
+				cname = rr
+			case rr.Type == _type.TypeDNAME && owner != name && dns.IsSubDomain(name, owner):
+				dname = rr
+			}
+		}
+		switch {
+		case len(matched) > 0:
+			return append(records, matched...), name, true
+		case dname != nil:
+			// Synthesize the CNAME of the name below the DNAME (RFC 6672)
+			owner := dns.CanonicalName(dname.Name)
+			target := strings.TrimSuffix(name, owner) + dns.CanonicalName(dname.Data.(*dns.DNAME).Target)
+			target = strings.TrimSuffix(target, ".")
+			records = append(records, dname, &dns.Record{
+				Name:  name,
+				Type:  _type.TypeCNAME,
+				Class: _type.ClassIN,
+				TTL:   dname.TTL,
+				Data:  &dns.CNAME{Target: target},
+			})
+			name = target
+		case cname != nil:
+			records = append(records, cname)
+			name = dns.CanonicalName(cname.Data.(*dns.CNAME).Target)
+		default:
+			return records, name, false
+		}
+	}
+	return records, name, false
+}
+
+// referral returns the zone a response delegates to and its NS records,
+// when it is a referral to a zone below zone covering qname
+func referral(msg *dns.Message, zone, qname string) (string, []*dns.Record) {
+	if msg.Header.AuthoritativeAnswer || len(msg.Answers) > 0 ||
+		_type.ResponseCode(msg.Header.ResponseCode) != _type.RCodeNoError {
+		return "", nil
+	}
+	var cut string
+	var ns []*dns.Record
+	for _, rr := range msg.Authority {
+		if rr.Type != _type.TypeNS {
+			continue
+		}
+		owner := dns.CanonicalName(rr.Name)
+		if cut == "" {
+			cut = owner
+		}
+		if owner == cut {
+			ns = append(ns, rr)
+		}
+	}
+	if cut == "" || cut == zone || !dns.IsSubDomain(cut, zone) || !dns.IsSubDomain(qname, cut) {
+		return "", nil
+	}
+	return cut, ns
+}
+
+// usable reports whether a response is an answer from a server
+// authoritative for zone or a referral below it. Anything else comes from
+// a lame server.
+func usable(msg *dns.Message, zone, qname string) bool {
+	switch _type.ResponseCode(msg.Header.ResponseCode) {
+	case _type.RCodeNoError, _type.RCodeNXDomain:
+	default:
+		return false
+	}
+	if msg.Header.AuthoritativeAnswer {
+		return true
+	}
+	cut, _ := referral(msg, zone, qname)
+	return cut != ""
+}
+
+// negativeSOA returns the SOA of zone carried by a negative answer
+func negativeSOA(msg *dns.Message, zone string) *dns.Record {
+	for _, rr := range msg.Authority {
+		if rr.Type == _type.TypeSOA && dns.IsSubDomain(rr.Name, zone) {
+			return rr
+		}
+	}
+	return nil
+}
+
+func hasAlias(answer []*dns.Record) bool {
+	for _, rr := range answer {
+		if rr.Type == _type.TypeCNAME || rr.Type == _type.TypeDNAME {
+			return true
+		}
+	}
+	return false
+}
+
+// child returns the name one label below parent on the way to name
+func child(parent, name string) string {
+	labels := dns.SplitName(name)
+	n := len(dns.SplitName(parent)) + 1
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+// addrs returns the addresses of A and AAAA records, IPv4 first
+func addrs(records []*dns.Record) []netip.Addr {
+	var v4, v6 []netip.Addr
+	for _, rr := range records {
+		switch data := rr.Data.(type) {
+		case *dns.A:
+			if addr, ok := netip.AddrFromSlice(data.IP.To4()); ok {
+				v4 = append(v4, addr)
+			}
+		case *dns.AAAA:
+			if addr, ok := netip.AddrFromSlice(data.IP.To16()); ok {
+				v6 = append(v6, addr)
+			}
+		}
+	}
+	return append(v4, v6...)
+}
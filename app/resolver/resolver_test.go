@@ -0,0 +1,287 @@
+package resolver
+
+import (
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/zone"
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// The stand-in servers of the tests, all on one port of the loopback
+// network: the root delegates test. to a server and a lame one, and
+// other. to a third server.
+const (
+	rootAddr  = "127.0.2.1"
+	testAddr  = "127.0.2.2"
+	otherAddr = "127.0.2.3"
+	lameAddr  = "127.0.2.9"
+)
+
+const rootZone = `
+.                  3600 IN SOA a.root. admin.root. 1 3600 600 86400 300
+.                  3600 IN NS  a.root.
+a.root.            3600 IN A   127.0.2.1
+test.              3600 IN NS  ns1.test.
+test.              3600 IN NS  ns2.test.
+ns1.test.          3600 IN A   127.0.2.2
+ns2.test.          3600 IN A   127.0.2.9
+other.             3600 IN NS  ns.other.
+ns.other.          3600 IN A   127.0.2.3
+`
+
+const testZone = `
+test.              3600 IN SOA ns1.test. admin.test. 1 3600 600 86400 300
+test.              3600 IN NS  ns1.test.
+test.              3600 IN NS  ns2.test.
+ns1.test.          3600 IN A   127.0.2.2
+ns2.test.          3600 IN A   127.0.2.9
+www.test.          3600 IN A   192.0.2.1
+alias.test.        3600 IN CNAME www.other.
+`
+
+const otherZone = `
+other.             3600 IN SOA ns.other. admin.other. 1 3600 600 86400 300
+other.             3600 IN NS  ns.other.
+ns.other.          3600 IN A   127.0.2.3
+www.other.         3600 IN A   192.0.2.2
+`
+
+const rootHints = `
+.                  3600000 NS a.root.
+a.root.            3600000 A  127.0.2.1
+`
+
+// standIn is an authoritative server answering from a zone, or refusing
+// every query when it has none, as a lame server does
+type standIn struct {
+	zone    *zone.Zone
+	queries atomic.Int32
+}
+
+// serve answers the queries read from conn until it is closed
+func (s *standIn) serve(conn net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query, err := dns.UnpackMessage(buf[:n])
+		if err != nil || len(query.Questions) != 1 {
+			continue
+		}
+		s.queries.Add(1)
+		resp := &dns.Message{
+			Header: &dns.Header{
+				ID:            query.Header.ID,
+				QueryResponse: true,
+				ResponseCode:  uint8(_type.RCodeRefused),
+			},
+			Questions: query.Questions,
+		}
+		if s.zone != nil {
+			q := query.Questions[0]
+			res := s.zone.Lookup(q.Name.String, q.Type, false)
+			resp.Header.AuthoritativeAnswer = res.Authoritative
+			resp.Header.ResponseCode = uint8(res.RCode)
+			resp.Answers, resp.Authority, resp.Additional = res.Answer, res.Authority, res.Additional
+		}
+		packed, err := resp.Pack(512)
+		if err != nil {
+			continue
+		}
+		conn.WriteTo(packed, addr)
+	}
+}
+
+type standIns struct {
+	root, test, other, lame *standIn
+}
+
+// startStandIns starts the stand-in servers and returns a resolver whose
+// root hints point at them
+func startStandIns(t *testing.T, minimise bool) (*Resolver, *standIns) {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	parse := func(name, origin, data string) *zone.Zone {
+		z, err := zone.ParseFile(write(name, data), origin)
+		if err != nil {
+			t.Fatalf("zone %s: %v", origin, err)
+		}
+		return z
+	}
+	servers := &standIns{
+		root:  &standIn{zone: parse("root.zone", ".", rootZone)},
+		test:  &standIn{zone: parse("test.zone", "test.", testZone)},
+		other: &standIn{zone: parse("other.zone", "other.", otherZone)},
+		lame:  &standIn{},
+	}
+
+	var port int
+	for _, s := range []struct {
+		addr string
+		*standIn
+	}{
+		{rootAddr, servers.root},
+		{testAddr, servers.test},
+		{otherAddr, servers.other},
+		{lameAddr, servers.lame},
+	} {
+		conn, err := net.ListenPacket("udp", net.JoinHostPort(s.addr, strconv.Itoa(port)))
+		if err != nil {
+			t.Skip("cannot listen on the loopback network:", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		port = conn.LocalAddr().(*net.UDPAddr).Port
+		go s.serve(conn)
+	}
+
+	r, err := New(&config.ResolverConfig{
+		RootHints:         write("root.hints", rootHints),
+		Port:              port,
+		QNAMEMinimisation: minimise,
+		CacheSize:         100,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, servers
+}
+
+func resolve(t *testing.T, r *Resolver, name string, qtype _type.RecordType) *Result {
+	t.Helper()
+	res, err := r.Resolve(context.Background(), name, qtype)
+	if err != nil {
+		t.Fatalf("resolving %s: %v", name, err)
+	}
+	return res
+}
+
+// answerString returns the records of an answer as owner, type and data
+func answerString(answer []*dns.Record) string {
+	var s []string
+	for _, rr := range answer {
+		var data string
+		switch d := rr.Data.(type) {
+		case *dns.A:
+			data = d.IP.String()
+		case *dns.CNAME:
+			data = dns.CanonicalName(d.Target)
+		}
+		s = append(s, dns.CanonicalName(rr.Name)+" "+rr.Type.String()+" "+data)
+	}
+	return strings.Join(s, ", ")
+}
+
+func TestResolveFollowsReferrals(t *testing.T) {
+	for _, minimise := range []bool{false, true} {
+		r, servers := startStandIns(t, minimise)
+		res := resolve(t, r, "www.test", _type.TypeA)
+		if got, want := answerString(res.Answer), "www.test A 192.0.2.1"; got != want {
+			t.Errorf("minimise %v: answer %q, want %q", minimise, got, want)
+		}
+		if servers.root.queries.Load() == 0 {
+			t.Errorf("minimise %v: root server never asked", minimise)
+		}
+		// The address of the server of test. came as glue of the referral
+		if servers.test.queries.Load() == 0 {
+			t.Errorf("minimise %v: server of test. never asked", minimise)
+		}
+	}
+}
+
+func TestResolveRestartsOnCNAME(t *testing.T) {
+	r, servers := startStandIns(t, false)
+	res := resolve(t, r, "alias.test", _type.TypeA)
+	want := "alias.test CNAME www.other, www.other A 192.0.2.2"
+	if got := answerString(res.Answer); got != want {
+		t.Errorf("answer %q, want %q", got, want)
+	}
+	if servers.other.queries.Load() == 0 {
+		t.Error("server of other. never asked for the target of the alias")
+	}
+}
+
+func TestResolveSkipsLameServers(t *testing.T) {
+	r, servers := startStandIns(t, false)
+	// The servers of test. are tried in random order, ask new names until
+	// the lame one was tried
+	for i := 0; i < 64 && servers.lame.queries.Load() == 0; i++ {
+		res := resolve(t, r, "missing"+strconv.Itoa(i)+".test", _type.TypeA)
+		if res.RCode != _type.RCodeNXDomain {
+			t.Fatalf("rcode %v, want NXDOMAIN", res.RCode)
+		}
+	}
+	if !r.cache.isLame("test", netip.MustParseAddr(lameAddr)) {
+		t.Fatal("lame server not marked lame")
+	}
+	for i := 0; i < 8; i++ {
+		resolve(t, r, "other"+strconv.Itoa(i)+".test", _type.TypeA)
+	}
+	if n := servers.lame.queries.Load(); n != 1 {
+		t.Errorf("lame server asked %d times, want once", n)
+	}
+}
+
+func TestResolveCachesNegativeAnswers(t *testing.T) {
+	r, servers := startStandIns(t, false)
+	for _, q := range []struct {
+		name  string
+		qtype _type.RecordType
+		rcode _type.ResponseCode
+	}{
+		{"missing.test", _type.TypeA, _type.RCodeNXDomain},
+		{"www.test", _type.TypeMX, _type.RCodeNoError},
+	} {
+		for i := 0; i < 2; i++ {
+			asked := servers.test.queries.Load() + servers.lame.queries.Load()
+			res := resolve(t, r, q.name, q.qtype)
+			if res.RCode != q.rcode || len(res.Answer) > 0 || len(res.Authority) != 1 || res.Authority[0].Type != _type.TypeSOA {
+				t.Fatalf("%s %s: rcode %v, answer %q, authority %d records", q.name, q.qtype, res.RCode, answerString(res.Answer), len(res.Authority))
+			}
+			if i > 0 && servers.test.queries.Load()+servers.lame.queries.Load() != asked {
+				t.Errorf("%s %s: asked again while cached", q.name, q.qtype)
+			}
+		}
+	}
+}
+
+func TestResolveDoesNotAnswerGlue(t *testing.T) {
+	r, servers := startStandIns(t, false)
+	resolve(t, r, "www.test", _type.TypeA)
+	asked := servers.test.queries.Load()
+	res := resolve(t, r, "ns1.test", _type.TypeA)
+	if got, want := answerString(res.Answer), "ns1.test A 127.0.2.2"; got != want {
+		t.Errorf("answer %q, want %q", got, want)
+	}
+	if servers.test.queries.Load() == asked {
+		t.Error("glue of the referral answered from the cache")
+	}
+}
+
+func TestResolveFailsWithoutServers(t *testing.T) {
+	r, _ := startStandIns(t, false)
+	r.hints = []*nameserver{{host: "a.root", addrs: []netip.Addr{netip.MustParseAddr(lameAddr)}}}
+	if _, err := r.Resolve(context.Background(), "www.test", _type.TypeA); err == nil {
+		t.Error("answered through lame root servers only")
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		t.Error("timed out instead of failing on the refusals")
+	}
+}
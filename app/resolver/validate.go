@@ -0,0 +1,303 @@
+package resolver
+
+import (
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/dnssec"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// loadAnchors indexes the trust anchors by zone
+func loadAnchors(configs []config.TrustAnchorConfig) (map[string][]*dns.DS, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("resolver: dnssec needs at least one trust anchor")
+	}
+	anchors := make(map[string][]*dns.DS)
+	for _, c := range configs {
+		digest, err := hex.DecodeString(c.Digest)
+		if err != nil || len(digest) == 0 {
+			return nil, fmt.Errorf("resolver: invalid digest of the trust anchor of %s", c.Domain)
+		}
+		if !dnssec.SupportsAlgorithm(c.Algorithm) || !dnssec.SupportsDigest(c.DigestType) {
+			return nil, fmt.Errorf("resolver: unsupported algorithm or digest type for the trust anchor of %s", c.Domain)
+		}
+		zone := dns.CanonicalName(c.Domain)
+		anchors[zone] = append(anchors[zone], &dns.DS{
+			KeyTag:     c.KeyTag,
+			Algorithm:  c.Algorithm,
+			DigestType: c.DigestType,
+			Digest:     digest,
+		})
+	}
+	return anchors, nil
+}
+
+// validate checks the answer of the servers of zone (RFC 4035 5): the
+// signatures of the records of the alias chain followed, then the proof
+// that no closer name matched a wildcard and, for negative answers, that
+// final has no record of qtype or does not exist
+func (r *Resolver) validate(s *state, msg *dns.Message, zone string, qtype _type.RecordType, records []*dns.Record, final string, negative bool, depth int) dnssec.Security {
+	if r.anchors == nil {
+		return dnssec.Insecure
+	}
+	security := dnssec.Secure
+	type expansion struct {
+		name   string
+		labels int
+	}
+	var expansions []expansion
+	for _, rrset := range dnssec.RRsets(records) {
+		if !slices.Contains(msg.Answers, rrset[0]) {
+			// A CNAME synthesized from a DNAME holds as the DNAME does
+			continue
+		}
+		status, sig := r.verify(s, rrset, dnssec.Signatures(msg.Answers, rrset[0].Name, rrset[0].Type), zone, depth)
+		security = merge(security, status)
+		if sig != nil {
+			if labels := int(sig.Data.(*dns.RRSIG).Labels); labels < dnssec.LabelCount(rrset[0].Name) {
+				expansions = append(expansions, expansion{name: rrset[0].Name, labels: labels})
+			}
+		}
+	}
+	if security != dnssec.Secure || (!negative && len(expansions) == 0) {
+		return security
+	}
+
+	proof, status := r.proof(s, msg, zone, depth)
+	if status != dnssec.Secure {
+		return status
+	}
+	for _, e := range expansions {
+		security = merge(security, proof.Wildcard(e.name, e.labels))
+	}
+	if negative {
+		if _type.ResponseCode(msg.Header.ResponseCode) == _type.RCodeNXDomain {
+			security = merge(security, proof.NameError(final))
+		} else {
+			security = merge(security, proof.NoData(final, qtype))
+		}
+	}
+	return security
+}
+
+// merge combines the security of two parts of an answer: bogus when
+// either is, secure when both are
+func merge(a, b dnssec.Security) dnssec.Security {
+	if a == dnssec.Bogus || b == dnssec.Bogus {
+		return dnssec.Bogus
+	}
+	if a == dnssec.Insecure || b == dnssec.Insecure {
+		return dnssec.Insecure
+	}
+	return dnssec.Secure
+}
+
+// verify checks the signatures of an RRset served by the servers of zone,
+// made by that zone or one below it they also serve, and returns the one
+// that holds. An unsigned RRset is only insecure in an insecure zone.
+func (r *Resolver) verify(s *state, rrset, sigs []*dns.Record, zone string, depth int) (dnssec.Security, *dns.Record) {
+	if len(sigs) == 0 {
+		if _, security := r.zoneKeys(s, zone, depth); security != dnssec.Secure {
+			return security, nil
+		}
+		return dnssec.Bogus, nil
+	}
+	now := time.Now()
+	for _, sig := range sigs {
+		signer := dns.CanonicalName(sig.Data.(*dns.RRSIG).SignerName)
+		if !dns.IsSubDomain(signer, zone) {
+			continue
+		}
+		keys, security := r.zoneKeys(s, signer, depth)
+		if security == dnssec.Insecure {
+			return dnssec.Insecure, nil
+		}
+		for _, key := range keys {
+			if dnssec.Verify(rrset, sig, key, now) == nil {
+				return dnssec.Secure, sig
+			}
+		}
+	}
+	return dnssec.Bogus, nil
+}
+
+// proof checks the signatures of the SOA, NSEC and NSEC3 records of the
+// authority section and gathers the denial records
+func (r *Resolver) proof(s *state, msg *dns.Message, zone string, depth int) (*dnssec.Proof, dnssec.Security) {
+	security := dnssec.Secure
+	signer := zone
+	var denial []*dns.Record
+	for _, rrset := range dnssec.RRsets(msg.Authority) {
+		t := rrset[0].Type
+		if t != _type.TypeSOA && t != _type.TypeNSEC && t != _type.TypeNSEC3 {
+			continue
+		}
+		status, sig := r.verify(s, rrset, dnssec.Signatures(msg.Authority, rrset[0].Name, t), zone, depth)
+		security = merge(security, status)
+		if t != _type.TypeSOA && sig != nil {
+			signer = dns.CanonicalName(sig.Data.(*dns.RRSIG).SignerName)
+			denial = append(denial, rrset...)
+		}
+	}
+	return dnssec.NewProof(signer, denial), security
+}
+
+// zoneKeys returns the validated keys of zone, building its chain of trust
+// from the closest trust anchor above when not cached: the DS records of
+// the zone, validated with the keys of its parent, authenticate the key
+// signing the DNSKEY RRset (RFC 4035 5.2)
+func (r *Resolver) zoneKeys(s *state, zone string, depth int) ([]*dns.DNSKEY, dnssec.Security) {
+	if e := r.cache.getKeys(zone); e != nil {
+		return e.keys, e.security
+	}
+	// A zone cannot vouch for itself, as a DS record or the proof of its
+	// absence signed by the zone below the cut would have it
+	if s.building[zone] {
+		return nil, dnssec.Bogus
+	}
+	if s.building == nil {
+		s.building = make(map[string]bool)
+	}
+	s.building[zone] = true
+	defer delete(s.building, zone)
+
+	ds, security, ttl := r.delegationSigners(s, zone, depth)
+	var keys []*dns.DNSKEY
+	if security == dnssec.Secure {
+		var keysTTL time.Duration
+		keys, security, keysTTL = r.fetchKeys(s, zone, ds, depth)
+		ttl = min(ttl, keysTTL)
+	}
+	r.cache.putKeys(zone, keys, security, ttl)
+	return keys, security
+}
+
+// delegationSigners returns the trust anchors of zone or its DS records,
+// insecure when the parent is or proves it has none
+func (r *Resolver) delegationSigners(s *state, zone string, depth int) ([]*dns.DS, dnssec.Security, time.Duration) {
+	if anchors, ok := r.anchors[zone]; ok {
+		return anchors, dnssec.Secure, maxTTL
+	}
+	if !r.anchored(zone) {
+		return nil, dnssec.Insecure, maxTTL
+	}
+	msg, parent, err := r.iterate(s, zone, _type.TypeDS, depth)
+	if err != nil || parent == zone {
+		return nil, dnssec.Bogus, 0
+	}
+	if _, security := r.zoneKeys(s, parent, depth); security != dnssec.Secure {
+		return nil, security, maxTTL
+	}
+	records, _, found := follow(msg.Answers, zone, _type.TypeDS, parent)
+	if !found {
+		proof, security := r.proof(s, msg, parent, depth)
+		if security == dnssec.Secure {
+			security = proof.NoData(zone, _type.TypeDS)
+		}
+		// The proof comes with the SOA of a negative answer, or alone in a
+		// referral
+		ttl := maxTTL
+		for _, rr := range msg.Authority {
+			ttl = min(ttl, time.Duration(rr.TTL)*time.Second)
+		}
+		if soa := negativeSOA(msg, parent); soa != nil {
+			ttl = min(ttl, time.Duration(negativeTTL(soa))*time.Second)
+		}
+		if security == dnssec.Secure {
+			// Proven unsigned delegation
+			return nil, dnssec.Insecure, ttl
+		}
+		return nil, security, ttl
+	}
+
+	var rrset []*dns.Record
+	for _, rr := range records {
+		if rr.Type == _type.TypeDS {
+			rrset = append(rrset, rr)
+		}
+	}
+	if security, _ := r.verify(s, rrset, dnssec.Signatures(msg.Answers, zone, _type.TypeDS), parent, depth); security != dnssec.Secure {
+		return nil, security, 0
+	}
+	var ds []*dns.DS
+	ttl := maxTTL
+	for _, rr := range rrset {
+		data := rr.Data.(*dns.DS)
+		if dnssec.SupportsAlgorithm(data.Algorithm) && dnssec.SupportsDigest(data.DigestType) {
+			ds = append(ds, data)
+		}
+		ttl = min(ttl, time.Duration(rr.TTL)*time.Second)
+	}
+	if len(ds) == 0 {
+		// Only algorithms this resolver cannot check
+		return nil, dnssec.Insecure, ttl
+	}
+	return ds, dnssec.Secure, ttl
+}
+
+// fetchKeys asks the servers of zone for its DNSKEY RRset, which must be
+// signed by a key one of the DS records refers to
+func (r *Resolver) fetchKeys(s *state, zone string, ds []*dns.DS, depth int) ([]*dns.DNSKEY, dnssec.Security, time.Duration) {
+	msg, server, err := r.iterate(s, zone, _type.TypeDNSKEY, depth)
+	if err != nil {
+		return nil, dnssec.Bogus, 0
+	}
+	records, _, found := follow(msg.Answers, zone, _type.TypeDNSKEY, server)
+	if !found {
+		return nil, dnssec.Bogus, 0
+	}
+	var rrset []*dns.Record
+	var keys, entry []*dns.DNSKEY
+	ttl := maxTTL
+	for _, rr := range records {
+		key, ok := rr.Data.(*dns.DNSKEY)
+		if !ok {
+			continue
+		}
+		rrset = append(rrset, rr)
+		keys = append(keys, key)
+		ttl = min(ttl, time.Duration(rr.TTL)*time.Second)
+		for _, d := range ds {
+			if dnssec.MatchesDS(zone, d, key) {
+				entry = append(entry, key)
+				break
+			}
+		}
+	}
+	if !r.verifiedBy(rrset, dnssec.Signatures(msg.Answers, zone, _type.TypeDNSKEY), zone, entry) {
+		return nil, dnssec.Bogus, 0
+	}
+	return keys, dnssec.Secure, ttl
+}
+
+// verifiedBy reports whether one of the signatures of signer over rrset
+// holds with one of keys
+func (r *Resolver) verifiedBy(rrset, sigs []*dns.Record, signer string, keys []*dns.DNSKEY) bool {
+	now := time.Now()
+	for _, sig := range sigs {
+		if dns.CanonicalName(sig.Data.(*dns.RRSIG).SignerName) != signer {
+			continue
+		}
+		for _, key := range keys {
+			if dnssec.Verify(rrset, sig, key, now) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// anchored reports whether a trust anchor lies at or above zone
+func (r *Resolver) anchored(zone string) bool {
+	for anchor := range r.anchors {
+		if dns.IsSubDomain(zone, anchor) {
+			return true
+		}
+	}
+	return false
+}
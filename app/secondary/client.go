@@ -0,0 +1,162 @@
+package secondary
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/tsig"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+const (
+	// queryTimeout bounds a SOA query to the primary
+	queryTimeout = 5 * time.Second
+	// transferTimeout bounds the wait for each message of a zone transfer
+	transferTimeout = 30 * time.Second
+	// udpMessageLimit is the largest response read over UDP
+	udpMessageLimit = 4096
+)
+
+// newQuery builds a query for the given name and type
+func newQuery(name string, qtype _type.RecordType) *dns.Message {
+	return &dns.Message{
+		Header: &dns.Header{ID: uint16(rand.Uint32())},
+		Questions: []*dns.Question{{
+			Name:  &dns.Addr{String: name},
+			Type:  qtype,
+			Class: _type.ClassIN,
+		}},
+	}
+}
+
+// checkResponse verifies that msg answers query successfully
+func checkResponse(query, msg *dns.Message) error {
+	if msg.Header.ID != query.Header.ID || !msg.Header.QueryResponse {
+		return errors.New("response does not match the query")
+	}
+	if rcode := _type.ResponseCode(msg.Header.ResponseCode); rcode != _type.RCodeNoError {
+		return errors.New("primary answered " + rcode.String())
+	}
+	return nil
+}
+
+// pack encodes query within limit, signed with key when not nil
+func pack(query *dns.Message, limit int, key *tsig.Key) ([]byte, *tsig.Session, error) {
+	packed, err := query.Pack(limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key.Sign(packed, time.Now())
+}
+
+// exchange sends query to server over UDP, retrying over TCP when the
+// answer is truncated
+func exchange(ctx context.Context, server string, query *dns.Message, key *tsig.Key) (*dns.Message, error) {
+	packed, session, err := pack(query, udpMessageLimit, key)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err = conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(packed); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, udpMessageLimit)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := dns.UnpackMessage(buf[:n])
+		if err != nil || msg.Header.ID != query.Header.ID {
+			continue
+		}
+		if err = session.Verify(buf[:n], time.Now()); err != nil {
+			return nil, err
+		}
+		if msg.Header.Truncation {
+			var resp *dns.Message
+			err = stream(ctx, server, query, key, func(m *dns.Message) (bool, error) {
+				resp = m
+				return true, nil
+			})
+			return resp, err
+		}
+		return msg, checkResponse(query, msg)
+	}
+}
+
+// stream sends query to server over TCP and hands every response message
+// to handle until it reports that the answer is complete
+func stream(ctx context.Context, server string, query *dns.Message, key *tsig.Key, handle func(*dns.Message) (bool, error)) error {
+	packed, session, err := pack(query, 65535, key)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	dialCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	conn, err := d.DialContext(dialCtx, "tcp", server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	buf := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(buf, uint16(len(packed)))
+	copy(buf[2:], packed)
+	if err = conn.SetDeadline(time.Now().Add(transferTimeout)); err != nil {
+		return err
+	}
+	if _, err = conn.Write(buf); err != nil {
+		return err
+	}
+
+	for {
+		if err = conn.SetReadDeadline(time.Now().Add(transferTimeout)); err != nil {
+			return err
+		}
+		var length [2]byte
+		if _, err = io.ReadFull(conn, length[:]); err != nil {
+			return err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		if err = session.Verify(buf, time.Now()); err != nil {
+			return err
+		}
+		msg, err := dns.UnpackMessage(buf)
+		if err != nil {
+			return err
+		}
+		if err = checkResponse(query, msg); err != nil {
+			return err
+		}
+		if done, err := handle(msg); done || err != nil {
+			if err == nil && !session.Complete() {
+				return errors.New("answer ends with an unsigned message")
+			}
+			return err
+		}
+	}
+}
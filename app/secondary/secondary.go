@@ -0,0 +1,297 @@
+package secondary
+
+import (
+	"com.sentry.dev/app/acl"
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/tsig"
+	"com.sentry.dev/app/zone"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// Timers used until the SOA of the zone is known
+const (
+	defaultRefresh = time.Hour
+	defaultRetry   = time.Minute
+	defaultExpire  = 7 * 24 * time.Hour
+	// minTimer keeps a zone with tiny timers from flooding its primary
+	minTimer = 5 * time.Second
+)
+
+// Zone keeps a copy of a zone in sync with its primary server. The SOA is
+// polled on the refresh and retry timers of the zone, or right away when
+// the primary sends a NOTIFY; the copy stops being served once the expire
+// timer runs out without reaching the primary.
+type Zone struct {
+	Origin string
+
+	primary     string
+	key         *tsig.Key // signs the requests to the primary
+	file        string
+	journalPath string
+	store       *zone.Store
+	notifyACL   *acl.List
+	notifyKeys  map[string]bool
+	notify      chan struct{}
+	lastRefresh time.Time
+}
+
+// New prepares the secondary copy of a zone, served from store. The TSIG
+// keys of the zone are looked up in keys.
+func New(cfg *config.ZoneConfig, journalPath string, store *zone.Store, keys tsig.Keyring) (*Zone, error) {
+	primary := cfg.Primary
+	if _, _, err := net.SplitHostPort(primary); err != nil {
+		primary = net.JoinHostPort(primary, "53")
+	}
+	host, _, _ := net.SplitHostPort(primary)
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil, fmt.Errorf("primary %q is not an IP address", cfg.Primary)
+	}
+
+	var key *tsig.Key
+	if cfg.PrimaryKey != "" {
+		if key = keys[dns.CanonicalName(cfg.PrimaryKey)]; key == nil {
+			return nil, fmt.Errorf("unknown TSIG key %q", cfg.PrimaryKey)
+		}
+	}
+
+	// NOTIFY is accepted from the primary, the configured ranges and
+	// the configured keys
+	aclConfig := &config.ACLConfig{
+		Default: "refuse",
+		Rules:   []config.ACLRule{{CIDR: netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()).String(), Action: "allow"}},
+	}
+	for _, cidr := range cfg.AllowNotify {
+		aclConfig.Rules = append(aclConfig.Rules, config.ACLRule{CIDR: cidr, Action: "allow"})
+	}
+	notifyACL, err := acl.New(aclConfig)
+	if err != nil {
+		return nil, err
+	}
+	notifyKeys := make(map[string]bool)
+	for _, name := range cfg.NotifyKeys {
+		name = dns.CanonicalName(name)
+		if keys[name] == nil {
+			return nil, fmt.Errorf("unknown TSIG key %q", name)
+		}
+		notifyKeys[name] = true
+	}
+
+	return &Zone{
+		Origin:      dns.CanonicalName(cfg.Origin),
+		primary:     primary,
+		key:         key,
+		file:        cfg.File,
+		journalPath: journalPath,
+		store:       store,
+		notifyACL:   notifyACL,
+		notifyKeys:  notifyKeys,
+		notify:      make(chan struct{}, 1),
+	}, nil
+}
+
+// Notify asks for a refresh after a NOTIFY from addr, signed with key when
+// not nil, and reports whether the sender may send one
+func (s *Zone) Notify(from netip.Addr, key *tsig.Key) bool {
+	if (key == nil || !s.notifyKeys[key.Name]) && s.notifyACL.Check(from) != acl.Allow {
+		return false
+	}
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Run keeps the zone in sync until ctx is done
+func (s *Zone) Run(ctx context.Context) {
+	s.loadFile()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.notify:
+		case <-timer.C:
+		}
+		timer.Reset(s.refresh(ctx))
+	}
+}
+
+// loadFile serves the copy saved by an earlier run, if any, as of the time
+// it was written
+func (s *Zone) loadFile() {
+	if s.file == "" {
+		return
+	}
+	info, err := os.Stat(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	z, err := zone.Load(s.file, s.Origin, s.journalPath)
+	if err != nil {
+		fmt.Println("Error loading zone", dns.Fqdn(s.Origin)+":", err)
+		return
+	}
+	s.store.Put(z)
+	s.lastRefresh = info.ModTime()
+	fmt.Println("Loaded secondary zone", dns.Fqdn(s.Origin), "serial", soaOf(z).Serial)
+}
+
+// refresh compares the serial of the primary with the local copy, transfers
+// the zone when it is behind, and returns the time until the next check
+func (s *Zone) refresh(ctx context.Context) time.Duration {
+	current := s.store.Get(s.Origin)
+	_, retry, expire := timers(current)
+
+	serial, err := s.querySerial(ctx)
+	if err == nil && (current == nil || zone.SerialLess(soaOf(current).Serial, serial)) {
+		err = s.transfer(ctx, current)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Println("Refresh of zone", dns.Fqdn(s.Origin), "from", s.primary, "failed:", err)
+		}
+		if current != nil && time.Since(s.lastRefresh) > expire {
+			fmt.Println("Zone", dns.Fqdn(s.Origin), "expired, no longer serving it")
+			s.store.Delete(s.Origin)
+		}
+		return retry
+	}
+	s.lastRefresh = time.Now()
+	refresh, _, _ := timers(s.store.Get(s.Origin))
+	return refresh
+}
+
+// querySerial asks the primary for the serial of the zone
+func (s *Zone) querySerial(ctx context.Context) (uint32, error) {
+	query := newQuery(s.Origin, _type.TypeSOA)
+	resp, err := exchange(ctx, s.primary, query, s.key)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range resp.Answers {
+		if soa, ok := r.Data.(*dns.SOA); ok && dns.CanonicalName(r.Name) == s.Origin {
+			return soa.Serial, nil
+		}
+	}
+	return 0, errors.New("no SOA record in the answer of the primary")
+}
+
+// transfer brings the zone up to date with IXFR when a copy exists, AXFR
+// otherwise, then saves it
+func (s *Zone) transfer(ctx context.Context, current *zone.Zone) error {
+	query := newQuery(s.Origin, _type.TypeAXFR)
+	if current != nil {
+		query.Questions[0].Type = _type.TypeIXFR
+		query.Authority = []*dns.Record{current.SOA()}
+	}
+	records, err := receive(ctx, s.primary, query, s.key)
+	if err != nil {
+		return err
+	}
+
+	var updated *zone.Zone
+	kind := "full"
+	switch {
+	case len(records) == 1:
+		// The primary has nothing newer for us
+		return nil
+	case current != nil && records[1].Type == _type.TypeSOA:
+		kind = "incremental"
+		deltas, err := zone.SplitDeltas(records[1 : len(records)-1])
+		if err != nil {
+			return err
+		}
+		if updated, err = current.Apply(deltas); err != nil {
+			return err
+		}
+	default:
+		fresh, err := zone.NewZone(s.Origin, records[:len(records)-1])
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			updated, err = current.Replace(fresh)
+		} else if updated = fresh; s.journalPath != "" {
+			err = fresh.AttachJournal(s.journalPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	s.store.Put(updated)
+	fmt.Println("Transferred zone", dns.Fqdn(s.Origin), "serial", soaOf(updated).Serial, "from", s.primary, "("+kind+")")
+	if s.file != "" {
+		if err = zone.WriteFile(s.file, updated); err != nil {
+			fmt.Println("Error saving zone", dns.Fqdn(s.Origin)+":", err)
+		}
+	}
+	return nil
+}
+
+// receive runs a zone transfer and returns its records, framing SOAs
+// included. An AXFR, or an IXFR answered with the whole zone, ends with the
+// second SOA of the latest serial; an incremental answer with the third.
+func receive(ctx context.Context, primary string, query *dns.Message, key *tsig.Key) ([]*dns.Record, error) {
+	var (
+		records     []*dns.Record
+		latest      uint32
+		seen        int
+		incremental bool
+	)
+	ixfr := query.Questions[0].Type == _type.TypeIXFR
+	err := stream(ctx, primary, query, key, func(msg *dns.Message) (bool, error) {
+		for _, r := range msg.Answers {
+			soa, isSOA := r.Data.(*dns.SOA)
+			switch {
+			case len(records) == 0 && !isSOA:
+				return false, errors.New("transfer does not start with a SOA record")
+			case len(records) == 0:
+				latest = soa.Serial
+			case len(records) == 1:
+				incremental = ixfr && isSOA
+			}
+			records = append(records, r)
+			if isSOA && soa.Serial == latest {
+				seen++
+			}
+		}
+		switch {
+		case len(records) == 0:
+			return false, errors.New("empty transfer message")
+		case ixfr && len(records) == 1:
+			return true, nil
+		case incremental:
+			return seen == 3, nil
+		default:
+			return seen == 2, nil
+		}
+	})
+	return records, err
+}
+
+// timers returns the refresh, retry and expire intervals of a zone
+func timers(z *zone.Zone) (refresh, retry, expire time.Duration) {
+	if z == nil {
+		return defaultRefresh, defaultRetry, defaultExpire
+	}
+	soa := soaOf(z)
+	return max(time.Duration(soa.Refresh)*time.Second, minTimer),
+		max(time.Duration(soa.Retry)*time.Second, minTimer),
+		time.Duration(soa.Expire) * time.Second
+}
+
+func soaOf(z *zone.Zone) *dns.SOA {
+	return z.SOA().Data.(*dns.SOA)
+}
@@ -0,0 +1,290 @@
+package secondary
+
+import (
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/zone"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// primary is a stand-in primary server answering SOA queries over UDP and
+// zone transfers over TCP from its zone
+type primary struct {
+	addr        string
+	zone        atomic.Pointer[zone.Zone]
+	journal     string
+	incremental atomic.Int32
+}
+
+func newPrimary(t *testing.T) *primary {
+	t.Helper()
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { udp.Close() })
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tcp.Close() })
+	p := &primary{addr: udp.LocalAddr().String(), journal: filepath.Join(t.TempDir(), "primary.jnl")}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			msg, err := dns.UnpackMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			msg.Header.QueryResponse = true
+			msg.Answers = []*dns.Record{p.zone.Load().SOA()}
+			if resp, err := msg.Pack(512); err == nil {
+				udp.WriteTo(resp, addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			go p.transfer(conn)
+		}
+	}()
+	return p
+}
+
+// transfer answers one AXFR or IXFR query read from conn
+func (p *primary) transfer(conn net.Conn) {
+	defer conn.Close()
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return
+	}
+	msg, err := dns.UnpackMessage(buf)
+	if err != nil {
+		return
+	}
+
+	z := p.zone.Load()
+	soa := z.SOA()
+	records := append(z.Records(), soa)
+	if msg.Questions[0].Type == _type.TypeIXFR {
+		serial := msg.Authority[0].Data.(*dns.SOA).Serial
+		if deltas, ok := z.Changes(serial); ok && len(deltas) == 0 {
+			records = []*dns.Record{soa}
+		} else if ok {
+			p.incremental.Add(1)
+			records = []*dns.Record{soa}
+			for _, d := range deltas {
+				records = append(records, d.Records()...)
+			}
+			records = append(records, soa)
+		}
+	}
+
+	msg.Header.QueryResponse = true
+	resp := &dns.Message{Header: msg.Header, Questions: msg.Questions}
+	messages, err := resp.PackStream(records, 65535)
+	if err != nil {
+		return
+	}
+	for _, m := range messages {
+		out := binary.BigEndian.AppendUint16(nil, uint16(len(m)))
+		if _, err := conn.Write(append(out, m...)); err != nil {
+			return
+		}
+	}
+}
+
+// set moves the zone of the primary to serial, with www at the given address
+func (p *primary) set(t *testing.T, serial uint32, www string) {
+	t.Helper()
+	fresh, err := zone.NewZone("example.", exampleRecords(serial, 3600, 600, 86400, www))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current := p.zone.Load(); current != nil {
+		fresh, err = current.Replace(fresh)
+	} else {
+		err = fresh.AttachJournal(p.journal)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.zone.Store(fresh)
+}
+
+func exampleRecords(serial, refresh, retry, expire uint32, www string) []*dns.Record {
+	return []*dns.Record{
+		{Name: "example", Type: _type.TypeSOA, Class: _type.ClassIN, TTL: 300, Data: &dns.SOA{
+			MName: "ns.example", RName: "hostmaster.example", Serial: serial, Refresh: refresh, Retry: retry, Expire: expire, Minimum: 60,
+		}},
+		{Name: "example", Type: _type.TypeNS, Class: _type.ClassIN, TTL: 300, Data: &dns.NS{Host: "ns.example"}},
+		{Name: "ns.example", Type: _type.TypeA, Class: _type.ClassIN, TTL: 300, Data: &dns.A{IP: net.IPv4(192, 0, 2, 1).To4()}},
+		{Name: "www.example", Type: _type.TypeA, Class: _type.ClassIN, TTL: 300, Data: &dns.A{IP: net.ParseIP(www).To4()}},
+	}
+}
+
+func newSecondary(t *testing.T, primary string) *Zone {
+	t.Helper()
+	s, err := New(&config.ZoneConfig{Origin: "example.", Primary: primary}, "", zone.NewStore(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// served returns the serial and the address of www of the zone served by s
+func served(s *Zone) (uint32, string) {
+	z := s.store.Get(s.Origin)
+	if z == nil {
+		return 0, ""
+	}
+	www := z.Lookup("www.example", _type.TypeA, false).Answer
+	if len(www) != 1 {
+		return soaOf(z).Serial, ""
+	}
+	return soaOf(z).Serial, www[0].Data.(*dns.A).IP.String()
+}
+
+func TestRefresh(t *testing.T) {
+	p := newPrimary(t)
+	s := newSecondary(t, p.addr)
+	ctx := context.Background()
+	for _, c := range []struct {
+		name    string
+		primary uint32
+		www     string
+		// the copy served after the refresh
+		serial      uint32
+		want        string
+		incremental int32
+	}{
+		{"first transfer", 4294967294, "192.0.2.2", 4294967294, "192.0.2.2", 0},
+		{"newer serial", 4294967295, "192.0.2.3", 4294967295, "192.0.2.3", 1},
+		// RFC 1982: 0 follows 4294967295
+		{"serial wrapped around", 0, "192.0.2.4", 0, "192.0.2.4", 2},
+		// a serial the copy is ahead of is not transferred
+		{"older serial", 4294967295, "192.0.2.5", 0, "192.0.2.4", 2},
+		// neither ahead nor behind, half the serial space apart
+		{"serial half the space apart", 1 << 31, "192.0.2.6", 0, "192.0.2.4", 2},
+	} {
+		p.set(t, c.primary, c.www)
+		if next := s.refresh(ctx); next != time.Hour {
+			t.Errorf("%s: next refresh in %s, want the refresh timer of the zone", c.name, next)
+		}
+		if serial, www := served(s); serial != c.serial || www != c.want {
+			t.Errorf("%s: serving serial %d with www at %s, want %d with %s", c.name, serial, www, c.serial, c.want)
+		}
+		if n := p.incremental.Load(); n != c.incremental {
+			t.Errorf("%s: %d incremental transfers, want %d", c.name, n, c.incremental)
+		}
+	}
+}
+
+func TestExpire(t *testing.T) {
+	// Nothing answers on the primary
+	s := newSecondary(t, "127.0.0.1:1")
+	for _, c := range []struct {
+		name        string
+		lastRefresh time.Duration
+		served      bool
+	}{
+		{"within the expire timer", 59 * time.Second, true},
+		{"past the expire timer", 61 * time.Second, false},
+	} {
+		z, err := zone.NewZone("example.", exampleRecords(1, 3600, 600, 60, "192.0.2.2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.store.Put(z)
+		s.lastRefresh = time.Now().Add(-c.lastRefresh)
+		// A failed refresh is retried on the retry timer of the zone
+		if next := s.refresh(context.Background()); next != 10*time.Minute {
+			t.Errorf("%s: next refresh in %s, want the retry timer", c.name, next)
+		}
+		if served := s.store.Get(s.Origin) != nil; served != c.served {
+			t.Errorf("%s: served %v, want %v", c.name, served, c.served)
+		}
+	}
+}
+
+func TestTimers(t *testing.T) {
+	for _, c := range []struct {
+		name                   string
+		zone                   []*dns.Record
+		refresh, retry, expire time.Duration
+	}{
+		{"no zone yet", nil, time.Hour, time.Minute, 7 * 24 * time.Hour},
+		{"from the SOA", exampleRecords(1, 7200, 900, 604800, "192.0.2.2"), 2 * time.Hour, 15 * time.Minute, 7 * 24 * time.Hour},
+		// too small timers would flood the primary
+		{"floored", exampleRecords(1, 1, 0, 30, "192.0.2.2"), 5 * time.Second, 5 * time.Second, 30 * time.Second},
+	} {
+		var z *zone.Zone
+		if c.zone != nil {
+			var err error
+			if z, err = zone.NewZone("example.", c.zone); err != nil {
+				t.Fatal(err)
+			}
+		}
+		refresh, retry, expire := timers(z)
+		if refresh != c.refresh || retry != c.retry || expire != c.expire {
+			t.Errorf("%s: %s, %s, %s", c.name, refresh, retry, expire)
+		}
+	}
+}
+
+func TestNotifyRefreshes(t *testing.T) {
+	p := newPrimary(t)
+	p.set(t, 1, "192.0.2.2")
+	s := newSecondary(t, p.addr)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	waitFor(t, s, 1)
+
+	p.set(t, 2, "192.0.2.3")
+	if s.Notify(netip.MustParseAddr("192.0.2.9"), nil) {
+		t.Error("NOTIFY from a stranger accepted")
+	}
+	if !s.Notify(netip.MustParseAddr("127.0.0.1"), nil) {
+		t.Fatal("NOTIFY from the primary refused")
+	}
+	// The refresh timer of the zone is an hour away
+	waitFor(t, s, 2)
+}
+
+// waitFor waits for s to serve serial
+func waitFor(t *testing.T, s *Zone, serial uint32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if got, _ := served(s); got == serial {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("serial %d not served", serial)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"com.sentry.dev/app/acl"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/metrics"
+	"com.sentry.dev/app/querylog"
+	"log"
+)
+
+func (server *UDPServer) configACL() {
+	var err error
+	if server.queryACL, err = acl.New(&server.Config.Access.Queries); err != nil {
+		log.Fatal(err)
+	}
+	if server.recursionACL, err = acl.New(&server.Config.Access.Recursion); err != nil {
+		log.Fatal(err)
+	}
+	if server.localDataACL, err = acl.New(&server.Config.Access.LocalData); err != nil {
+		log.Fatal(err)
+	}
+	if server.clientSubnetACL, err = acl.New(&server.Config.Access.ClientSubnet); err != nil {
+		log.Fatal(err)
+	}
+	if server.clientIDACL, err = acl.New(&server.Config.Access.ClientID); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// checkAccess evaluates the ACLs on the client address and reports whether
+// the request should be queued. Clients that can neither recurse nor read
+// local data get nothing, refused requests are answered right away.
+func (server *UDPServer) checkAccess(req *Request) bool {
+	addr := req.ClientAddr.Addr()
+	req.Recursion = server.recursionACL.Check(addr)
+	req.LocalData = server.localDataACL.Check(addr)
+
+	action := max(server.queryACL.Check(addr), min(req.Recursion, req.LocalData))
+	switch action {
+	case acl.Drop:
+		metrics.DeniedQueries.WithLabelValues("drop").Inc()
+		req.dropped = errDenied
+		return false
+	case acl.Refuse:
+		metrics.DeniedQueries.WithLabelValues("refuse").Inc()
+		if err := server.HandleError(req, _type.RCodeRefused, querylog.SourceACL); err != nil {
+			log.Println("handle response:", err)
+		}
+		return false
+	}
+	return true
+}
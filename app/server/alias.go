@@ -0,0 +1,93 @@
+package server
+
+import (
+	"com.sentry.dev/app/acl"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/querylog"
+)
+
+// maxAliasChain bounds the known_hosts aliases followed for one question
+const maxAliasChain = 8
+
+// configAliases indexes the aliases answered with their final addresses only
+func (server *UDPServer) configAliases() {
+	server.flattened = make(map[string]bool)
+	for _, name := range server.Config.Server.FlattenCNAMEs {
+		server.flattened[dns.CanonicalName(name)] = true
+	}
+}
+
+// resolveAlias answers a question for an alias of known_hosts: the CNAME
+// records of the chain through known_hosts, then the answer for the name it
+// ends at, looked up like any other question. A chain that loops or grows
+// too long fails with SERVFAIL.
+func (server *UDPServer) resolveAlias(req *Request, question *dns.Question, target string) resolution {
+	flattened := server.flattened[dns.CanonicalName(question.Name.String)]
+	name := question.Name.String
+	seen := map[string]bool{dns.CanonicalName(name): true}
+	var chain []*dns.Record
+	for {
+		target = dns.CanonicalName(target)
+		chain = append(chain, &dns.Record{
+			Name:  name,
+			Type:  _type.TypeCNAME,
+			Class: question.Class,
+			TTL:   server.Config.Server.CacheTTLSec,
+			Data:  &dns.CNAME{Target: target},
+		})
+		if question.Type == _type.TypeCNAME && !flattened {
+			return resolution{answers: chain, source: querylog.SourceLocal}
+		}
+		if seen[target] || len(chain) >= maxAliasChain {
+			return resolution{answers: chain, rcode: _type.RCodeServFail, source: querylog.SourceLocal}
+		}
+		seen[target] = true
+		next, err := server.lookUp(req.view.knownHosts, target)
+		if err != nil || parseAddrs(next) != nil {
+			break
+		}
+		name, target = target, next
+	}
+
+	res := server.resolve(req, &dns.Question{
+		Name:  &dns.Addr{String: target},
+		Type:  question.Type,
+		Class: question.Class,
+	})
+	// The chain is local data, clients refused recursion still get it, and
+	// known_hosts is no authority for the alias
+	res.denied = acl.Allow
+	res.authoritative = false
+	if flattened {
+		return flatten(res, question, chain[0].TTL)
+	}
+	res.answers = append(chain, res.answers...)
+	return res
+}
+
+// flatten answers for an alias with the records of the name its chain ends
+// at, renamed to the alias as if they were its own, for at most ttl. Their
+// signatures and the proofs of the other zone no longer hold and are left
+// out.
+func flatten(res resolution, question *dns.Question, ttl uint32) resolution {
+	var answers []*dns.Record
+	for _, r := range res.answers {
+		switch {
+		case r.Type == _type.TypeCNAME || r.Type == _type.TypeDNAME || r.Type == _type.TypeRRSIG:
+			continue
+		case question.Type != _type.TypeANY && r.Type != question.Type:
+			continue
+		}
+		flat := *r
+		flat.Name = question.Name.String
+		answers = append(answers, &flat)
+		ttl = min(ttl, r.TTL)
+	}
+	for _, r := range answers {
+		r.TTL = ttl
+	}
+	res.answers = answers
+	res.authority, res.additional = nil, nil
+	return res
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"com.sentry.dev/app/acl"
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/dnssec"
+	"com.sentry.dev/app/querylog"
+	"com.sentry.dev/app/secondary"
+	"com.sentry.dev/app/zone"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+func (server *UDPServer) configZones() {
+	server.zones = zone.NewStore()
+	server.transferAccess = make(map[string]*zoneAccess)
+	server.updateAccess = make(map[string]*zoneAccess)
+	server.secondaries = make(map[string]*secondary.Zone)
+	for _, zoneConfig := range server.Config.Zones {
+		if zoneConfig.Primary != "" {
+			server.configSecondary(&zoneConfig)
+			continue
+		}
+		z := loadZone(&zoneConfig)
+		if z == nil {
+			continue
+		}
+		server.transferAccess[z.Origin] = server.newZoneAccess(zoneConfig.AllowTransfer, zoneConfig.TransferKeys)
+		server.updateAccess[z.Origin] = server.newZoneAccess(zoneConfig.AllowUpdate, zoneConfig.UpdateKeys)
+		server.zones.Put(z)
+		fmt.Println("Loaded zone", dns.Fqdn(z.Origin), "serial", z.SOA().Data.(*dns.SOA).Serial)
+	}
+}
+
+// loadZone reads a zone from its file and signs it when configured, nil
+// when it cannot be served
+func loadZone(zoneConfig *config.ZoneConfig) *zone.Zone {
+	z, err := zone.Load(zoneConfig.File, zoneConfig.Origin, journalPath(zoneConfig))
+	if err != nil {
+		fmt.Println("Error loading zone", zoneConfig.Origin+":", err)
+		return nil
+	}
+	if zoneConfig.DNSSEC.Enabled {
+		if err = signZone(z, &zoneConfig.DNSSEC); err != nil {
+			fmt.Println("Error signing zone", zoneConfig.Origin+":", err)
+			return nil
+		}
+	}
+	return z
+}
+
+// signZone loads the keys of a zone and signs its answers on the fly. The
+// DS records of the KSKs are printed for the parent zone.
+func signZone(z *zone.Zone, c *config.ZoneDNSSECConfig) error {
+	if len(c.Keys) == 0 {
+		return errors.New("dnssec needs at least one key")
+	}
+	var keys []*dnssec.Key
+	for i := range c.Keys {
+		key, err := dnssec.LoadKey(&c.Keys[i])
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		if !key.KSK {
+			continue
+		}
+		ds, err := dnssec.NewDS(z.Origin, key.DNSKEY, dnssec.DigestSHA256)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("DS record of zone %s: %s IN DS %s\n", dns.Fqdn(z.Origin), dns.Fqdn(z.Origin), ds)
+	}
+	z.SetSigner(zone.NewSigner(keys, c.NSEC3, time.Duration(c.SignatureValidityDays)*24*time.Hour))
+	return nil
+}
+
+// configSecondary starts keeping a zone in sync with its primary
+func (server *UDPServer) configSecondary(zoneConfig *config.ZoneConfig) {
+	sec, err := secondary.New(zoneConfig, journalPath(zoneConfig), server.zones, server.tsigKeys)
+	if err != nil {
+		fmt.Println("Error configuring secondary zone", zoneConfig.Origin+":", err)
+		return
+	}
+	server.transferAccess[sec.Origin] = server.newZoneAccess(zoneConfig.AllowTransfer, zoneConfig.TransferKeys)
+	server.secondaries[sec.Origin] = sec
+	server.eventLoopGr.Add(1)
+	go func() {
+		defer server.eventLoopGr.Done()
+		sec.Run(server.Context)
+	}()
+}
+
+// ReloadZones reads the zone files again. Zones whose serial increased keep
+// the differences in their journal for incremental transfers.
+func (server *UDPServer) ReloadZones() {
+	server.zonesMu.Lock()
+	defer server.zonesMu.Unlock()
+	reloadZones(server.zones, server.Config.Zones)
+	for _, v := range server.views {
+		reloadZones(v.zones, v.zoneConfigs)
+	}
+}
+
+// reloadZones reads the files of the zones of a store again
+func reloadZones(zones *zone.Store, zoneConfigs []config.ZoneConfig) {
+	for _, zoneConfig := range zoneConfigs {
+		if zoneConfig.Primary != "" {
+			continue
+		}
+		current := zones.Get(zoneConfig.Origin)
+		if current == nil {
+			fmt.Println("Zone", zoneConfig.Origin, "was not loaded at startup, restart to serve it")
+			continue
+		}
+		z, err := current.Reload(zoneConfig.File)
+		if err != nil {
+			fmt.Println("Error reloading zone", zoneConfig.Origin+":", err)
+			continue
+		}
+		if z == current {
+			fmt.Println("Zone", dns.Fqdn(z.Origin), "unchanged")
+			continue
+		}
+		zones.Put(z)
+		fmt.Println("Reloaded zone", dns.Fqdn(z.Origin), "serial", z.SOA().Data.(*dns.SOA).Serial)
+	}
+}
+
+// journalPath returns where the journal of a zone is kept, next to its
+// file unless configured
+func journalPath(zoneConfig *config.ZoneConfig) string {
+	if zoneConfig.Journal != "" || zoneConfig.File == "" {
+		return zoneConfig.Journal
+	}
+	return zoneConfig.File + ".jnl"
+}
+
+// zoneAccess allows an operation on a zone, transfers or updates, to client
+// ranges and to requests signed with one of its TSIG keys
+type zoneAccess struct {
+	acl  *acl.List
+	keys map[string]bool
+}
+
+func (server *UDPServer) newZoneAccess(cidrs, keys []string) *zoneAccess {
+	access := &zoneAccess{acl: allowList(cidrs), keys: make(map[string]bool)}
+	for _, name := range keys {
+		name = dns.CanonicalName(name)
+		if server.tsigKeys[name] == nil {
+			log.Fatalf("unknown TSIG key %q", name)
+		}
+		access.keys[name] = true
+	}
+	return access
+}
+
+// allowList builds a list allowing the client ranges cidrs and refusing
+// everyone else
+func allowList(cidrs []string) *acl.List {
+	aclConfig := &config.ACLConfig{Default: "refuse"}
+	for _, cidr := range cidrs {
+		aclConfig.Rules = append(aclConfig.Rules, config.ACLRule{CIDR: cidr, Action: "allow"})
+	}
+	list, err := acl.New(aclConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return list
+}
+
+// allows reports whether req may perform the operation
+func (a *zoneAccess) allows(req *Request) bool {
+	if key := req.tsig.Key(); key != nil && a.keys[key.Name] {
+		return true
+	}
+	return a.acl.Check(req.ClientAddr.Addr()) == acl.Allow
+}
+
+// findZone returns the zone of zones answering question. The DS RRset of a
+// zone is answered by its parent when served too (RFC 4035 3.1.4.1).
+func findZone(zones *zone.Store, question *dns.Question) *zone.Zone {
+	z := zones.Find(question.Name.String)
+	if z != nil && question.Type == _type.TypeDS && z.Origin == dns.CanonicalName(question.Name.String) && z.Origin != "" {
+		if parent := zones.Find(dns.Parent(z.Origin)); parent != nil {
+			return parent
+		}
+	}
+	return z
+}
+
+// resolveZone answers a question from a zone served authoritatively, signed
+// when the client set the DO bit
+func resolveZone(z *zone.Zone, question *dns.Question, dnssecOK bool) resolution {
+	res := z.Lookup(question.Name.String, question.Type, dnssecOK)
+	return resolution{
+		answers:       res.Answer,
+		authority:     res.Authority,
+		additional:    res.Additional,
+		rcode:         res.RCode,
+		authoritative: res.Authoritative,
+		source:        querylog.SourceLocal,
+	}
+}
@@ -1,12 +1,16 @@
 package server
 
 import (
+	"com.sentry.dev/app/acl"
 	"com.sentry.dev/app/dns"
 	_type "com.sentry.dev/app/dns/type"
-	"com.sentry.dev/app/utils"
-	"fmt"
+	"com.sentry.dev/app/metrics"
+	"com.sentry.dev/app/querylog"
+	"com.sentry.dev/app/ratelimit"
 	"net"
+	"strings"
 	"sync"
+	"time"
 )
 
 // HandleRequest handle incoming request from client
@@ -14,21 +18,31 @@ func (server *UDPServer) HandleRequest() (
 	request *Request,
 	err error,
 ) {
-	buf := make([]byte, server.Config.UDP.PkgLimitRFC1035)
-	_, clientAddr, err := server.conn.ReadFromUDP(buf)
+	buf := make([]byte, server.Config.UDP.PkgLimitEDNS0)
+	n, clientAddr, err := server.conn.ReadFromUDP(buf)
 	if err != nil {
-		fmt.Println(err)
+		queryErrors.Println("read udp:", err)
 		return nil, err
 	}
-	header, questions, err := dns.ParseMessage(buf, server.Config.UDP.PkgLimitRFC1035)
+	receivedAt := time.Now()
+	server.tap.ClientQuery(clientAddr.AddrPort(), server.localAddr(), TransportUDP, buf[:n], receivedAt)
+	msg, err := dns.UnpackMessage(buf[:n])
 	if err != nil {
-		fmt.Println(err)
+		queryErrors.Println("malformed query from", clientAddr.IP.String()+":", err)
 		return nil, err
 	}
 	return &Request{
-		ClientAddr: clientAddr,
-		Header:     header,
-		Questions:  questions,
+		ClientAddr: clientAddr.AddrPort(),
+		LocalAddr:  server.localAddr(),
+		Transport:  TransportUDP,
+		ReceivedAt: receivedAt,
+		Message:    msg,
+		wire:       buf[:n],
+		reply: func(resp []byte) error {
+			_, err := server.conn.WriteToUDP(resp, clientAddr)
+			return err
+		},
+		limit: server.Config.UDP.PkgLimitRFC1035,
 	}, nil
 }
 
@@ -36,142 +50,352 @@ func (server *UDPServer) HandleRequest() (
 func (server *UDPServer) HandleResponse(
 	req *Request,
 ) error {
-	result := make([]byte, server.Config.UDP.PkgLimitRFC1035)
+	// The AD bit of a response is only set for validated answers, and only
+	// when asked for (RFC 6840 5.8), while CD is echoed (RFC 4035 3.2.2)
+	wantsAD := req.Header.Reserved&dns.FlagAD != 0 || dns.DNSSECOK(dns.FindOPT(req.Additional))
+	req.Header.Reserved &= dns.FlagCD
+	// EDNS clients may take UDP responses up to the payload size they
+	// advertise (RFC 6891 6.2.5)
+	if opt := dns.FindOPT(req.Additional); opt != nil && req.Transport == TransportUDP {
+		req.limit = max(req.limit, min(int(opt.Class), dns.EDNSPayload))
+	}
+	if rcode := server.authenticate(req); rcode != _type.RCodeNoError {
+		return server.HandleError(req, rcode, querylog.SourceNone)
+	}
+	switch _type.OpCode(req.Header.OperationCode) {
+	case _type.OpCodeQuery:
+	case _type.OpCodeNotify:
+		return server.notify(req)
+	case _type.OpCodeUpdate:
+		return server.update(req)
+	default:
+		return server.HandleError(req, _type.RCodeNotImp, querylog.SourceNone)
+	}
+	if isTransfer(req) {
+		return server.transfer(req)
+	}
 
-	pointerMap, remaining, err := server.writeQuestions(result, req.Questions)
-	if err != nil {
-		return err
+	req.view = server.selectView(req)
+	req.group = server.selectGroup(req)
+	answers := server.processQuestions(req)
+
+	resp := &dns.Message{
+		Header:    req.Header,
+		Questions: req.Questions,
+	}
+	req.Header.RecursionAvailable = req.Recursion == acl.Allow
+	req.Header.QueryResponse = true
+	req.Header.AuthoritativeAnswer = len(answers) > 0
+	authentic := wantsAD && len(answers) > 0
+	for _, answer := range answers {
+		switch answer.denied {
+		case acl.Drop:
+			req.dropped = errDenied
+			return nil
+		case acl.Refuse:
+			answer.rcode = _type.RCodeRefused
+		}
+		if req.Header.ResponseCode == uint8(_type.RCodeNoError) {
+			req.Header.ResponseCode = uint8(answer.rcode)
+		}
+		req.Header.AuthoritativeAnswer = req.Header.AuthoritativeAnswer && answer.authoritative
+		authentic = authentic && answer.authentic
+		resp.Answers = append(resp.Answers, answer.answers...)
+		resp.Authority = append(resp.Authority, answer.authority...)
+		resp.Additional = append(resp.Additional, answer.additional...)
 	}
+	resp.Additional = append(resp.Additional, replyOPT(req)...)
 
-	answers := server.processQuestions(req.Questions)
+	if authentic {
+		req.Header.Reserved |= dns.FlagAD
+	}
 
-	ansCount, remaining, err := server.writeAnswers(remaining, req.Questions, answers, pointerMap)
+	switch server.limitResponse(req) {
+	case ratelimit.Drop:
+		return nil
+	case ratelimit.Slip:
+		req.Header.Truncation = true
+		resp.Answers, resp.Authority, resp.Additional = nil, nil, nil
+		for i := range answers {
+			answers[i].answers = nil
+		}
+	}
+
+	msg, err := resp.Pack(req.limit)
 	if err != nil {
 		return err
 	}
+	return server.writeResponse(req, msg, answers)
+}
 
-	req.Header.RecursionAvailable = true
+// HandleError answers req with an empty response carrying rcode
+func (server *UDPServer) HandleError(
+	req *Request,
+	rcode _type.ResponseCode,
+	source string,
+) error {
+	req.Header.RecursionAvailable = req.Recursion == acl.Allow
 	req.Header.QueryResponse = true
-	if req.Header.OperationCode != 0 {
-		req.Header.ResponseCode = 4
+	req.Header.AuthoritativeAnswer = false
+	req.Header.Reserved &= dns.FlagCD
+	req.Header.ResponseCode = uint8(rcode)
+	resp := &dns.Message{
+		Header:     req.Header,
+		Questions:  req.Questions,
+		Additional: replyOPT(req),
 	}
-	req.Header.AnswerCount = ansCount
-
-	if ansCount == 0 && server.handleNoAnswer(req.ClientAddr, req.Header) != nil {
+	msg, err := resp.Pack(req.limit)
+	if err != nil {
 		return err
 	}
 
-	if _, err = req.Header.WriteTo(result); err != nil {
-		return err
+	answers := make([]resolution, len(req.Questions))
+	for i := range answers {
+		answers[i].source = source
 	}
+	return server.writeResponse(req, msg, answers)
+}
 
-	size := len(result) - len(remaining)
-	if _, err = server.conn.WriteToUDP(result[:size], req.ClientAddr); err != nil {
+// replyOPT returns the OPT record of the response to an EDNS request,
+// echoing its DO bit (RFC 3225 3)
+func replyOPT(req *Request) []*dns.Record {
+	opt := dns.FindOPT(req.Additional)
+	if opt == nil {
+		return nil
+	}
+	return []*dns.Record{dns.NewOPT(dns.EDNSPayload, dns.DNSSECOK(opt))}
+}
+
+// writeResponse sends an encoded response to the client and records it
+func (server *UDPServer) writeResponse(req *Request, msg []byte, answers []resolution) error {
+	if err := server.send(req, msg); err != nil {
 		return err
 	}
+	server.recordQueries(req, answers)
+	return nil
+}
 
+// send signs one encoded message when the request was signed and writes it
+// back to the client of req
+func (server *UDPServer) send(req *Request, msg []byte) error {
+	msg, err := req.tsig.Sign(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := req.reply(msg); err != nil {
+		return err
+	}
+	server.tap.ClientResponse(
+		req.ClientAddr,
+		req.LocalAddr,
+		req.Transport,
+		msg,
+		req.ReceivedAt,
+		time.Now(),
+	)
 	return nil
 }
 
-func (server *UDPServer) writeQuestions(buf []byte, questions []*dns.Question) (
-	pointerMap map[int]uint16,
-	remaining []byte,
-	err error,
-) {
-	pointerMap = make(map[int]uint16)
-	var pos uint16 = 12
-	remaining = buf[pos:]
-	for i, question := range questions {
-		remaining, err = question.WriteTo(remaining)
-		if err != nil {
-			return nil, buf, err
+// limitResponse applies Response Rate Limiting to the response of req.
+// Clients over TCP have proven their address and are not limited.
+func (server *UDPServer) limitResponse(req *Request) ratelimit.Action {
+	if req.Transport != TransportUDP {
+		return ratelimit.Send
+	}
+	var qname string
+	if len(req.Questions) > 0 {
+		qname = req.Questions[0].Name.String
+	}
+	action := server.responseLimiter.Check(req.ClientAddr.Addr(), qname, req.Header.ResponseCode)
+	switch action {
+	case ratelimit.Slip:
+		metrics.RRLResponses.WithLabelValues("slip").Inc()
+	case ratelimit.Drop:
+		metrics.RRLResponses.WithLabelValues("drop").Inc()
+	}
+	return action
+}
+
+// recordQueries reports every answered question to the metrics and the query log
+func (server *UDPServer) recordQueries(req *Request, answers []resolution) {
+	rcode := _type.ResponseCode(req.Header.ResponseCode).String()
+	latency := time.Since(req.ReceivedAt)
+	for i, question := range req.Questions {
+		metrics.Queries.WithLabelValues(question.Type.String(), rcode, req.Transport).Inc()
+
+		entry := &querylog.Entry{
+			Time:      req.ReceivedAt,
+			Client:    req.ClientAddr.Addr().String(),
+			QName:     question.Name.String,
+			QType:     question.Type.String(),
+			RCode:     rcode,
+			Source:    answers[i].source,
+			LatencyMs: float64(latency.Microseconds()) / 1000,
+			Transport: req.Transport,
 		}
-		pointerMap[i] = pos
-		pos += uint16(question.Size())
+		entry.Answers = len(answers[i].answers)
+		server.queryLog.Log(entry)
 	}
-	return pointerMap, remaining, nil
 }
 
-func (server *UDPServer) processQuestions(questions []*dns.Question) []net.IP {
+// resolution is the outcome of looking up a single question
+type resolution struct {
+	answers       []*dns.Record
+	authority     []*dns.Record
+	additional    []*dns.Record
+	rcode         _type.ResponseCode
+	authoritative bool
+	authentic     bool // validated with DNSSEC
+	source        string
+	denied        acl.Action
+}
+
+func (server *UDPServer) processQuestions(req *Request) []resolution {
 	var lookUpWg sync.WaitGroup
-	answers := make([]net.IP, len(questions))
-	for i, question := range questions {
+	results := make([]resolution, len(req.Questions))
+	for i, question := range req.Questions {
 		lookUpWg.Add(1)
 		go func(question *dns.Question, order int) {
 			defer lookUpWg.Done()
-			yes, _ := server.isBlackListed(question.Name.String)
-			if yes {
-				return
-			}
-			var addr []byte
-			if ip, err := server.lookUp(question.Name.String); err == nil {
-				addr = net.ParseIP(ip).To4()
-				if addr != nil {
-					answers[order] = addr
-					return
-				}
-			}
-			if ips, err := net.LookupIP(question.Name.String); err == nil {
-				for _, ip := range ips {
-					addr = ip.To4()
-					if addr != nil {
-						answers[order] = addr
-						ipStr := ip.String()
-						fmt.Println("Cached:", question.Name.String, "=>", ipStr)
-						server.cache.HSet(server.Context, utils.KnownHost, question.Name.String, ipStr)
-						server.cache.HExpire(
-							server.Context,
-							utils.KnownHost,
-							server.Config.Server.CacheTTLDuration(),
-							question.Name.String,
-						)
-						return
-					}
-				}
-			}
+			results[order] = server.resolve(req, question)
 		}(question, i)
 	}
 	lookUpWg.Wait()
-	return answers
+	return results
 }
 
-func (server *UDPServer) writeAnswers(
-	buf []byte,
-	questions []*dns.Question,
-	answers []net.IP,
-	pointerMap map[int]uint16,
-) (ansCount uint16, remaining []byte, err error) {
-	remaining = buf
-	for i, answer := range answers {
-		if answer != nil {
-			pointer := _type.ToPointer(pointerMap[i])
-			ansStruct := dns.Answer{
-				Pointer:  pointer,
-				Type:     questions[i].Type,
-				Class:    questions[i].Class,
-				TTL:      server.Config.Server.CacheTTLSec,
-				RDLength: uint16(len(answer)),
-				RData:    answer,
+func (server *UDPServer) resolve(req *Request, question *dns.Question) resolution {
+	v := req.view
+	if list := server.blockingList(req, question.Name.String); list != "" {
+		metrics.BlockedQueries.WithLabelValues(list).Inc()
+		return resolution{source: querylog.SourceBlocked}
+	}
+	if req.LocalData == acl.Allow {
+		if z := findZone(v.zones, question); z != nil {
+			return resolveZone(z, question, dns.DNSSECOK(dns.FindOPT(req.Additional)))
+		}
+		if value, err := server.lookUp(v.knownHosts, question.Name.String); err == nil {
+			ips := parseAddrs(value)
+			if ips == nil {
+				return server.resolveAlias(req, question, value)
 			}
-			if remaining, err = ansStruct.WriteTo(remaining); err != nil {
-				return 0, nil, err
+			return resolution{
+				answers: server.addressRecords(question, server.health.Filter(question.Name.String, ips)...),
+				source:  querylog.SourceLocal,
+			}
+		}
+		if hosts, err := server.lookUpReverse(v.reverseHosts, question.Name.String); err == nil {
+			return resolution{
+				answers: server.pointerRecords(question, hosts),
+				source:  querylog.SourceLocal,
 			}
-			ansCount++
 		}
 	}
-	return ansCount, remaining, nil
+	if req.Recursion != acl.Allow {
+		return resolution{source: querylog.SourceACL, denied: req.Recursion}
+	}
+	if upstreams := server.findForwarders(question.Name.String); upstreams != nil {
+		return server.forwardQuestion(upstreams, question, server.clientSubnet(req))
+	}
+	if server.resolver != nil && !v.forwarded {
+		return server.resolveIteratively(req, question)
+	}
+	ecs := server.clientSubnet(req)
+	if question.Class != _type.ClassIN || (question.Type != _type.TypeA && question.Type != _type.TypeAAAA) {
+		// Only addresses are cached, other questions are relayed as asked
+		if len(v.upstreams) == 0 {
+			return resolution{source: querylog.SourceUpstream}
+		}
+		res := server.forwardQuestion(v.upstreams, question, ecs)
+		res.source = querylog.SourceUpstream
+		return res
+	}
+	if value, err := server.lookUpCache(v.cache, cacheFields(question.Name.String, ecs)); err == nil {
+		if ips := parseAddrs(value); ips != nil {
+			metrics.CacheHits.Inc()
+			return resolution{
+				answers: server.addressRecords(question, ips...),
+				source:  querylog.SourceCache,
+			}
+		}
+	}
+	metrics.CacheMisses.Inc()
+	ips, scope, msg, err := server.lookUpUpstream(v.upstreams, question.Name.String, question.Type, ecs)
+	// a partial answer is served but not cached
+	if len(ips) > 0 && err == nil {
+		values := make([]string, len(ips))
+		for i, ip := range ips {
+			values[i] = ip.String()
+		}
+		field := cacheField(question.Name.String, ecs, scope)
+		server.cache.HSet(server.Context, v.cache, field, strings.Join(values, " "))
+		server.cache.HExpire(
+			server.Context,
+			v.cache,
+			server.Config.Server.CacheTTLDuration(),
+			field,
+		)
+	}
+	res := resolution{
+		answers: server.addressRecords(question, ips...),
+		source:  querylog.SourceUpstream,
+	}
+	switch {
+	case msg != nil:
+		// NXDOMAIN and the SOA of negative answers are passed on
+		res.rcode = _type.ResponseCode(msg.Header.ResponseCode)
+		if len(res.answers) == 0 {
+			res.authority = msg.Authority
+		}
+	case err != nil && len(v.upstreams) > 0:
+		res.rcode = _type.RCodeServFail
+	}
+	return res
 }
 
-func (server *UDPServer) handleNoAnswer(
-	clientAddr *net.UDPAddr,
-	respHeader *dns.Header,
-) (err error) {
-	buf := make([]byte, respHeader.Size())
-	if _, err = respHeader.WriteTo(buf); err != nil {
-		return err
+// addressRecords returns the A or AAAA records answering question among
+// ips, in the configured answer order
+func (server *UDPServer) addressRecords(question *dns.Question, ips ...net.IP) []*dns.Record {
+	var records []*dns.Record
+	for _, ip := range ips {
+		record := &dns.Record{
+			Name:  question.Name.String,
+			Class: question.Class,
+			TTL:   server.Config.Server.CacheTTLSec,
+		}
+		switch {
+		case ip == nil:
+			continue
+		case ip.To4() != nil && (question.Type == _type.TypeA || question.Type == _type.TypeANY):
+			record.Type = _type.TypeA
+			record.Data = &dns.A{IP: ip.To4()}
+		case ip.To4() == nil && (question.Type == _type.TypeAAAA || question.Type == _type.TypeANY):
+			record.Type = _type.TypeAAAA
+			record.Data = &dns.AAAA{IP: ip}
+		default:
+			continue
+		}
+		records = append(records, record)
 	}
-	if _, err = server.conn.WriteToUDP(buf, clientAddr); err != nil {
-		return err
+	server.answerOrder.apply(question, records)
+	return records
+}
+
+// pointerRecords returns the PTR records answering question, a reverse
+// name, with the known hosts holding its address
+func (server *UDPServer) pointerRecords(question *dns.Question, hosts []string) []*dns.Record {
+	if question.Type != _type.TypePTR && question.Type != _type.TypeANY {
+		return nil
 	}
-	return nil
+	var records []*dns.Record
+	for _, host := range hosts {
+		records = append(records, &dns.Record{
+			Name:  question.Name.String,
+			Type:  _type.TypePTR,
+			Class: question.Class,
+			TTL:   server.Config.Server.CacheTTLSec,
+			Data:  &dns.PTR{Target: host},
+		})
+	}
+	return records
 }
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"net"
+	"testing"
+)
+
+func TestHandleRequestReadsEDNSPayloads(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	server := &UDPServer{
+		Config: &config.Config{UDP: config.UDPConfig{PkgLimitRFC1035: 512, PkgLimitEDNS0: 4096}},
+		conn:   conn,
+	}
+
+	// A signed query may well be longer than 512 bytes, as this padded one
+	pad := &dns.Record{Name: "example", Type: _type.RecordType(65280), Class: _type.ClassIN, Data: &dns.Unknown{Data: make([]byte, 900)}}
+	query, err := (&dns.Message{
+		Header:     &dns.Header{ID: 1},
+		Questions:  []*dns.Question{{Name: &dns.Addr{String: "example"}, Type: _type.TypeA, Class: _type.ClassIN}},
+		Additional: []*dns.Record{pad},
+	}).Pack(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write(query); err != nil {
+		t.Fatal(err)
+	}
+	req, err := server.HandleRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.wire) != len(query) || len(req.Additional) != 1 {
+		t.Errorf("read %d of %d bytes, %d additional records", len(req.wire), len(query), len(req.Additional))
+	}
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dnsMessageType = "application/dns-message"
+	dnsJSONType    = "application/dns-json"
+	// dohTimeout bounds the wait for the answer to a query over HTTPS,
+	// which gets 504 Gateway Timeout once it runs out
+	dohTimeout = 10 * time.Second
+)
+
+// configDoH serves DNS over HTTPS (RFC 8484) over HTTP/2, with the JSON API
+// of public resolvers next to it
+func (server *UDPServer) configDoH() {
+	if !server.Config.DoH.Enabled {
+		return
+	}
+	addr := &net.TCPAddr{
+		Port: server.Config.DoH.Port,
+		IP:   net.IPv4zero,
+	}
+	listener, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(server.Config.DoH.Path, server.serveDoH)
+	mux.HandleFunc(server.Config.DoH.JSONPath, server.serveDoHJSON)
+	// A last path segment names the device of the client
+	mux.HandleFunc(server.Config.DoH.Path+"/{client}", server.serveDoH)
+	mux.HandleFunc(server.Config.DoH.JSONPath+"/{client}", server.serveDoHJSON)
+	server.doh = &http.Server{
+		Handler:           mux,
+		TLSConfig:         server.tlsConfig("h2", "http/1.1"),
+		ReadHeaderTimeout: server.Config.Server.TCPIdleTimeoutDuration(),
+		IdleTimeout:       server.Config.Server.TCPIdleTimeoutDuration(),
+	}
+	server.eventLoopGr.Add(1)
+	go func() {
+		defer server.eventLoopGr.Done()
+		if err := server.doh.ServeTLS(listener, "", ""); !errors.Is(err, http.ErrServerClosed) {
+			log.Println("Error serving DNS over HTTPS:", err)
+		}
+	}()
+}
+
+// closeDoH stops accepting requests and waits for the pending ones
+func (server *UDPServer) closeDoH() {
+	if server.doh == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dohTimeout)
+	defer cancel()
+	if err := server.doh.Shutdown(ctx); err != nil {
+		log.Println("Error closing DNS over HTTPS server:", err)
+	}
+}
+
+// serveDoH answers a query in wire format, sent base64url-encoded in the dns
+// parameter of a GET or as the body of a POST
+func (server *UDPServer) serveDoH(w http.ResponseWriter, r *http.Request) {
+	var (
+		buf []byte
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		buf, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dnsMessageType {
+			http.Error(w, "expected "+dnsMessageType, http.StatusUnsupportedMediaType)
+			return
+		}
+		buf, err = io.ReadAll(http.MaxBytesReader(w, r.Body, tcpMessageLimit))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil || len(buf) == 0 {
+		http.Error(w, "invalid DNS message", http.StatusBadRequest)
+		return
+	}
+
+	resp, status := server.exchangeHTTP(r, buf)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", dnsMessageType)
+	if msg, err := dns.UnpackMessage(resp); err == nil {
+		setCacheControl(w, msg)
+	}
+	w.Write(resp)
+}
+
+// serveDoHJSON answers /resolve?name=&type= in the JSON format of public
+// resolvers. The type is a mnemonic or a number and defaults to A; cd=1
+// disables DNSSEC checking.
+func (server *UDPServer) serveDoHJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	params := r.URL.Query()
+	name := dns.CanonicalName(params.Get("name"))
+	qtype, ok := parseQType(params.Get("type"))
+	if name == "" || !ok {
+		http.Error(w, "invalid name or type", http.StatusBadRequest)
+		return
+	}
+	query := &dns.Message{
+		Header: &dns.Header{ID: uint16(rand.Uint32()), RecursionDesired: true, Reserved: dns.FlagAD},
+		Questions: []*dns.Question{{
+			Name:  &dns.Addr{String: name},
+			Type:  qtype,
+			Class: _type.ClassIN,
+		}},
+	}
+	if cd := params.Get("cd"); cd == "1" || cd == "true" {
+		query.Header.Reserved |= dns.FlagCD
+	}
+	buf, err := query.Pack(tcpMessageLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, status := server.exchangeHTTP(r, buf)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	msg, err := dns.UnpackMessage(resp)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", dnsJSONType)
+	setCacheControl(w, msg)
+	json.NewEncoder(w).Encode(newJSONResponse(msg))
+}
+
+// exchangeHTTP runs a query received over HTTPS through the request
+// pipeline and waits for its single response message
+func (server *UDPServer) exchangeHTTP(r *http.Request, buf []byte) ([]byte, int) {
+	clientAddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil, http.StatusInternalServerError
+	}
+	var localAddr netip.AddrPort
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); ok {
+		localAddr = addr.AddrPort()
+	}
+	receivedAt := time.Now()
+	server.tap.ClientQuery(clientAddr, localAddr, TransportHTTPS, buf, receivedAt)
+	msg, err := dns.UnpackMessage(buf)
+	if err != nil {
+		return nil, http.StatusBadRequest
+	}
+
+	responses := make(chan []byte, 1)
+	finished := make(chan struct{})
+	req := &Request{
+		ClientAddr: clientAddr,
+		LocalAddr:  localAddr,
+		Transport:  TransportHTTPS,
+		ReceivedAt: receivedAt,
+		ClientID:   r.PathValue("client"),
+		Message:    msg,
+		wire:       buf,
+		reply: func(msg []byte) error {
+			select {
+			case responses <- msg:
+				return nil
+			default:
+				return errors.New("DNS over HTTPS carries a single response message")
+			}
+		},
+		limit: tcpMessageLimit,
+		done:  func() { close(finished) },
+	}
+	server.admit(req)
+
+	timer := time.NewTimer(dohTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-responses:
+		return resp, http.StatusOK
+	case <-finished:
+		// The response, if any, was sent before the request finished
+		select {
+		case resp := <-responses:
+			return resp, http.StatusOK
+		default:
+			return nil, dropStatus(req.dropped)
+		}
+	case <-r.Context().Done():
+		return nil, http.StatusServiceUnavailable
+	case <-server.Context.Done():
+		return nil, http.StatusServiceUnavailable
+	case <-timer.C:
+		return nil, http.StatusGatewayTimeout
+	}
+}
+
+// dropStatus is the status telling a client why its query was dropped
+// without a response
+func dropStatus(reason error) int {
+	switch reason {
+	case errRateLimited:
+		return http.StatusTooManyRequests
+	case errDenied:
+		return http.StatusForbidden
+	case errQueueFull:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// setCacheControl lets HTTP caches keep a response as long as its shortest
+// TTL (RFC 8484 5.1), leaving out the OPT and TSIG pseudo-records. A
+// negative answer is kept no longer than the minimum of its SOA either
+// (RFC 2308 5).
+func setCacheControl(w http.ResponseWriter, msg *dns.Message) {
+	ttl := uint32(math.MaxUint32)
+	for _, section := range [][]*dns.Record{msg.Answers, msg.Authority, msg.Additional} {
+		for _, r := range section {
+			if r.Type == _type.TypeOPT || r.Type == _type.TypeTSIG {
+				continue
+			}
+			ttl = min(ttl, r.TTL)
+			if soa, ok := r.Data.(*dns.SOA); ok && len(msg.Answers) == 0 {
+				ttl = min(ttl, soa.Minimum)
+			}
+		}
+	}
+	if ttl != math.MaxUint32 {
+		w.Header().Set("Cache-Control", "max-age="+strconv.FormatUint(uint64(ttl), 10))
+	}
+}
+
+// parseQType reads a record type given as a mnemonic or a number
+func parseQType(s string) (_type.RecordType, bool) {
+	if s == "" {
+		return _type.TypeA, true
+	}
+	if n, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return _type.RecordType(n), true
+	}
+	return _type.ParseRecordType(strings.ToUpper(s))
+}
+
+type jsonQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+type jsonRecord struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+type jsonResponse struct {
+	Status     uint8          `json:"Status"`
+	TC         bool           `json:"TC"`
+	RD         bool           `json:"RD"`
+	RA         bool           `json:"RA"`
+	AD         bool           `json:"AD"`
+	CD         bool           `json:"CD"`
+	Question   []jsonQuestion `json:"Question"`
+	Answer     []jsonRecord   `json:"Answer,omitempty"`
+	Authority  []jsonRecord   `json:"Authority,omitempty"`
+	Additional []jsonRecord   `json:"Additional,omitempty"`
+}
+
+func newJSONResponse(msg *dns.Message) *jsonResponse {
+	resp := &jsonResponse{
+		Status: msg.Header.ResponseCode,
+		TC:     msg.Header.Truncation,
+		RD:     msg.Header.RecursionDesired,
+		RA:     msg.Header.RecursionAvailable,
+		AD:     msg.Header.Reserved&dns.FlagAD != 0,
+		CD:     msg.Header.Reserved&dns.FlagCD != 0,
+	}
+	for _, q := range msg.Questions {
+		resp.Question = append(resp.Question, jsonQuestion{Name: dns.Fqdn(q.Name.String), Type: uint16(q.Type)})
+	}
+	resp.Answer = jsonRecords(msg.Answers)
+	resp.Authority = jsonRecords(msg.Authority)
+	resp.Additional = jsonRecords(msg.Additional)
+	return resp
+}
+
+func jsonRecords(records []*dns.Record) []jsonRecord {
+	var out []jsonRecord
+	for _, r := range records {
+		out = append(out, jsonRecord{Name: dns.Fqdn(r.Name), Type: uint16(r.Type), TTL: r.TTL, Data: r.Data.String()})
+	}
+	return out
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"com.sentry.dev/app/acl"
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/ratelimit"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func newDoHServer(t *testing.T, queries string) *UDPServer {
+	t.Helper()
+	server := &UDPServer{
+		Config:     &config.Config{Server: config.ServerConfig{EventQueueTimeout: 1}},
+		Context:    context.Background(),
+		eventQueue: make(chan *Request),
+	}
+	var err error
+	if server.queryACL, err = acl.New(&config.ACLConfig{Default: queries}); err != nil {
+		t.Fatal(err)
+	}
+	server.recursionACL, _ = acl.New(&config.ACLConfig{})
+	server.localDataACL, _ = acl.New(&config.ACLConfig{})
+	return server
+}
+
+// Queries dropped on the way to the workers are told so at once, rather
+// than when the wait for their answer runs out
+func TestDoHDroppedQueries(t *testing.T) {
+	limited := newDoHServer(t, "allow")
+	limited.queryLimiter = ratelimit.NewQueryLimiter(&config.RateLimitConfig{
+		Enabled: true, QueriesPerSecond: 0.001, Burst: 1, SubnetQueriesPerSecond: 100, SubnetBurst: 100, IPv4Prefix: 24, IPv6Prefix: 56,
+	})
+	limited.queryLimiter.Allow(netip.MustParseAddr("192.0.2.1"))
+
+	query, err := (&dns.Message{
+		Header:    &dns.Header{ID: 1, RecursionDesired: true},
+		Questions: []*dns.Question{{Name: &dns.Addr{String: "example"}, Type: _type.TypeA, Class: _type.ClassIN}},
+	}).Pack(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name   string
+		server *UDPServer
+		status int
+	}{
+		{"rate limited", limited, http.StatusTooManyRequests},
+		{"dropped by an ACL", newDoHServer(t, "drop"), http.StatusForbidden},
+		{"queue full", newDoHServer(t, "allow"), http.StatusServiceUnavailable},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/dns-query?dns="+base64.RawURLEncoding.EncodeToString(query), nil)
+		w := httptest.NewRecorder()
+		start := time.Now()
+		c.server.serveDoH(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s: status %d, want %d", c.name, w.Code, c.status)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: answered after %v", c.name, elapsed)
+		}
+	}
+}
+
+func TestSetCacheControl(t *testing.T) {
+	a := &dns.Record{Name: "www.example", Type: _type.TypeA, Class: _type.ClassIN, TTL: 300, Data: &dns.A{IP: net.IPv4(192, 0, 2, 1)}}
+	soa := &dns.Record{Name: "example", Type: _type.TypeSOA, Class: _type.ClassIN, TTL: 3600, Data: &dns.SOA{
+		MName: "ns.example", RName: "hostmaster.example", Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, Minimum: 60,
+	}}
+	opt := dns.NewOPT(dns.EDNSPayload, false)
+	tsig := &dns.Record{Name: "key", Type: _type.TypeTSIG, Class: _type.ClassANY, Data: &dns.TSIG{Algorithm: "hmac-sha256"}}
+
+	for _, c := range []struct {
+		name string
+		msg  *dns.Message
+		want string
+	}{
+		{"answer", &dns.Message{Answers: []*dns.Record{a}, Additional: []*dns.Record{opt, tsig}}, "max-age=300"},
+		{"negative answer", &dns.Message{Authority: []*dns.Record{soa}, Additional: []*dns.Record{opt}}, "max-age=60"},
+		{"answer with the SOA", &dns.Message{Answers: []*dns.Record{a}, Authority: []*dns.Record{soa}}, "max-age=300"},
+		{"no records", &dns.Message{Additional: []*dns.Record{opt}}, ""},
+	} {
+		w := httptest.NewRecorder()
+		setCacheControl(w, c.msg)
+		if got := w.Header().Get("Cache-Control"); got != c.want {
+			t.Errorf("%s: Cache-Control %q, want %q", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"com.sentry.dev/app/acl"
+	"com.sentry.dev/app/dns"
+	"net/netip"
+)
+
+// trustedClientSubnet returns the client subnet option of req when its
+// client is trusted to send one, nil otherwise
+func (server *UDPServer) trustedClientSubnet(req *Request) *dns.ClientSubnet {
+	if server.clientSubnetACL.Check(req.ClientAddr.Addr()) != acl.Allow {
+		return nil
+	}
+	return dns.FindClientSubnet(dns.FindOPT(req.Additional))
+}
+
+// clientSubnet returns the client subnet option sent to the upstreams for
+// req: the one of a trusted client, or else its address, truncated to the
+// configured prefix length. It is nil when disabled and for networks that
+// are not public, which mean nothing to the upstreams.
+func (server *UDPServer) clientSubnet(req *Request) *dns.ClientSubnet {
+	c := &server.Config.ClientSubnet
+	if !c.Enabled {
+		return nil
+	}
+	addr, source := req.ClientAddr.Addr().Unmap(), 128
+	if ecs := server.trustedClientSubnet(req); ecs != nil {
+		addr, source = ecs.Prefix.Addr(), ecs.Prefix.Bits()
+	}
+	bits := c.IPv6Prefix
+	if addr.Is4() {
+		bits = c.IPv4Prefix
+	}
+	bits = min(bits, source)
+	if bits != 0 && (!addr.IsGlobalUnicast() || addr.IsPrivate()) {
+		return nil
+	}
+	return &dns.ClientSubnet{Prefix: netip.PrefixFrom(addr, bits).Masked()}
+}
+
+// cacheField returns the cache field of an answer for name asked with
+// ecs, for the clients within scope bits of its subnet. Answers for every
+// client, asked without a subnet or scoped to none, are kept under the
+// name alone.
+func cacheField(name string, ecs *dns.ClientSubnet, scope int) string {
+	if ecs == nil || scope == 0 {
+		return name
+	}
+	prefix := netip.PrefixFrom(ecs.Prefix.Addr(), min(scope, ecs.Prefix.Bits())).Masked()
+	return name + "/" + prefix.String()
+}
+
+// cacheFields returns the fields an answer for name asked with ecs may be
+// cached under, the most specific first
+func cacheFields(name string, ecs *dns.ClientSubnet) []string {
+	var fields []string
+	if ecs != nil {
+		for bits := ecs.Prefix.Bits(); bits > 0; bits-- {
+			fields = append(fields, cacheField(name, ecs, bits))
+		}
+	}
+	return append(fields, name)
+}
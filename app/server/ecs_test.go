@@ -0,0 +1,108 @@
+package server
+
+import (
+	"com.sentry.dev/app/acl"
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func newECSServer(t *testing.T, enabled bool) *UDPServer {
+	t.Helper()
+	trusted, err := acl.New(&config.ACLConfig{
+		Default: "refuse",
+		Rules:   []config.ACLRule{{CIDR: "10.0.0.1/32", Action: "allow"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &UDPServer{
+		Config: &config.Config{ClientSubnet: config.ClientSubnetConfig{
+			Enabled:    enabled,
+			IPv4Prefix: 24,
+			IPv6Prefix: 56,
+		}},
+		clientSubnetACL: trusted,
+	}
+}
+
+func newECSRequest(client string, ecs *dns.ClientSubnet) *Request {
+	req := &Request{
+		ClientAddr: netip.AddrPortFrom(netip.MustParseAddr(client), 53000),
+		Message:    &dns.Message{Header: &dns.Header{}},
+	}
+	if ecs != nil {
+		opt := dns.NewOPT(dns.EDNSPayload, false)
+		opt.Data = &dns.Unknown{Data: ecs.Pack()}
+		req.Additional = []*dns.Record{opt}
+	}
+	return req
+}
+
+func TestClientSubnet(t *testing.T) {
+	server := newECSServer(t, true)
+	forwarded := &dns.ClientSubnet{Prefix: netip.MustParsePrefix("203.0.113.0/28")}
+	for _, c := range []struct {
+		client string
+		ecs    *dns.ClientSubnet
+		want   string
+	}{
+		{"198.51.100.7", nil, "198.51.100.0/24"},
+		{"2001:db8:1:2:3::1", nil, "2001:db8:1::/56"},
+		{"::ffff:198.51.100.7", nil, "198.51.100.0/24"},
+		// Private networks mean nothing to the upstreams
+		{"192.168.1.7", nil, ""},
+		{"127.0.0.1", nil, ""},
+		// The option of a trusted forwarder stands for its address, never
+		// longer than it was sent
+		{"10.0.0.1", forwarded, "203.0.113.0/24"},
+		{"10.0.0.1", &dns.ClientSubnet{Prefix: netip.MustParsePrefix("203.0.113.0/16")}, "203.0.0.0/16"},
+		// A client opting out asks for no subnet to be sent
+		{"10.0.0.1", &dns.ClientSubnet{Prefix: netip.MustParsePrefix("0.0.0.0/0")}, "0.0.0.0/0"},
+		{"198.51.100.7", forwarded, "198.51.100.0/24"},
+	} {
+		got := server.clientSubnet(newECSRequest(c.client, c.ecs))
+		switch {
+		case got == nil && c.want != "":
+			t.Errorf("%s: no subnet, want %s", c.client, c.want)
+		case got != nil && got.Prefix.String() != c.want:
+			t.Errorf("%s: subnet %v, want %q", c.client, got.Prefix, c.want)
+		}
+	}
+	if got := newECSServer(t, false).clientSubnet(newECSRequest("198.51.100.7", nil)); got != nil {
+		t.Errorf("disabled: subnet %v", got.Prefix)
+	}
+}
+
+func TestCacheFields(t *testing.T) {
+	ecs := &dns.ClientSubnet{Prefix: netip.MustParsePrefix("198.51.100.0/24")}
+	if got := cacheField("example", nil, 24); got != "example" {
+		t.Errorf("field without a subnet %q", got)
+	}
+	if got := cacheField("example", ecs, 0); got != "example" {
+		t.Errorf("field of an answer for every client %q", got)
+	}
+	if got := cacheField("example", ecs, 20); got != "example/198.51.96.0/20" {
+		t.Errorf("field of scope 20 %q", got)
+	}
+	// A scope longer than the source only holds for the source network
+	if got := cacheField("example", ecs, 32); got != "example/198.51.100.0/24" {
+		t.Errorf("field of scope 32 %q", got)
+	}
+
+	fields := cacheFields("example", ecs)
+	if len(fields) != 25 || fields[0] != "example/198.51.100.0/24" || fields[24] != "example" {
+		t.Errorf("fields %v", fields)
+	}
+	// Whatever the scope of the answer, its field is looked up
+	for scope := 0; scope <= 32; scope++ {
+		if !slices.Contains(fields, cacheField("example", ecs, scope)) {
+			t.Errorf("field of scope %d not looked up", scope)
+		}
+	}
+	if got := cacheFields("example", nil); !slices.Equal(got, []string{"example"}) {
+		t.Errorf("fields without a subnet %v", got)
+	}
+}
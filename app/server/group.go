@@ -0,0 +1,247 @@
+package server
+
+import (
+	"com.sentry.dev/app/acl"
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	"com.sentry.dev/app/utils"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// clientGroup is the filtering of the queries of a group of clients
+type clientGroup struct {
+	name    string
+	clients *acl.List
+	// Redis keys of the lists of the group
+	blockLists []string
+	allowLists []string
+	schedules  []*schedule
+}
+
+// schedule enables more blocklists during a window of the week
+type schedule struct {
+	// days the window starts on, by time.Weekday
+	days [7]bool
+	// from and to are minutes past midnight
+	from       int
+	to         int
+	blockLists []string
+}
+
+// weekdays maps the day names of schedules to their weekday
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// configGroups loads the domain lists into Redis and indexes the client
+// groups by MAC address and identifier
+func (server *UDPServer) configGroups() {
+	blockLists := server.loadDomainLists(server.Config.Blocklists, utils.BlockList)
+	allowLists := server.loadDomainLists(server.Config.Allowlists, utils.AllowList)
+	server.groupsByDevice = make(map[string]*clientGroup)
+	for _, c := range server.Config.ClientGroups {
+		g := &clientGroup{
+			name:       c.Name,
+			clients:    allowList(c.Clients),
+			blockLists: listKeys(c.Name, c.Blocklists, blockLists),
+			allowLists: listKeys(c.Name, c.Allowlists, allowLists),
+		}
+		for _, sc := range c.Schedules {
+			s, err := newSchedule(&sc)
+			if err != nil {
+				log.Fatalf("schedule of client group %q: %v", c.Name, err)
+			}
+			s.blockLists = listKeys(c.Name, sc.Blocklists, blockLists)
+			g.schedules = append(g.schedules, s)
+		}
+		for _, value := range c.MACs {
+			mac, err := net.ParseMAC(value)
+			if err != nil {
+				log.Fatalf("client group %q: %v", c.Name, err)
+			}
+			server.addGroupDevice(mac.String(), g)
+		}
+		for _, id := range c.Identifiers {
+			server.addGroupDevice(id, g)
+		}
+		server.groups = append(server.groups, g)
+	}
+}
+
+// loadDomainLists adds the domains of each list to a Redis set under
+// prefix and returns the keys of the sets by list name
+func (server *UDPServer) loadDomainLists(lists []config.DomainListConfig, prefix string) map[string]string {
+	keys := make(map[string]string)
+	for _, list := range lists {
+		if list.Name == "" || keys[list.Name] != "" {
+			log.Fatalf("list name %q is empty or defined twice", list.Name)
+		}
+		keys[list.Name] = prefix + ":" + list.Name
+		server.loadBlackList(list.File, keys[list.Name])
+	}
+	return keys
+}
+
+// listKeys returns the Redis keys of the lists a group names
+func listKeys(group string, names []string, keys map[string]string) []string {
+	var listKeys []string
+	for _, name := range names {
+		key, ok := keys[name]
+		if !ok {
+			log.Fatalf("client group %q uses unknown list %q", group, name)
+		}
+		listKeys = append(listKeys, key)
+	}
+	return listKeys
+}
+
+func (server *UDPServer) addGroupDevice(device string, g *clientGroup) {
+	if other := server.groupsByDevice[device]; other != nil {
+		log.Fatalf("device %q is in client groups %q and %q", device, other.name, g.name)
+	}
+	server.groupsByDevice[device] = g
+}
+
+func newSchedule(c *config.ScheduleConfig) (*schedule, error) {
+	s := &schedule{}
+	for _, name := range c.Days {
+		day, ok := weekdays[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", name)
+		}
+		s.days[day] = true
+	}
+	if len(c.Days) == 0 {
+		s.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	var err error
+	if s.from, err = parseMinutes(c.From); err != nil {
+		return nil, err
+	}
+	if s.to, err = parseMinutes(c.To); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parseMinutes returns the minutes past midnight of a time of day written
+// as 15:04, midnight when empty
+func parseMinutes(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// active reports whether now falls in the window, which may have started
+// the day before when it runs over midnight
+func (s *schedule) active(now time.Time) bool {
+	day, minute := now.Weekday(), now.Hour()*60+now.Minute()
+	switch {
+	case s.from == s.to:
+		return s.days[day]
+	case s.from < s.to:
+		return s.days[day] && minute >= s.from && minute < s.to
+	}
+	return s.days[day] && minute >= s.from || s.days[(day+6)%7] && minute < s.to
+}
+
+// activeBlockLists returns the keys of the blocklists of the group enabled
+// at now
+func (g *clientGroup) activeBlockLists(now time.Time) []string {
+	keys := g.blockLists
+	for _, s := range g.schedules {
+		if s.active(now) {
+			keys = append(slices.Clip(keys), s.blockLists...)
+		}
+	}
+	return keys
+}
+
+// selectGroup returns the client group of req, nil when it is in none. A
+// device named by a trusted forwarder or by the DoH path is in its group,
+// other clients are in the first group whose ranges include them.
+func (server *UDPServer) selectGroup(req *Request) *clientGroup {
+	if len(server.groups) == 0 {
+		return nil
+	}
+	devices := []string{req.ClientID}
+	if server.clientIDACL.Check(req.ClientAddr.Addr()) == acl.Allow {
+		opt := dns.FindOPT(req.Additional)
+		if mac := dns.FindMAC(opt); mac != nil {
+			devices = append(devices, mac.String())
+		}
+		devices = append(devices, dns.FindCPEID(opt))
+	}
+	for _, device := range devices {
+		if g := server.groupsByDevice[device]; g != nil && device != "" {
+			return g
+		}
+	}
+	for _, g := range server.groups {
+		if g.clients.Check(req.ClientAddr.Addr()) == acl.Allow {
+			return g
+		}
+	}
+	return nil
+}
+
+// blockingList returns the key of the list blocking name for the client of
+// req, empty when it is not blocked. Clients outside of any group get the
+// blacklist of their view.
+func (server *UDPServer) blockingList(req *Request, name string) string {
+	if req.group == nil {
+		if yes, _ := server.isBlackListed(req.view.blackList, name); yes {
+			return req.view.blackList
+		}
+		return ""
+	}
+	blockLists := req.group.activeBlockLists(time.Now())
+	if len(blockLists) == 0 {
+		return ""
+	}
+	if allowed, _ := server.findList(req.group.allowLists, name); allowed != "" {
+		return ""
+	}
+	blocked, _ := server.findList(blockLists, name)
+	return blocked
+}
+
+// findList returns the key of the first of the sets at keys holding name
+func (server *UDPServer) findList(keys []string, name string) (string, error) {
+	if len(keys) == 0 {
+		return "", nil
+	}
+	cmds := make([]*redis.BoolCmd, len(keys))
+	_, err := server.cache.Pipelined(server.Context, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.SIsMember(server.Context, key, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	for i, cmd := range cmds {
+		if cmd.Val() {
+			return keys[i], nil
+		}
+	}
+	return "", nil
+}
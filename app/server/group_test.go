@@ -0,0 +1,80 @@
+package server
+
+import (
+	"com.sentry.dev/app/config"
+	"testing"
+	"time"
+)
+
+func TestNewSchedule(t *testing.T) {
+	s, err := newSchedule(&config.ScheduleConfig{Days: []string{"Mon", "fri"}, From: "21:30", To: "07:05"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.days != [7]bool{time.Monday: true, time.Friday: true} || s.from != 21*60+30 || s.to != 7*60+5 {
+		t.Errorf("schedule %+v", s)
+	}
+	if s, err := newSchedule(&config.ScheduleConfig{}); err != nil || s.days != [7]bool{true, true, true, true, true, true, true} || s.from != 0 || s.to != 0 {
+		t.Errorf("schedule without days or times %+v, error %v", s, err)
+	}
+	for _, c := range []config.ScheduleConfig{
+		{Days: []string{"monday"}},
+		{From: "25:00"},
+		{To: "9pm"},
+	} {
+		if _, err := newSchedule(&c); err == nil {
+			t.Errorf("schedule %+v accepted", c)
+		}
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	// 2024-01-01 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+	window := func(from, to string, days ...string) *schedule {
+		s, err := newSchedule(&config.ScheduleConfig{Days: days, From: from, To: to})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	school := window("08:00", "15:00", "mon", "tue", "wed", "thu", "fri")
+	night := window("21:00", "07:00", "sun", "mon", "tue", "wed", "thu")
+	weekend := window("", "", "sat", "sun")
+	for _, c := range []struct {
+		name     string
+		schedule *schedule
+		now      time.Time
+		want     bool
+	}{
+		{"school on Monday morning", school, at(1, 8, 0), true},
+		{"school at its end", school, at(1, 15, 0), false},
+		{"school before it starts", school, at(1, 7, 59), false},
+		{"school on Saturday", school, at(6, 10, 0), false},
+		{"night on Monday evening", night, at(1, 22, 0), true},
+		{"night after midnight on Tuesday", night, at(2, 6, 59), true},
+		{"night at its end", night, at(2, 7, 0), false},
+		{"night during the day", night, at(2, 12, 0), false},
+		// Friday night starts on no listed day, Thursday night ends on one
+		// that is not
+		{"night on Friday evening", night, at(5, 23, 0), false},
+		{"night after midnight on Friday", night, at(5, 1, 0), true},
+		{"night after midnight on Saturday", night, at(6, 1, 0), false},
+		// and Sunday night runs into Monday
+		{"night after midnight on Monday", night, at(8, 1, 0), true},
+		{"weekend at midnight", weekend, at(6, 0, 0), true},
+		{"weekend late on Sunday", weekend, at(7, 23, 59), true},
+		{"weekend on Monday", weekend, at(8, 0, 0), false},
+		// The window is read in the time zone of now: 23:00 UTC on Monday
+		// is 08:00 on Tuesday in Tokyo
+		{"school in Tokyo", school, at(1, 23, 0).In(time.FixedZone("JST", 9*3600)), true},
+		{"night in Tokyo", night, at(1, 22, 0).In(time.FixedZone("JST", 9*3600)), false},
+		{"night west of UTC", night, at(2, 8, 0).In(time.FixedZone("EST", -5*3600)), true},
+	} {
+		if got := c.schedule.active(c.now); got != c.want {
+			t.Errorf("%s (%s): active %v, want %v", c.name, c.now.Format(time.RFC1123Z), got, c.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"com.sentry.dev/app/dns"
+	"com.sentry.dev/app/health"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+)
+
+// configHealthChecks starts checking the addresses the known hosts of
+// every view give a checked name, each address once whatever the views
+// sharing it
+func (server *UDPServer) configHealthChecks() {
+	checker, err := health.New(server.Config.HealthChecks)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.health = checker
+	keys := []string{server.defaultView.knownHosts}
+	for _, v := range server.views {
+		if !slices.Contains(keys, v.knownHosts) {
+			keys = append(keys, v.knownHosts)
+		}
+	}
+	for _, name := range checker.Names() {
+		var ips []net.IP
+		for _, key := range keys {
+			value, err := server.lookUp(key, name)
+			if err != nil {
+				continue
+			}
+			for _, ip := range parseAddrs(value) {
+				if !slices.ContainsFunc(ips, ip.Equal) {
+					ips = append(ips, ip)
+				}
+			}
+		}
+		if ips == nil {
+			fmt.Println("Health check of", dns.Fqdn(name), "has no addresses in known_hosts")
+			continue
+		}
+		server.eventLoopGr.Add(1)
+		go func() {
+			defer server.eventLoopGr.Done()
+			checker.Watch(server.Context, name, ips)
+		}()
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// queryErrors logs the errors met while serving single queries. A client
+// sending garbage must not be able to flood the log, so one line is
+// written a second at most.
+var queryErrors = &throttledLog{interval: time.Second}
+
+// throttledLog is a log writing at most one line per interval, and
+// counting the lines it drops in between
+type throttledLog struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	last       time.Time
+	suppressed int
+}
+
+// Println logs v unless a line was logged less than an interval ago
+func (l *throttledLog) Println(v ...any) {
+	l.mu.Lock()
+	now := time.Now()
+	if now.Sub(l.last) < l.interval {
+		l.suppressed++
+		l.mu.Unlock()
+		return
+	}
+	suppressed := l.suppressed
+	l.last, l.suppressed = now, 0
+	l.mu.Unlock()
+	if suppressed > 0 {
+		v = append(v, fmt.Sprintf("(%d more errors suppressed)", suppressed))
+	}
+	log.Println(v...)
+}
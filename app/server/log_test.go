@@ -0,0 +1,28 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottledLog(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	l := &throttledLog{interval: time.Hour}
+	for range 3 {
+		l.Println("bad query")
+	}
+	if n := strings.Count(out.String(), "bad query"); n != 1 {
+		t.Fatalf("%d lines logged within the interval, want 1", n)
+	}
+	l.last = l.last.Add(-time.Hour)
+	l.Println("bad query")
+	if !strings.Contains(out.String(), "bad query (2 more errors suppressed)") {
+		t.Errorf("log %q does not count the dropped lines", out.String())
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/metrics"
+	"com.sentry.dev/app/querylog"
+)
+
+// notify acknowledges a NOTIFY (RFC 1996) for a secondary zone and has the
+// zone checked against its primary right away
+func (server *UDPServer) notify(req *Request) error {
+	if len(req.Questions) != 1 || req.Questions[0].Type != _type.TypeSOA {
+		return server.HandleError(req, _type.RCodeFormErr, querylog.SourceNone)
+	}
+	sec := server.secondaries[dns.CanonicalName(req.Questions[0].Name.String)]
+	if sec == nil {
+		return server.HandleError(req, _type.RCodeNotAuth, querylog.SourceNone)
+	}
+	if !sec.Notify(req.ClientAddr.Addr(), req.tsig.Key()) {
+		metrics.DeniedQueries.WithLabelValues("refuse").Inc()
+		return server.HandleError(req, _type.RCodeRefused, querylog.SourceACL)
+	}
+
+	req.Header.QueryResponse = true
+	req.Header.AuthoritativeAnswer = true
+	req.Header.ResponseCode = uint8(_type.RCodeNoError)
+	resp := &dns.Message{
+		Header:    req.Header,
+		Questions: req.Questions,
+	}
+	msg, err := resp.Pack(req.limit)
+	if err != nil {
+		return err
+	}
+	return server.writeResponse(req, msg, []resolution{{source: querylog.SourceNone}})
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"com.sentry.dev/app/dns"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"sync"
+)
+
+// Policies ordering the addresses of a name in responses
+const (
+	orderRoundRobin = "round_robin"
+	orderRandom     = "random"
+	orderFixed      = "fixed"
+)
+
+// maxRotations bounds the names whose rotation is remembered
+const maxRotations = 10000
+
+// answerOrder spreads clients over the addresses of a name by changing
+// their order from one response to the next
+type answerOrder struct {
+	policy string
+	mu     sync.Mutex
+	// next is the offset of the next rotation of each name and type
+	next map[string]int
+}
+
+func (server *UDPServer) configAnswerOrder() {
+	order, err := newAnswerOrder(server.Config.Server.AnswerOrder)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.answerOrder = order
+}
+
+func newAnswerOrder(policy string) (*answerOrder, error) {
+	switch policy {
+	case orderRoundRobin, orderRandom, orderFixed:
+		return &answerOrder{policy: policy, next: make(map[string]int)}, nil
+	}
+	return nil, fmt.Errorf("unknown answer order %q", policy)
+}
+
+// apply orders the address records answering question in place: rotated
+// by one more on each response, shuffled, or as configured
+func (o *answerOrder) apply(question *dns.Question, records []*dns.Record) {
+	if len(records) < 2 {
+		return
+	}
+	switch o.policy {
+	case orderRoundRobin:
+		key := dns.CanonicalName(question.Name.String) + "|" + question.Type.String()
+		o.mu.Lock()
+		if len(o.next) >= maxRotations {
+			clear(o.next)
+		}
+		offset := o.next[key] % len(records)
+		o.next[key] = offset + 1
+		o.mu.Unlock()
+		copy(records, slices.Concat(records[offset:], records[:offset]))
+	case orderRandom:
+		rand.Shuffle(len(records), func(i, j int) { records[i], records[j] = records[j], records[i] })
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"net"
+	"slices"
+	"testing"
+)
+
+func aRecords(name string, ips ...string) []*dns.Record {
+	var records []*dns.Record
+	for _, ip := range ips {
+		records = append(records, &dns.Record{Name: name, Type: _type.TypeA, Class: _type.ClassIN, TTL: 300, Data: &dns.A{IP: net.ParseIP(ip).To4()}})
+	}
+	return records
+}
+
+func recordIPs(records []*dns.Record) []string {
+	var ips []string
+	for _, r := range records {
+		ips = append(ips, r.Data.(*dns.A).IP.String())
+	}
+	return ips
+}
+
+func TestAnswerOrderRoundRobin(t *testing.T) {
+	order, err := newAnswerOrder(orderRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	www := &dns.Question{Name: &dns.Addr{String: "www.example"}, Type: _type.TypeA, Class: _type.ClassIN}
+	for i, want := range [][]string{
+		{"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+		{"192.0.2.2", "192.0.2.3", "192.0.2.1"},
+		{"192.0.2.3", "192.0.2.1", "192.0.2.2"},
+		{"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+	} {
+		records := aRecords("www.example", "192.0.2.1", "192.0.2.2", "192.0.2.3")
+		order.apply(www, records)
+		if got := recordIPs(records); !slices.Equal(got, want) {
+			t.Errorf("response %d: %v, want %v", i, got, want)
+		}
+	}
+
+	// Names rotate on their own, whatever their case, and a set that
+	// shrank is still rotated within its length
+	other := &dns.Question{Name: &dns.Addr{String: "Other.Example."}, Type: _type.TypeA, Class: _type.ClassIN}
+	records := aRecords("other.example", "192.0.2.1", "192.0.2.2")
+	order.apply(other, records)
+	if got := recordIPs(records); !slices.Equal(got, []string{"192.0.2.1", "192.0.2.2"}) {
+		t.Errorf("first response for another name: %v", got)
+	}
+	records = aRecords("www.example", "192.0.2.1", "192.0.2.2")
+	order.apply(www, records)
+	if got := recordIPs(records); !slices.Equal(got, []string{"192.0.2.2", "192.0.2.1"}) {
+		t.Errorf("shrunk set: %v", got)
+	}
+}
+
+func TestAnswerOrderPolicies(t *testing.T) {
+	question := &dns.Question{Name: &dns.Addr{String: "www.example"}, Type: _type.TypeA, Class: _type.ClassIN}
+	ips := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}
+	fixed, _ := newAnswerOrder(orderFixed)
+	random, _ := newAnswerOrder(orderRandom)
+	for range 3 {
+		records := aRecords("www.example", ips...)
+		fixed.apply(question, records)
+		if got := recordIPs(records); !slices.Equal(got, ips) {
+			t.Errorf("fixed order: %v", got)
+		}
+		records = aRecords("www.example", ips...)
+		random.apply(question, records)
+		if got := recordIPs(records); !slices.Equal(slices.Sorted(slices.Values(got)), ips) {
+			t.Errorf("random order lost addresses: %v", got)
+		}
+	}
+	if _, err := newAnswerOrder("weighted"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"com.sentry.dev/app/dns"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/quic-go/quic-go"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Error codes of DNS over QUIC (RFC 9250 4.3)
+const (
+	doqNoError          = 0x0
+	doqInternalError    = 0x1
+	doqProtocolError    = 0x2
+	doqRequestCancelled = 0x3
+)
+
+// doqMaxStreams bounds the queries a client may have in flight on one
+// connection
+const doqMaxStreams = 100
+
+// quicListener accepts DNS over QUIC (RFC 9250): one query per
+// bidirectional stream, answered on the same stream before it is closed
+type quicListener struct {
+	listener *quic.Listener
+	mu       sync.Mutex
+	conns    map[*quic.Conn]struct{}
+	closed   bool
+	connGr   sync.WaitGroup
+}
+
+func (server *UDPServer) configQUIC() {
+	if !server.Config.DoQ.Enabled {
+		return
+	}
+	addr := &net.UDPAddr{
+		Port: server.Config.DoQ.Port,
+		IP:   net.IPv4zero,
+	}
+	listener, err := quic.ListenAddr(addr.String(), server.tlsConfig("doq"), &quic.Config{
+		MaxIdleTimeout:        server.Config.Server.TCPIdleTimeoutDuration(),
+		MaxIncomingStreams:    doqMaxStreams,
+		MaxIncomingUniStreams: -1,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.quic = &quicListener{
+		listener: listener,
+		conns:    make(map[*quic.Conn]struct{}),
+	}
+	server.eventLoopGr.Add(1)
+	go server.serveQUIC()
+}
+
+func (server *UDPServer) serveQUIC() {
+	defer server.eventLoopGr.Done()
+	for {
+		conn, err := server.quic.listener.Accept(context.Background())
+		if err != nil {
+			if !errors.Is(err, quic.ErrServerClosed) {
+				log.Println("accept quic:", err)
+			}
+			return
+		}
+		if !server.quic.track(conn) {
+			conn.CloseWithError(doqNoError, "")
+			return
+		}
+		go server.serveQUICConn(conn)
+	}
+}
+
+// serveQUICConn accepts the streams of one connection until it is closed
+func (server *UDPServer) serveQUICConn(conn *quic.Conn) {
+	defer server.quic.untrack(conn)
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		server.quic.connGr.Add(1)
+		go func() {
+			defer server.quic.connGr.Done()
+			server.serveQUICStream(conn, stream)
+		}()
+	}
+}
+
+// serveQUICStream reads the query of one stream and hands it to the
+// pipeline. Malformed queries are protocol errors closing the connection.
+func (server *UDPServer) serveQUICStream(conn *quic.Conn, stream *quic.Stream) {
+	idleTimeout := server.Config.Server.TCPIdleTimeoutDuration()
+	if err := stream.SetReadDeadline(time.Now().Add(idleTimeout)); err != nil {
+		stream.CancelRead(doqInternalError)
+		stream.CancelWrite(doqInternalError)
+		return
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		stream.CancelRead(doqRequestCancelled)
+		stream.CancelWrite(doqRequestCancelled)
+		return
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		stream.CancelRead(doqRequestCancelled)
+		stream.CancelWrite(doqRequestCancelled)
+		return
+	}
+	// The client ends the stream after its query
+	if extra, err := io.ReadAll(io.LimitReader(stream, 1)); err != nil || len(extra) > 0 {
+		conn.CloseWithError(doqProtocolError, "stream carries more than one query")
+		return
+	}
+
+	clientAddr := conn.RemoteAddr().(*net.UDPAddr).AddrPort()
+	localAddr := conn.LocalAddr().(*net.UDPAddr).AddrPort()
+	receivedAt := time.Now()
+	server.tap.ClientQuery(clientAddr, localAddr, TransportQUIC, buf, receivedAt)
+	msg, err := dns.UnpackMessage(buf)
+	if err != nil {
+		queryErrors.Println("malformed query from", clientAddr.Addr().String()+":", err)
+		conn.CloseWithError(doqProtocolError, "malformed query")
+		return
+	}
+	if msg.Header.ID != 0 {
+		conn.CloseWithError(doqProtocolError, "message ID must be 0")
+		return
+	}
+
+	answered := false
+	server.admit(&Request{
+		ClientAddr: clientAddr,
+		LocalAddr:  localAddr,
+		Transport:  TransportQUIC,
+		ReceivedAt: receivedAt,
+		Message:    msg,
+		wire:       buf,
+		reply: func(msg []byte) error {
+			if len(msg) > tcpMessageLimit {
+				return errors.New("message too large for QUIC")
+			}
+			out := make([]byte, 2+len(msg))
+			binary.BigEndian.PutUint16(out, uint16(len(msg)))
+			copy(out[2:], msg)
+			if err := stream.SetWriteDeadline(time.Now().Add(idleTimeout)); err != nil {
+				return err
+			}
+			answered = true
+			_, err := stream.Write(out)
+			return err
+		},
+		limit: tcpMessageLimit,
+		// Close the stream once answered, or reset it when the query
+		// was dropped
+		done: func() {
+			if answered {
+				stream.Close()
+			} else {
+				stream.CancelWrite(doqRequestCancelled)
+			}
+		},
+	})
+}
+
+// close stops accepting connections, closes the open ones and waits for
+// their streams to be read
+func (l *quicListener) close() {
+	if l == nil {
+		return
+	}
+	if err := l.listener.Close(); err != nil {
+		log.Println("Error closing quic server:", err)
+	}
+	l.mu.Lock()
+	l.closed = true
+	for conn := range l.conns {
+		conn.CloseWithError(doqNoError, "")
+	}
+	l.mu.Unlock()
+	l.connGr.Wait()
+}
+
+// track registers an open connection, unless the listener is closing
+func (l *quicListener) track(conn *quic.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	l.conns[conn] = struct{}{}
+	l.connGr.Add(1)
+	return true
+}
+
+func (l *quicListener) untrack(conn *quic.Conn) {
+	l.mu.Lock()
+	delete(l.conns, conn)
+	l.mu.Unlock()
+	l.connGr.Done()
+}
@@ -1,12 +1,71 @@
 package server
 
 import (
+	"com.sentry.dev/app/acl"
 	"com.sentry.dev/app/dns"
-	"net"
+	"com.sentry.dev/app/tsig"
+	"errors"
+	"net/netip"
+	"time"
+)
+
+const (
+	TransportUDP   = "udp"
+	TransportTCP   = "tcp"
+	TransportTLS   = "tls"
+	TransportHTTPS = "https"
+	TransportQUIC  = "quic"
+)
+
+// Reasons for dropping a request without a response
+var (
+	errRateLimited = errors.New("rate limited")
+	errDenied      = errors.New("denied by an ACL")
+	errQueueFull   = errors.New("event queue is full")
 )
 
 type Request struct {
-	ClientAddr *net.UDPAddr
-	Header     *dns.Header
-	Questions  []*dns.Question
+	ClientAddr netip.AddrPort
+	LocalAddr  netip.AddrPort
+	Transport  string
+	ReceivedAt time.Time
+	// ClientID names the device of the client, from the DoH path
+	ClientID string
+	*dns.Message
+
+	// Access granted to the client by the recursion and local data ACLs
+	Recursion acl.Action
+	LocalData acl.Action
+
+	// view holds the data the questions are answered from
+	view *view
+	// group filters the questions, none when nil
+	group *clientGroup
+
+	// wire is the request as received, for TSIG verification
+	wire []byte
+	// tsig signs the responses of a signed request
+	tsig *tsig.Session
+
+	// reply sends an encoded response back over the transport of the request
+	reply func(msg []byte) error
+	// limit is the size of the largest response the transport carries
+	limit int
+	// done, when set, is called once the request is answered or dropped
+	done func()
+	// dropped tells why the request was dropped, if it was
+	dropped error
+}
+
+// finish reports the end of the handling of req to its transport
+func (req *Request) finish() {
+	if req.done != nil {
+		req.done()
+	}
+}
+
+// streamed reports whether the transport of req carries several response
+// messages, as zone transfers need
+func (req *Request) streamed() bool {
+	return req.Transport == TransportTCP || req.Transport == TransportTLS || req.Transport == TransportQUIC
 }
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/metrics"
+	"com.sentry.dev/app/querylog"
+	"com.sentry.dev/app/resolver"
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// iterativeUpstream labels the metrics of the built-in resolver
+	iterativeUpstream = "iterative"
+	// resolveTimeout bounds the iterative resolution of one question
+	resolveTimeout = 10 * time.Second
+)
+
+func (server *UDPServer) configResolver() {
+	if !server.Config.Resolver.Enabled {
+		return
+	}
+	r, err := resolver.New(&server.Config.Resolver, server.tap)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.resolver = r
+}
+
+// resolveIteratively answers question with the built-in resolver. Answers
+// failing DNSSEC validation are SERVFAIL, unless the client disabled
+// checking to validate them itself.
+func (server *UDPServer) resolveIteratively(req *Request, question *dns.Question) resolution {
+	if question.Class != _type.ClassIN {
+		return resolution{rcode: _type.RCodeNotImp, source: querylog.SourceResolver}
+	}
+	ctx, cancel := context.WithTimeout(server.Context, resolveTimeout)
+	defer cancel()
+	start := time.Now()
+	res, err := server.resolver.Resolve(ctx, question.Name.String, question.Type)
+	metrics.UpstreamDuration.WithLabelValues(iterativeUpstream).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.UpstreamErrors.WithLabelValues(iterativeUpstream).Inc()
+		queryErrors.Println("resolve", dns.Fqdn(question.Name.String), err)
+		return resolution{rcode: _type.RCodeServFail, source: querylog.SourceResolver}
+	}
+	if res.Bogus && req.Header.Reserved&dns.FlagCD == 0 {
+		queryErrors.Println("resolve", dns.Fqdn(question.Name.String), "failed DNSSEC validation")
+		return resolution{rcode: _type.RCodeServFail, source: querylog.SourceResolver}
+	}
+	return resolution{
+		answers:   res.Answer,
+		authority: res.Authority,
+		rcode:     res.RCode,
+		authentic: res.Secure,
+		source:    querylog.SourceResolver,
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bufio"
+	"com.sentry.dev/app/dns"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// tcpMessageLimit is the largest message the two-byte length prefix of DNS
+// over TCP can frame
+const tcpMessageLimit = 65535
+
+// tcpListener accepts DNS over TCP (RFC 7766) on the port of the UDP server,
+// for clients retrying truncated answers and for zone transfers, or over
+// TLS (RFC 7858). Both carry the same length-prefixed messages.
+type tcpListener struct {
+	listener  net.Listener
+	transport string
+	mu        sync.Mutex
+	conns     map[net.Conn]struct{}
+	closed    bool
+	connGr    sync.WaitGroup
+}
+
+func newTCPListener(listener net.Listener, transport string) *tcpListener {
+	return &tcpListener{
+		listener:  listener,
+		transport: transport,
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+func (server *UDPServer) configTCP() {
+	addr := &net.TCPAddr{
+		Port: server.Config.Server.Port,
+		IP:   net.IPv4zero,
+	}
+	listener, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.tcp = newTCPListener(listener, TransportTCP)
+}
+
+func (server *UDPServer) serveTCP(l *tcpListener) {
+	defer server.eventLoopGr.Done()
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Println("accept "+l.transport+":", err)
+			continue
+		}
+		if !l.track(conn) {
+			conn.Close()
+			return
+		}
+		go server.serveConn(l, conn)
+	}
+}
+
+// serveConn reads the queries of one connection until the client closes it
+// or stays idle for too long. Queries are handled concurrently, so answers
+// may come back out of order.
+func (server *UDPServer) serveConn(l *tcpListener, conn net.Conn) {
+	defer l.untrack(conn)
+	defer conn.Close()
+
+	idleTimeout := server.Config.Server.TCPIdleTimeoutDuration()
+	// Also bounds the TLS handshake, run by the first read
+	if err := conn.SetDeadline(time.Now().Add(idleTimeout)); err != nil {
+		return
+	}
+	clientAddr := conn.RemoteAddr().(*net.TCPAddr).AddrPort()
+	localAddr := conn.LocalAddr().(*net.TCPAddr).AddrPort()
+	var writeMu sync.Mutex
+	reply := func(msg []byte) error {
+		if len(msg) > tcpMessageLimit {
+			return errors.New("message too large for TCP")
+		}
+		buf := make([]byte, 2+len(msg))
+		binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+		copy(buf[2:], msg)
+
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := conn.SetWriteDeadline(time.Now().Add(idleTimeout)); err != nil {
+			return err
+		}
+		_, err := conn.Write(buf)
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(idleTimeout)); err != nil {
+			return
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return
+		}
+		receivedAt := time.Now()
+		server.tap.ClientQuery(clientAddr, localAddr, l.transport, buf, receivedAt)
+		msg, err := dns.UnpackMessage(buf)
+		if err != nil {
+			queryErrors.Println("malformed query from", clientAddr.Addr().String()+":", err)
+			return
+		}
+		server.admit(&Request{
+			ClientAddr: clientAddr,
+			LocalAddr:  localAddr,
+			Transport:  l.transport,
+			ReceivedAt: receivedAt,
+			Message:    msg,
+			wire:       buf,
+			reply:      reply,
+			limit:      tcpMessageLimit,
+		})
+	}
+}
+
+// close stops accepting connections, closes the open ones and waits for
+// their readers to return
+func (l *tcpListener) close() {
+	if l == nil {
+		return
+	}
+	if err := l.listener.Close(); err != nil {
+		log.Println("Error closing "+l.transport+" server:", err)
+	}
+	l.mu.Lock()
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+	l.connGr.Wait()
+}
+
+// track registers an open connection, unless the listener is closing
+func (l *tcpListener) track(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	l.conns[conn] = struct{}{}
+	l.connGr.Add(1)
+	return true
+}
+
+func (l *tcpListener) untrack(conn net.Conn) {
+	l.mu.Lock()
+	delete(l.conns, conn)
+	l.mu.Unlock()
+	l.connGr.Done()
+}
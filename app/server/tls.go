@@ -0,0 +1,38 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+)
+
+// tlsConfig loads the certificate of the encrypted listeners. TLS 1.2 is
+// the oldest version accepted; session tickets let clients resume their
+// sessions without a full handshake.
+func (server *UDPServer) tlsConfig(nextProtos ...string) *tls.Config {
+	cert, err := tls.LoadX509KeyPair(server.Config.TLS.CertFile, server.Config.TLS.KeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   nextProtos,
+	}
+}
+
+// configTLS listens for DNS over TLS (RFC 7858), served like DNS over TCP
+func (server *UDPServer) configTLS() {
+	if !server.Config.DoT.Enabled {
+		return
+	}
+	addr := &net.TCPAddr{
+		Port: server.Config.DoT.Port,
+		IP:   net.IPv4zero,
+	}
+	listener, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.tls = newTCPListener(tls.NewListener(listener, server.tlsConfig("dot")), TransportTLS)
+}
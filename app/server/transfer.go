@@ -0,0 +1,93 @@
+package server
+
+import (
+	"com.sentry.dev/app/acl"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/metrics"
+	"com.sentry.dev/app/querylog"
+	"com.sentry.dev/app/zone"
+)
+
+// isTransfer reports whether req asks for a zone transfer
+func isTransfer(req *Request) bool {
+	if len(req.Questions) != 1 {
+		return false
+	}
+	qtype := req.Questions[0].Type
+	return qtype == _type.TypeAXFR || qtype == _type.TypeIXFR
+}
+
+// transfer answers an AXFR (RFC 5936) or IXFR (RFC 1995) request. The zone
+// is streamed over as many messages as needed; IXFR falls back to the whole
+// zone when the journal does not reach back to the serial of the client.
+func (server *UDPServer) transfer(req *Request) error {
+	question := req.Questions[0]
+	z := server.zones.Get(question.Name.String)
+	if z == nil {
+		return server.HandleError(req, _type.RCodeNotAuth, querylog.SourceNone)
+	}
+	if !server.transferAccess[z.Origin].allows(req) {
+		metrics.DeniedQueries.WithLabelValues("refuse").Inc()
+		return server.HandleError(req, _type.RCodeRefused, querylog.SourceACL)
+	}
+
+	var records []*dns.Record
+	if question.Type == _type.TypeIXFR {
+		if len(req.Authority) != 1 || req.Authority[0].Type != _type.TypeSOA {
+			return server.HandleError(req, _type.RCodeFormErr, querylog.SourceNone)
+		}
+		records = incrementalTransfer(z, req.Authority[0].Data.(*dns.SOA).Serial, req.streamed())
+	} else if !req.streamed() {
+		return server.HandleError(req, _type.RCodeFormErr, querylog.SourceNone)
+	}
+	if records == nil {
+		records = z.Records()
+		records = append(records, records[0])
+	}
+
+	req.Header.QueryResponse = true
+	req.Header.AuthoritativeAnswer = true
+	req.Header.RecursionAvailable = req.Recursion == acl.Allow
+	req.Header.ResponseCode = uint8(_type.RCodeNoError)
+	resp := &dns.Message{
+		Header:    req.Header,
+		Questions: req.Questions,
+	}
+	messages, err := resp.PackStream(records, req.limit)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if err = server.send(req, msg); err != nil {
+			return err
+		}
+	}
+	server.recordQueries(req, []resolution{{
+		answers:       records,
+		authoritative: true,
+		source:        querylog.SourceLocal,
+	}})
+	return nil
+}
+
+// incrementalTransfer returns the IXFR answer for a client at serial: the
+// current SOA alone when it is up to date or asked over a transport with a
+// single response message, the journal deltas framed by the current SOA, or
+// nil when only a full transfer helps
+func incrementalTransfer(z *zone.Zone, serial uint32, streamed bool) []*dns.Record {
+	soa := z.SOA()
+	if !zone.SerialLess(serial, soa.Data.(*dns.SOA).Serial) || !streamed {
+		return []*dns.Record{soa}
+	}
+	deltas, ok := z.Changes(serial)
+	if !ok || len(deltas) == 0 {
+		return nil
+	}
+	last := deltas[len(deltas)-1].To
+	records := []*dns.Record{last}
+	for _, d := range deltas {
+		records = append(records, d.Records()...)
+	}
+	return append(records, last)
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/zone"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newJournaledZone loads a zone at serial 4294967294 and takes it over the
+// wraparound to serial 0 in two reloads, both kept in its journal
+func newJournaledZone(t *testing.T) *zone.Zone {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "example.zone")
+	var z *zone.Zone
+	for _, version := range []struct {
+		serial uint32
+		www    string
+	}{{4294967294, "192.0.2.2"}, {4294967295, "192.0.2.3"}, {0, "192.0.2.4"}} {
+		err := os.WriteFile(path, []byte(fmt.Sprintf(`
+$TTL 300
+@    SOA  ns hostmaster %d 3600 600 86400 60
+     NS   ns
+ns   A    192.0.2.1
+www  A    %s
+`, version.serial, version.www)), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if z == nil {
+			z, err = zone.Load(path, "example.", filepath.Join(dir, "example.jnl"))
+		} else {
+			z, err = z.Reload(path)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return z
+}
+
+// soaSerials returns the serials of the SOA records among records, in order
+func soaSerials(records []*dns.Record) []uint32 {
+	var serials []uint32
+	for _, r := range records {
+		if soa, ok := r.Data.(*dns.SOA); ok {
+			serials = append(serials, soa.Serial)
+		}
+	}
+	return serials
+}
+
+func TestIncrementalTransfer(t *testing.T) {
+	z := newJournaledZone(t)
+	for _, c := range []struct {
+		name     string
+		serial   uint32
+		streamed bool
+		// serials of the SOA records of the answer, nil for a full transfer
+		want    []uint32
+		records int
+	}{
+		{"two changes behind", 4294967294, true, []uint32{0, 4294967294, 4294967295, 4294967295, 0, 0}, 10},
+		{"one change behind", 4294967295, true, []uint32{0, 4294967295, 0, 0}, 6},
+		{"up to date", 0, true, []uint32{0}, 1},
+		// a client ahead of the zone is told its serial
+		{"ahead", 5, true, []uint32{0}, 1},
+		{"over UDP", 4294967294, false, []uint32{0}, 1},
+		{"older than the journal", 4294967000, true, nil, 0},
+	} {
+		records := incrementalTransfer(z, c.serial, c.streamed)
+		if got := soaSerials(records); !slices.Equal(got, c.want) || len(records) != c.records {
+			t.Errorf("%s: SOA serials %v in %d records, want %v in %d", c.name, got, len(records), c.want, c.records)
+		}
+	}
+	// The changes are the records of www, removed and added
+	records := incrementalTransfer(z, 4294967295, true)
+	if removed, added := records[2].Data.(*dns.A).IP.String(), records[4].Data.(*dns.A).IP.String(); removed != "192.0.2.3" || added != "192.0.2.4" {
+		t.Errorf("change removes %s and adds %s", removed, added)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	server := &UDPServer{Config: &config.Config{}, zones: zone.NewStore()}
+	server.transferAccess = map[string]*zoneAccess{"example": server.newZoneAccess([]string{"192.0.2.0/24"}, nil)}
+	server.zones.Put(newJournaledZone(t))
+
+	for _, c := range []struct {
+		name      string
+		client    string
+		transport string
+		qname     string
+		qtype     _type.RecordType
+		serial    uint32
+		rcode     _type.ResponseCode
+		// serials of the SOA records of the answer
+		want []uint32
+	}{
+		{"AXFR", "192.0.2.53", TransportTCP, "example", _type.TypeAXFR, 0, _type.RCodeNoError, []uint32{0, 0}},
+		{"IXFR", "192.0.2.53", TransportTCP, "Example.", _type.TypeIXFR, 4294967295, _type.RCodeNoError, []uint32{0, 4294967295, 0, 0}},
+		// the journal does not reach back to the client, the whole zone
+		// is sent
+		{"IXFR from an old serial", "192.0.2.53", TransportTLS, "example", _type.TypeIXFR, 4294967000, _type.RCodeNoError, []uint32{0, 0}},
+		{"IXFR over UDP", "192.0.2.53", TransportUDP, "example", _type.TypeIXFR, 4294967295, _type.RCodeNoError, []uint32{0}},
+		{"AXFR over UDP", "192.0.2.53", TransportUDP, "example", _type.TypeAXFR, 0, _type.RCodeFormErr, nil},
+		{"client outside of the ACL", "198.51.100.53", TransportTCP, "example", _type.TypeAXFR, 0, _type.RCodeRefused, nil},
+		{"zone not served", "192.0.2.53", TransportTCP, "example.net", _type.TypeAXFR, 0, _type.RCodeNotAuth, nil},
+	} {
+		req := &Request{
+			ClientAddr: netip.AddrPortFrom(netip.MustParseAddr(c.client), 53000),
+			Transport:  c.transport,
+			Message: &dns.Message{
+				Header:    &dns.Header{ID: 1},
+				Questions: []*dns.Question{{Name: &dns.Addr{String: c.qname}, Type: c.qtype, Class: _type.ClassIN}},
+			},
+			limit: tcpMessageLimit,
+		}
+		if c.qtype == _type.TypeIXFR {
+			req.Authority = []*dns.Record{{Name: "example", Type: _type.TypeSOA, Class: _type.ClassIN, Data: &dns.SOA{Serial: c.serial}}}
+		}
+		var answers []*dns.Record
+		var rcode _type.ResponseCode
+		req.reply = func(msg []byte) error {
+			resp, err := dns.UnpackMessage(msg)
+			if err != nil {
+				return err
+			}
+			rcode = _type.ResponseCode(resp.Header.ResponseCode)
+			answers = append(answers, resp.Answers...)
+			return nil
+		}
+		if err := server.transfer(req); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := soaSerials(answers); rcode != c.rcode || !slices.Equal(got, c.want) {
+			t.Errorf("%s: %s with SOA serials %v, want %s with %v", c.name, rcode, got, c.rcode, c.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/tsig"
+	"log"
+	"time"
+)
+
+func (server *UDPServer) configTSIG() {
+	keys, err := tsig.NewKeyring(server.Config.TSIGKeys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.tsigKeys = keys
+}
+
+// authenticate verifies the TSIG record of a signed request (RFC 8945).
+// Every response to it is signed from then on, errors included, and the
+// room taken by the signature is kept free in the responses.
+func (server *UDPServer) authenticate(req *Request) _type.ResponseCode {
+	session, err := server.tsigKeys.Verify(req.wire, time.Now())
+	if err != nil {
+		queryErrors.Println("TSIG from", req.ClientAddr.Addr().String()+":", err)
+		return _type.RCodeFormErr
+	}
+	if session == nil {
+		return _type.RCodeNoError
+	}
+	req.tsig = session
+	req.limit -= session.Overhead()
+	if session.Error != _type.RCodeNoError {
+		queryErrors.Println("TSIG", session.Error, "for key", session.Name(), "from", req.ClientAddr.Addr())
+		return _type.RCodeNotAuth
+	}
+	return _type.RCodeNoError
+}
@@ -1,12 +1,29 @@
 package server
 
 import (
+	"com.sentry.dev/app/acl"
 	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	"com.sentry.dev/app/health"
+	"com.sentry.dev/app/metrics"
+	"com.sentry.dev/app/querylog"
+	"com.sentry.dev/app/ratelimit"
+	"com.sentry.dev/app/resolver"
+	"com.sentry.dev/app/secondary"
+	"com.sentry.dev/app/tap"
+	"com.sentry.dev/app/tsig"
+	"com.sentry.dev/app/upstream"
 	"com.sentry.dev/app/utils"
+	"com.sentry.dev/app/zone"
 	"context"
 	"github.com/redis/go-redis/v9"
 	"log"
 	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,24 +33,96 @@ type UDPServer struct {
 	Context    context.Context
 	CancelFunc context.CancelFunc
 
-	conn        *net.UDPConn
-	cache       *redis.Client
+	conn     *net.UDPConn
+	cache    *redis.Client
+	zones    *zone.Store
+	tcp      *tcpListener
+	tls      *tcpListener
+	doh      *http.Server
+	quic     *quicListener
+	queryLog *querylog.Logger
+	tap      *tap.Tapper
+	// upstreams are tried in order, the system resolver is used without any
+	upstreams []upstream.Upstream
+	// forwarders holds the upstreams of domains resolved by their own
+	// servers, by domain name
+	forwarders map[string][]upstream.Upstream
+	// resolver resolves iteratively from the root servers, in place of
+	// the upstreams, when enabled
+	resolver *resolver.Resolver
+	// flattened holds the known_hosts aliases answered with the records of
+	// the name they lead to
+	flattened map[string]bool
+	// answerOrder orders the addresses of known hosts and upstream answers
+	answerOrder *answerOrder
+	// health withdraws the failing addresses of known hosts
+	health *health.Checker
+	// views answer the clients they match with their own data, in order,
+	// and defaultView answers everyone else
+	views       []*view
+	defaultView *view
+	// groups filter the queries of their clients, in order, and
+	// groupsByDevice holds them by MAC address and identifier
+	groups         []*clientGroup
+	groupsByDevice map[string]*clientGroup
+
+	queryLimiter    *ratelimit.QueryLimiter
+	responseLimiter *ratelimit.ResponseLimiter
+	queryACL        *acl.List
+	recursionACL    *acl.List
+	localDataACL    *acl.List
+	clientSubnetACL *acl.List
+	clientIDACL     *acl.List
+	tsigKeys        tsig.Keyring
+	transferAccess  map[string]*zoneAccess
+	updateAccess    map[string]*zoneAccess
+	secondaries     map[string]*secondary.Zone
+	// zonesMu serializes changes to the zones served by the primary
+	zonesMu sync.Mutex
+
 	eventQueue  chan *Request
 	workers     chan struct{}
 	eventLoopGr sync.WaitGroup
+	workerGr    sync.WaitGroup
 }
 
 // Start the UDP DNS server
 func (server *UDPServer) Start() {
 	server.configConnection()
+	server.configTCP()
+	server.configTLS()
+	server.configDoH()
+	server.configQUIC()
 	server.configRedis()
+	server.configAliases()
+	server.configAnswerOrder()
+	server.configTSIG()
+	server.configZones()
+	server.configQueryLog()
+	server.configTap()
+	server.configUpstreams()
+	server.configResolver()
+	server.configViews()
+	server.configHealthChecks()
+	server.configGroups()
+	server.queryLimiter = ratelimit.NewQueryLimiter(&server.Config.RateLimit)
+	server.responseLimiter = ratelimit.NewResponseLimiter(&server.Config.RRL)
+	server.configACL()
 
 	server.eventQueue = make(chan *Request, server.Config.Server.EventQueueSize)
 	server.workers = make(chan struct{}, server.Config.Server.Workers)
+	metrics.WatchEventQueue(func() int { return len(server.eventQueue) }, cap(server.eventQueue))
+	metrics.WatchWorkers(func() int { return len(server.workers) }, cap(server.workers))
+	metrics.WatchCacheEvictions(server.cacheEvictions)
 
-	server.eventLoopGr.Add(2)
+	server.eventLoopGr.Add(3)
 	go server.dispatchRequests()
 	go server.processRequests()
+	go server.serveTCP(server.tcp)
+	if server.tls != nil {
+		server.eventLoopGr.Add(1)
+		go server.serveTCP(server.tls)
+	}
 }
 
 func (server *UDPServer) configConnection() {
@@ -58,11 +147,64 @@ func (server *UDPServer) configRedis() {
 		DB:       rDB,
 	})
 
-	blackList := config.GetBlackList(server.Config.Server.BlacklistFilePath)
-	server.cache.SAdd(server.Context, utils.BlackList, blackList)
+	server.loadBlackList(server.Config.Server.BlacklistFilePath, utils.BlackList)
+	server.loadKnownHosts(server.Config.Server.KnownHostsFilePath, utils.KnownHost, utils.ReverseHost)
+}
+
+// loadBlackList adds the domains of a blacklist file to the set at key
+func (server *UDPServer) loadBlackList(filePath, key string) {
+	blackList := config.GetBlackList(filePath)
+	server.cache.SAdd(server.Context, key, blackList)
+}
+
+// loadKnownHosts stores the names of a known_hosts file in the hash at key,
+// and the reverse names of their addresses in the hash at reverseKey
+func (server *UDPServer) loadKnownHosts(filePath, key, reverseKey string) {
+	knownHosts := config.GetKnownHosts(filePath)
+	values := make(map[string]string, len(knownHosts))
+	for name, hosts := range knownHosts {
+		values[name] = strings.Join(hosts, " ")
+	}
+	server.cache.HSet(server.Context, key, values)
+	if reverseHosts := reverseHosts(knownHosts); len(reverseHosts) > 0 {
+		server.cache.HSet(server.Context, reverseKey, reverseHosts)
+	}
+}
+
+// reverseHosts maps the reverse names of the addresses of known_hosts to
+// the names holding them, separated by spaces, for PTR answers
+func reverseHosts(knownHosts map[string][]string) map[string]string {
+	names := make(map[string][]string)
+	for name, values := range knownHosts {
+		for _, value := range values {
+			if ip := net.ParseIP(value); ip != nil {
+				reverse := dns.ReverseName(ip)
+				names[reverse] = append(names[reverse], dns.CanonicalName(name))
+			}
+		}
+	}
+	reverseHosts := make(map[string]string, len(names))
+	for reverse, hosts := range names {
+		slices.Sort(hosts)
+		reverseHosts[reverse] = strings.Join(hosts, " ")
+	}
+	return reverseHosts
+}
+
+func (server *UDPServer) configQueryLog() {
+	queryLog, err := querylog.New(&server.Config.QueryLog)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.queryLog = queryLog
+}
 
-	knownHosts := config.GetKnownHosts(server.Config.Server.KnownHostsFilePath)
-	server.cache.HSet(server.Context, utils.KnownHost, knownHosts)
+func (server *UDPServer) configTap() {
+	tapper, err := tap.New(&server.Config.Dnstap)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.tap = tapper
 }
 
 // Stop the UDP DNS server gracefully
@@ -71,11 +213,18 @@ func (server *UDPServer) Stop() {
 	if err := server.conn.Close(); err != nil {
 		log.Println("Error closing UDP server:", err)
 	}
+	server.tcp.close()
+	server.tls.close()
+	server.closeDoH()
+	server.quic.close()
 	close(server.eventQueue)
 	if err := server.cache.Close(); err != nil {
 		log.Println("Error closing memcached client:", err)
 	}
 	server.eventLoopGr.Wait()
+	server.workerGr.Wait()
+	server.queryLog.Close()
+	server.tap.Close()
 }
 
 func (server *UDPServer) dispatchRequests() {
@@ -89,15 +238,34 @@ func (server *UDPServer) dispatchRequests() {
 			if err != nil {
 				continue
 			}
-			select {
-			case server.eventQueue <- req:
-			case <-time.After(server.Config.Server.EventQueueTimeoutDuration()):
-				log.Println("event queue is full")
-			}
+			server.admit(req)
 		}
 	}
 }
 
+// admit applies the rate limits and the access-control lists to a request
+// and queues it for the workers
+func (server *UDPServer) admit(req *Request) {
+	if ok, reason := server.queryLimiter.Allow(req.ClientAddr.Addr()); !ok {
+		metrics.RateLimitedQueries.WithLabelValues(reason).Inc()
+		req.dropped = errRateLimited
+		req.finish()
+		return
+	}
+	if !server.checkAccess(req) {
+		req.finish()
+		return
+	}
+	select {
+	case server.eventQueue <- req:
+	case <-time.After(server.Config.Server.EventQueueTimeoutDuration()):
+		metrics.EventQueueDropped.Inc()
+		log.Println("event queue is full")
+		req.dropped = errQueueFull
+		req.finish()
+	}
+}
+
 func (server *UDPServer) processRequests() {
 	defer server.eventLoopGr.Done()
 	for {
@@ -110,23 +278,98 @@ func (server *UDPServer) processRequests() {
 			}
 			select {
 			case server.workers <- struct{}{}:
-				go func(req *Request) {
-					defer func() { <-server.workers }()
-					if err := server.HandleResponse(req); err != nil {
-						log.Println("handle response:", err)
-					}
-				}(req)
+			default:
+				metrics.WorkersSaturated.Inc()
+				server.workers <- struct{}{}
 			}
+			server.workerGr.Add(1)
+			go func(req *Request) {
+				defer server.workerGr.Done()
+				defer func() { <-server.workers }()
+				defer req.finish()
+				if err := server.HandleResponse(req); err != nil {
+					log.Println("handle response:", err)
+				}
+			}(req)
 		}
 	}
 }
 
-func (server *UDPServer) lookUp(hostName string) (ip string, err error) {
-	ip, err = server.cache.HGet(server.Context, utils.KnownHost, hostName).Result()
+func (server *UDPServer) lookUp(key, hostName string) (ip string, err error) {
+	ip, err = server.cache.HGet(server.Context, key, hostName).Result()
 	return
 }
 
-func (server *UDPServer) isBlackListed(hostName string) (yes bool, err error) {
-	yes, err = server.cache.SIsMember(server.Context, utils.BlackList, hostName).Result()
+// lookUpReverse returns the known hosts holding the address of a reverse name
+func (server *UDPServer) lookUpReverse(key, reverseName string) ([]string, error) {
+	hosts, err := server.cache.HGet(server.Context, key, dns.CanonicalName(reverseName)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(hosts), nil
+}
+
+// parseAddrs returns the addresses a known host or a cache entry holds,
+// separated by spaces, nil when it holds something else
+func parseAddrs(value string) []net.IP {
+	var ips []net.IP
+	for _, field := range strings.Fields(value) {
+		ip := net.ParseIP(field)
+		if ip == nil {
+			return nil
+		}
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// lookUpCache returns the value of the first of fields cached at key
+func (server *UDPServer) lookUpCache(key string, fields []string) (string, error) {
+	values, err := server.cache.HMGet(server.Context, key, fields...).Result()
+	if err != nil {
+		return "", err
+	}
+	for _, value := range values {
+		if ip, ok := value.(string); ok {
+			return ip, nil
+		}
+	}
+	return "", redis.Nil
+}
+
+// cacheEvictions reads the expiry and eviction counters from Redis INFO
+func (server *UDPServer) cacheEvictions() map[string]float64 {
+	stats := make(map[string]float64)
+	info, err := server.cache.Info(server.Context, "stats").Result()
+	if err != nil {
+		return stats
+	}
+	for _, line := range strings.Split(info, "\r\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		var reason string
+		switch key {
+		case "expired_subkeys":
+			reason = "expired"
+		case "evicted_keys":
+			reason = "evicted"
+		default:
+			continue
+		}
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			stats[reason] = n
+		}
+	}
+	return stats
+}
+
+func (server *UDPServer) localAddr() netip.AddrPort {
+	return server.conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func (server *UDPServer) isBlackListed(key, hostName string) (yes bool, err error) {
+	yes, err = server.cache.SIsMember(server.Context, key, hostName).Result()
 	return
 }
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/metrics"
+	"com.sentry.dev/app/querylog"
+	"fmt"
+)
+
+// update applies a dynamic update (RFC 2136) to a zone served by this
+// primary. The zone section travels as the question, the prerequisites as
+// the answers and the updates as the authority records.
+func (server *UDPServer) update(req *Request) error {
+	if len(req.Questions) != 1 || req.Questions[0].Type != _type.TypeSOA {
+		return server.HandleError(req, _type.RCodeFormErr, querylog.SourceNone)
+	}
+	origin := dns.CanonicalName(req.Questions[0].Name.String)
+	if server.zones.Get(origin) == nil || server.secondaries[origin] != nil {
+		return server.HandleError(req, _type.RCodeNotAuth, querylog.SourceNone)
+	}
+	if !server.updateAccess[origin].allows(req) {
+		metrics.DeniedQueries.WithLabelValues("refuse").Inc()
+		return server.HandleError(req, _type.RCodeRefused, querylog.SourceACL)
+	}
+
+	server.zonesMu.Lock()
+	z := server.zones.Get(origin)
+	updated, rcode, err := z.Update(req.Answers, req.Authority)
+	if err != nil {
+		fmt.Println("Error updating zone", dns.Fqdn(origin)+":", err)
+	} else if updated != z {
+		server.zones.Put(updated)
+		fmt.Println("Updated zone", dns.Fqdn(origin), "serial", updated.SOA().Data.(*dns.SOA).Serial, "from", req.ClientAddr.Addr())
+	}
+	server.zonesMu.Unlock()
+	return server.HandleError(req, rcode, querylog.SourceUpdate)
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/metrics"
+	"com.sentry.dev/app/querylog"
+	"com.sentry.dev/app/upstream"
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// systemUpstream labels lookups delegated to the operating system resolver
+const systemUpstream = "system"
+
+// upstreamTimeout bounds a query forwarded to one upstream
+const upstreamTimeout = 5 * time.Second
+
+func (server *UDPServer) configUpstreams() {
+	server.upstreams = newUpstreams(server.Config.Upstreams)
+	server.forwarders = make(map[string][]upstream.Upstream)
+	for _, c := range server.Config.Forwarders {
+		domain := dns.CanonicalName(strings.TrimPrefix(c.Domain, "*."))
+		if _, ok := server.forwarders[domain]; ok {
+			log.Fatalf("forwarder for %q defined twice", c.Domain)
+		}
+		if len(c.Upstreams) == 0 {
+			log.Fatalf("forwarder for %q has no upstreams", c.Domain)
+		}
+		server.forwarders[domain] = newUpstreams(c.Upstreams)
+	}
+}
+
+func newUpstreams(configs []config.UpstreamConfig) []upstream.Upstream {
+	var upstreams []upstream.Upstream
+	for _, c := range configs {
+		u, err := upstream.New(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		upstreams = append(upstreams, u)
+	}
+	return upstreams
+}
+
+// findForwarders returns the upstreams of the longest forwarded domain
+// covering name, nil when there is none
+func (server *UDPServer) findForwarders(name string) []upstream.Upstream {
+	if len(server.forwarders) == 0 {
+		return nil
+	}
+	name = dns.CanonicalName(name)
+	for {
+		if upstreams, ok := server.forwarders[name]; ok {
+			return upstreams
+		}
+		if name == "" {
+			return nil
+		}
+		name = dns.Parent(name)
+	}
+}
+
+// forwardQuestion relays question to the upstreams of a forwarder and
+// answers with whatever the first one to succeed returns
+func (server *UDPServer) forwardQuestion(upstreams []upstream.Upstream, question *dns.Question, ecs *dns.ClientSubnet) resolution {
+	msg, err := server.exchange(upstreams, newUpstreamQuery(question.Name.String, question.Type, question.Class, ecs))
+	if err != nil {
+		return resolution{rcode: _type.RCodeServFail, source: querylog.SourceForward}
+	}
+	// The OPT record of the upstream is not the one of the response
+	additional := slices.DeleteFunc(msg.Additional, func(r *dns.Record) bool { return r.Type == _type.TypeOPT })
+	return resolution{
+		answers:    msg.Answers,
+		authority:  msg.Authority,
+		additional: additional,
+		rcode:      _type.ResponseCode(msg.Header.ResponseCode),
+		source:     querylog.SourceForward,
+	}
+}
+
+// lookUpUpstream resolves the addresses of hostName through upstreams, or
+// through the system resolver when there is none, for the clients of ecs
+// when set. Both A and AAAA are asked, as the system resolver does, and
+// the answer to qtype is returned for its rcode and authority, nil from
+// the system resolver. The scope is the prefix length of the subnet the
+// answer holds for, 0 for every client. The addresses of one type are
+// still returned with the error of the other.
+func (server *UDPServer) lookUpUpstream(upstreams []upstream.Upstream, hostName string, qtype _type.RecordType, ecs *dns.ClientSubnet) (ips []net.IP, scope int, answer *dns.Message, err error) {
+	if len(upstreams) == 0 {
+		ips, err = server.lookUpSystem(hostName)
+		return ips, 0, nil, err
+	}
+	qtypes := []_type.RecordType{_type.TypeA, _type.TypeAAAA}
+	msgs := make([]*dns.Message, len(qtypes))
+	errs := make([]error, len(qtypes))
+	var wg sync.WaitGroup
+	for i, qtype := range qtypes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msgs[i], errs[i] = server.exchange(upstreams, newUpstreamQuery(hostName, qtype, _type.ClassIN, ecs))
+		}()
+	}
+	wg.Wait()
+	for i, msg := range msgs {
+		if errs[i] != nil {
+			err = errs[i]
+			continue
+		}
+		if qtypes[i] == qtype {
+			answer = msg
+		}
+		if ecs != nil {
+			// the answer holds for the narrowest subnet of both
+			scope = max(scope, answerScope(ecs, dns.FindClientSubnet(dns.FindOPT(msg.Additional))))
+		}
+		for _, record := range msg.Answers {
+			switch data := record.Data.(type) {
+			case *dns.A:
+				ips = append(ips, data.IP)
+			case *dns.AAAA:
+				ips = append(ips, data.IP)
+			}
+		}
+	}
+	return ips, scope, answer, err
+}
+
+// answerScope returns the scope of an answer to a query sent with ecs.
+// Without an option the answer holds for every client (RFC 7871 7.3). One
+// for another subnet is kept for the subnet asked for only.
+func answerScope(ecs, answered *dns.ClientSubnet) int {
+	switch {
+	case answered == nil:
+		return 0
+	case answered.Prefix != ecs.Prefix:
+		return ecs.Prefix.Bits()
+	}
+	return answered.Scope
+}
+
+// lookUpSystem resolves through the resolver of the OS. Its messages are
+// not seen, so it is left out of dnstap.
+func (server *UDPServer) lookUpSystem(hostName string) (ips []net.IP, err error) {
+	start := time.Now()
+	ips, err = net.LookupIP(hostName)
+	metrics.UpstreamDuration.WithLabelValues(systemUpstream).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.UpstreamErrors.WithLabelValues(systemUpstream).Inc()
+	}
+	return
+}
+
+// newUpstreamQuery builds a recursive query for one question, carrying the
+// client subnet option of ecs when set
+func newUpstreamQuery(name string, qtype _type.RecordType, class _type.RecordClass, ecs *dns.ClientSubnet) *dns.Message {
+	query := &dns.Message{
+		Header: &dns.Header{ID: uint16(rand.Uint32()), RecursionDesired: true},
+		Questions: []*dns.Question{{
+			Name:  &dns.Addr{String: name},
+			Type:  qtype,
+			Class: class,
+		}},
+	}
+	if ecs != nil {
+		opt := dns.NewOPT(dns.EDNSPayload, false)
+		opt.Data = &dns.Unknown{Data: ecs.Pack()}
+		query.Additional = []*dns.Record{opt}
+	}
+	return query
+}
+
+// exchange sends query to upstreams in order and returns the first answer,
+// positive or NXDOMAIN
+func (server *UDPServer) exchange(upstreams []upstream.Upstream, query *dns.Message) (*dns.Message, error) {
+	packed, err := query.Pack(server.Config.UDP.PkgLimitRFC1035)
+	if err != nil {
+		return nil, err
+	}
+	var msg *dns.Message
+	for _, u := range upstreams {
+		if msg, err = server.exchangeWith(u, packed); err == nil {
+			return msg, nil
+		}
+		metrics.UpstreamErrors.WithLabelValues(u.String()).Inc()
+		queryErrors.Println("upstream", u, err)
+	}
+	return nil, err
+}
+
+func (server *UDPServer) exchangeWith(u upstream.Upstream, query []byte) (*dns.Message, error) {
+	ctx, cancel := context.WithTimeout(server.Context, upstreamTimeout)
+	defer cancel()
+	start := time.Now()
+	server.tap.ForwarderQuery(u.Addr(), u.Transport(), query, start)
+	resp, err := u.Exchange(ctx, query)
+	metrics.UpstreamDuration.WithLabelValues(u.String()).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+	server.tap.ForwarderResponse(u.Addr(), u.Transport(), resp, start, time.Now())
+
+	msg, err := dns.UnpackMessage(resp)
+	if err != nil {
+		return nil, err
+	}
+	switch rcode := _type.ResponseCode(msg.Header.ResponseCode); {
+	case !msg.Header.QueryResponse:
+		return nil, errors.New("upstream sent a query")
+	case rcode != _type.RCodeNoError && rcode != _type.RCodeNXDomain:
+		return nil, errors.New("upstream answered " + rcode.String())
+	}
+	return msg, nil
+}
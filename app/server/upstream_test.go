@@ -0,0 +1,158 @@
+package server
+
+import (
+	"com.sentry.dev/app/acl"
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/upstream"
+	"com.sentry.dev/app/zone"
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// zoneUpstream answers queries from a zone, as a recursive resolver would
+type zoneUpstream struct {
+	zone *zone.Zone
+}
+
+func (u *zoneUpstream) Exchange(_ context.Context, query []byte) ([]byte, error) {
+	msg, err := dns.UnpackMessage(query)
+	if err != nil {
+		return nil, err
+	}
+	res := u.zone.Lookup(msg.Questions[0].Name.String, msg.Questions[0].Type, false)
+	msg.Header.QueryResponse = true
+	msg.Header.ResponseCode = uint8(res.RCode)
+	msg.Answers, msg.Authority, msg.Additional = res.Answer, res.Authority, nil
+	return msg.Pack(65535)
+}
+
+func (u *zoneUpstream) Transport() string    { return upstream.TransportUDP }
+func (u *zoneUpstream) Addr() netip.AddrPort { return netip.AddrPort{} }
+func (u *zoneUpstream) String() string       { return "zone" }
+
+func newZoneUpstream(t *testing.T) upstream.Upstream {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "example.zone")
+	err := os.WriteFile(path, []byte(`
+$TTL 300
+@     SOA   ns hostmaster 1 3600 600 86400 60
+      NS    ns
+      MX    10 mail
+ns    A     192.0.2.1
+mail  A     192.0.2.2
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := zone.ParseFile(path, "example.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &zoneUpstream{zone: z}
+}
+
+func TestResolveThroughUpstreams(t *testing.T) {
+	// Redis is down: nothing is blocked, known or cached
+	cache := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer cache.Close()
+	server := &UDPServer{
+		Config: &config.Config{
+			UDP:    config.UDPConfig{PkgLimitRFC1035: 512},
+			Server: config.ServerConfig{CacheTTLSec: 300},
+		},
+		Context:     context.Background(),
+		cache:       cache,
+		answerOrder: &answerOrder{},
+	}
+	req := &Request{
+		Message:   &dns.Message{Header: &dns.Header{}},
+		Recursion: acl.Allow,
+		LocalData: acl.Refuse,
+		view:      &view{upstreams: []upstream.Upstream{newZoneUpstream(t)}},
+	}
+	for _, c := range []struct {
+		name   string
+		qtype  _type.RecordType
+		rcode  _type.ResponseCode
+		answer []_type.RecordType
+		auth   []_type.RecordType
+	}{
+		{"mail.example", _type.TypeA, _type.RCodeNoError, []_type.RecordType{_type.TypeA}, nil},
+		{"mail.example", _type.TypeAAAA, _type.RCodeNoError, nil, []_type.RecordType{_type.TypeSOA}},
+		{"missing.example", _type.TypeA, _type.RCodeNXDomain, nil, []_type.RecordType{_type.TypeSOA}},
+		{"example", _type.TypeMX, _type.RCodeNoError, []_type.RecordType{_type.TypeMX}, nil},
+		{"example", _type.TypeNS, _type.RCodeNoError, []_type.RecordType{_type.TypeNS}, nil},
+		{"missing.example", _type.TypeTXT, _type.RCodeNXDomain, nil, []_type.RecordType{_type.TypeSOA}},
+	} {
+		res := server.resolve(req, &dns.Question{Name: &dns.Addr{String: c.name}, Type: c.qtype, Class: _type.ClassIN})
+		if res.rcode != c.rcode || !recordTypes(res.answers, c.answer) || !recordTypes(res.authority, c.auth) {
+			t.Errorf("%s %s: rcode %s, answer %v, authority %v", c.name, c.qtype, res.rcode, res.answers, res.authority)
+		}
+	}
+}
+
+func recordTypes(records []*dns.Record, types []_type.RecordType) bool {
+	if len(records) != len(types) {
+		return false
+	}
+	for i, r := range records {
+		if r.Type != types[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFindForwarders(t *testing.T) {
+	corp := []upstream.Upstream{&zoneUpstream{}}
+	lab := []upstream.Upstream{&zoneUpstream{}}
+	root := []upstream.Upstream{&zoneUpstream{}}
+	server := &UDPServer{Config: &config.Config{Forwarders: []config.ForwarderConfig{
+		{Domain: "corp.example", Upstreams: []config.UpstreamConfig{{URL: "192.0.2.1"}}},
+		{Domain: "*.Lab.Corp.Example.", Upstreams: []config.UpstreamConfig{{URL: "192.0.2.2"}}},
+	}}}
+	server.configUpstreams()
+	for _, c := range []struct {
+		name string
+		want string
+	}{
+		{"corp.example", "192.0.2.1"},
+		{"host.corp.example", "192.0.2.1"},
+		{"HOST.Corp.Example.", "192.0.2.1"},
+		{"lab.corp.example", "192.0.2.2"},
+		{"host.lab.corp.example.", "192.0.2.2"},
+		// domains match at label boundaries only
+		{"badcorp.example", ""},
+		{"corp.example.net", ""},
+		{"example", ""},
+		{"", ""},
+	} {
+		got := ""
+		if upstreams := server.findForwarders(c.name); upstreams != nil {
+			got = upstreams[0].String()
+		}
+		if got != c.want {
+			t.Errorf("%q: forwarded to %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	// The root takes every name no other domain does
+	server.forwarders = map[string][]upstream.Upstream{"corp.example": corp, "lab.corp.example": lab, "": root}
+	for name, want := range map[string][]upstream.Upstream{
+		"www.example":          root,
+		"":                     root,
+		"host.corp.example":    corp,
+		"x.y.lab.corp.example": lab,
+	} {
+		if got := server.findForwarders(name); len(got) != 1 || got[0] != want[0] {
+			t.Errorf("%q: forwarded to %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"com.sentry.dev/app/acl"
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	"com.sentry.dev/app/upstream"
+	"com.sentry.dev/app/utils"
+	"com.sentry.dev/app/zone"
+	"fmt"
+	"log"
+)
+
+// view is the data a set of clients is answered from (split horizon)
+type view struct {
+	name    string
+	clients *acl.List
+	// Redis keys of the known hosts, their reverse names, the blacklist
+	// and the cache of the view
+	knownHosts   string
+	reverseHosts string
+	blackList    string
+	cache        string
+	zones        *zone.Store
+	zoneConfigs  []config.ZoneConfig
+	upstreams    []upstream.Upstream
+	// forwarded views resolve through their own upstreams, never
+	// iteratively
+	forwarded bool
+}
+
+// configViews loads the data of each view. Known hosts and blacklists are
+// kept in Redis under the keys of the default data suffixed with the name
+// of the view, and the cache is shared unless the view has its own
+// upstreams.
+func (server *UDPServer) configViews() {
+	server.defaultView = &view{
+		knownHosts:   utils.KnownHost,
+		reverseHosts: utils.ReverseHost,
+		blackList:    utils.BlackList,
+		cache:        utils.Cache,
+		zones:        server.zones,
+		upstreams:    server.upstreams,
+	}
+	names := make(map[string]bool)
+	for _, c := range server.Config.Views {
+		if c.Name == "" || names[c.Name] {
+			log.Fatalf("view name %q is empty or defined twice", c.Name)
+		}
+		names[c.Name] = true
+		v := *server.defaultView
+		v.name = c.Name
+		v.clients = allowList(c.MatchClients)
+		if c.KnownHostsFilePath != "" {
+			v.knownHosts = utils.KnownHost + ":" + c.Name
+			v.reverseHosts = utils.ReverseHost + ":" + c.Name
+			server.loadKnownHosts(c.KnownHostsFilePath, v.knownHosts, v.reverseHosts)
+		}
+		if c.BlacklistFilePath != "" {
+			v.blackList = utils.BlackList + ":" + c.Name
+			server.loadBlackList(c.BlacklistFilePath, v.blackList)
+		}
+		if len(c.Zones) > 0 {
+			v.zones = zone.NewStore()
+			v.zoneConfigs = c.Zones
+			for i := range c.Zones {
+				server.configViewZone(&v, &c.Zones[i])
+			}
+		}
+		if len(c.Upstreams) > 0 {
+			v.cache = utils.Cache + ":" + c.Name
+			v.upstreams = newUpstreams(c.Upstreams)
+			v.forwarded = true
+		}
+		server.views = append(server.views, &v)
+	}
+}
+
+// configViewZone loads a zone of a view. The zones of views are read only:
+// neither transferred, updated, nor kept in sync with a primary.
+func (server *UDPServer) configViewZone(v *view, zoneConfig *config.ZoneConfig) {
+	if zoneConfig.Primary != "" {
+		fmt.Println("Error loading zone", zoneConfig.Origin, "of view", v.name+": secondary zones are not supported in views")
+		return
+	}
+	if z := loadZone(zoneConfig); z != nil {
+		v.zones.Put(z)
+		fmt.Println("Loaded zone", dns.Fqdn(z.Origin), "of view", v.name, "serial", z.SOA().Data.(*dns.SOA).Serial)
+	}
+}
+
+// selectView returns the first view matching the client of req, the
+// default view when none does. The client subnet option of a trusted
+// client stands for its address.
+func (server *UDPServer) selectView(req *Request) *view {
+	if len(server.views) == 0 {
+		return server.defaultView
+	}
+	addr := req.ClientAddr.Addr()
+	if ecs := server.trustedClientSubnet(req); ecs != nil {
+		addr = ecs.Prefix.Addr()
+	}
+	for _, v := range server.views {
+		if v.clients.Check(addr) == acl.Allow {
+			return v
+		}
+	}
+	return server.defaultView
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"com.sentry.dev/app/acl"
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	"net/netip"
+	"testing"
+)
+
+func newView(t *testing.T, name string, cidrs ...string) *view {
+	t.Helper()
+	var rules []config.ACLRule
+	for _, cidr := range cidrs {
+		rules = append(rules, config.ACLRule{CIDR: cidr, Action: "allow"})
+	}
+	clients, err := acl.New(&config.ACLConfig{Default: "refuse", Rules: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &view{name: name, clients: clients}
+}
+
+func TestSelectView(t *testing.T) {
+	untrusted, _ := acl.New(&config.ACLConfig{Default: "refuse"})
+	server := &UDPServer{
+		views: []*view{
+			newView(t, "office", "10.1.0.0/16", "2001:db8:1::/48"),
+			newView(t, "lab", "10.1.2.0/24", "10.2.0.0/16"),
+		},
+		defaultView:     &view{},
+		clientSubnetACL: untrusted,
+	}
+	for _, c := range []struct {
+		client string
+		want   string
+	}{
+		{"10.1.0.7", "office"},
+		{"::ffff:10.1.0.7", "office"},
+		{"2001:db8:1:2::7", "office"},
+		// the first view matching wins, not the most specific one
+		{"10.1.2.7", "office"},
+		{"10.2.0.7", "lab"},
+		{"10.3.0.7", ""},
+		{"2001:db8:2::7", ""},
+	} {
+		req := &Request{
+			ClientAddr: netip.AddrPortFrom(netip.MustParseAddr(c.client), 53000),
+			Message:    &dns.Message{Header: &dns.Header{}},
+		}
+		if got := server.selectView(req); got.name != c.want {
+			t.Errorf("%s: view %q, want %q", c.client, got.name, c.want)
+		}
+	}
+
+	// The subnet a trusted forwarder sends stands for its address
+	server.clientSubnetACL, _ = acl.New(&config.ACLConfig{
+		Default: "refuse",
+		Rules:   []config.ACLRule{{CIDR: "10.1.0.1/32", Action: "allow"}},
+	})
+	for _, c := range []struct {
+		client string
+		subnet string
+		want   string
+	}{
+		{"10.1.0.1", "10.2.0.0/24", "lab"},
+		{"10.1.0.1", "192.0.2.0/24", ""},
+		{"10.1.0.1", "", "office"},
+		{"10.1.0.2", "10.2.0.0/24", "office"},
+	} {
+		var ecs *dns.ClientSubnet
+		if c.subnet != "" {
+			ecs = &dns.ClientSubnet{Prefix: netip.MustParsePrefix(c.subnet)}
+		}
+		if got := server.selectView(newECSRequest(c.client, ecs)); got.name != c.want {
+			t.Errorf("%s for %s: view %q, want %q", c.client, c.subnet, got.name, c.want)
+		}
+	}
+
+	none := &UDPServer{defaultView: server.defaultView}
+	if got := none.selectView(&Request{}); got != server.defaultView {
+		t.Errorf("without views: view %q", got.name)
+	}
+}
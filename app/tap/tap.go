@@ -0,0 +1,229 @@
+package tap
+
+import (
+	"com.sentry.dev/app/config"
+	dnstap "github.com/dnstap/golang-dnstap"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Tapper emits dnstap frames over Frame Streams to a Unix socket or a file
+type Tapper struct {
+	identity []byte
+	version  []byte
+	output   dnstap.Output
+}
+
+// New creates a Tapper from the dnstap configuration. It returns nil when
+// dnstap is disabled; a nil Tapper discards every frame.
+func New(cfg *config.DnstapConfig) (*Tapper, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	var output dnstap.Output
+	if cfg.SocketPath != "" {
+		sockOutput, err := dnstap.NewFrameStreamSockOutput(&net.UnixAddr{Name: cfg.SocketPath, Net: "unix"})
+		if err != nil {
+			return nil, err
+		}
+		output = sockOutput
+	} else {
+		fileOutput, err := dnstap.NewFrameStreamOutputFromFilename(cfg.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		output = fileOutput
+	}
+	go output.RunOutputLoop()
+	return &Tapper{
+		identity: []byte(cfg.Identity),
+		version:  []byte(cfg.Version),
+		output:   output,
+	}, nil
+}
+
+// Close flushes the pending frames and closes the output
+func (t *Tapper) Close() {
+	if t == nil {
+		return
+	}
+	t.output.Close()
+}
+
+// ClientQuery records a query received from a client
+func (t *Tapper) ClientQuery(
+	client netip.AddrPort,
+	local netip.AddrPort,
+	transport string,
+	msg []byte,
+	received time.Time,
+) {
+	if t == nil {
+		return
+	}
+	m := newMessage(dnstap.Message_CLIENT_QUERY, client, local, transport)
+	m.QueryTimeSec, m.QueryTimeNsec = timestamp(received)
+	m.QueryMessage = msg
+	t.emit(m)
+}
+
+// ClientResponse records a response sent back to a client
+func (t *Tapper) ClientResponse(
+	client netip.AddrPort,
+	local netip.AddrPort,
+	transport string,
+	msg []byte,
+	received time.Time,
+	sent time.Time,
+) {
+	if t == nil {
+		return
+	}
+	m := newMessage(dnstap.Message_CLIENT_RESPONSE, client, local, transport)
+	m.QueryTimeSec, m.QueryTimeNsec = timestamp(received)
+	m.ResponseTimeSec, m.ResponseTimeNsec = timestamp(sent)
+	m.ResponseMessage = msg
+	t.emit(m)
+}
+
+// ForwarderQuery records a query forwarded to an upstream. msg is nil when
+// the upstream does not expose the wire message (the system resolver).
+func (t *Tapper) ForwarderQuery(
+	upstream netip.AddrPort,
+	transport string,
+	msg []byte,
+	sent time.Time,
+) {
+	if t == nil {
+		return
+	}
+	m := newMessage(dnstap.Message_FORWARDER_QUERY, netip.AddrPort{}, upstream, transport)
+	m.QueryTimeSec, m.QueryTimeNsec = timestamp(sent)
+	m.QueryMessage = msg
+	t.emit(m)
+}
+
+// ForwarderResponse records a response received from an upstream
+func (t *Tapper) ForwarderResponse(
+	upstream netip.AddrPort,
+	transport string,
+	msg []byte,
+	sent time.Time,
+	received time.Time,
+) {
+	if t == nil {
+		return
+	}
+	m := newMessage(dnstap.Message_FORWARDER_RESPONSE, netip.AddrPort{}, upstream, transport)
+	m.QueryTimeSec, m.QueryTimeNsec = timestamp(sent)
+	m.ResponseTimeSec, m.ResponseTimeNsec = timestamp(received)
+	m.ResponseMessage = msg
+	t.emit(m)
+}
+
+// ResolverQuery records a query sent to an authoritative server while
+// resolving iteratively
+func (t *Tapper) ResolverQuery(
+	server netip.AddrPort,
+	transport string,
+	msg []byte,
+	sent time.Time,
+) {
+	if t == nil {
+		return
+	}
+	m := newMessage(dnstap.Message_RESOLVER_QUERY, netip.AddrPort{}, server, transport)
+	m.QueryTimeSec, m.QueryTimeNsec = timestamp(sent)
+	m.QueryMessage = msg
+	t.emit(m)
+}
+
+// ResolverResponse records a response received from an authoritative server
+func (t *Tapper) ResolverResponse(
+	server netip.AddrPort,
+	transport string,
+	msg []byte,
+	sent time.Time,
+	received time.Time,
+) {
+	if t == nil {
+		return
+	}
+	m := newMessage(dnstap.Message_RESOLVER_RESPONSE, netip.AddrPort{}, server, transport)
+	m.QueryTimeSec, m.QueryTimeNsec = timestamp(sent)
+	m.ResponseTimeSec, m.ResponseTimeNsec = timestamp(received)
+	m.ResponseMessage = msg
+	t.emit(m)
+}
+
+func (t *Tapper) emit(m *dnstap.Message) {
+	frame, err := proto.Marshal(&dnstap.Dnstap{
+		Identity: t.identity,
+		Version:  t.version,
+		Type:     dnstap.Dnstap_MESSAGE.Enum(),
+		Message:  m,
+	})
+	if err != nil {
+		log.Println("dnstap:", err)
+		return
+	}
+	select {
+	case t.output.GetOutputChannel() <- frame:
+	default:
+		log.Println("dnstap output is full")
+	}
+}
+
+func newMessage(
+	msgType dnstap.Message_Type,
+	query netip.AddrPort,
+	response netip.AddrPort,
+	transport string,
+) *dnstap.Message {
+	m := &dnstap.Message{
+		Type:           msgType.Enum(),
+		SocketProtocol: socketProtocol(transport),
+	}
+	if query.IsValid() {
+		m.SocketFamily = socketFamily(query.Addr())
+		m.QueryAddress = query.Addr().Unmap().AsSlice()
+		m.QueryPort = proto.Uint32(uint32(query.Port()))
+	}
+	if response.IsValid() {
+		if m.SocketFamily == nil {
+			m.SocketFamily = socketFamily(response.Addr())
+		}
+		m.ResponseAddress = response.Addr().Unmap().AsSlice()
+		m.ResponsePort = proto.Uint32(uint32(response.Port()))
+	}
+	return m
+}
+
+func socketFamily(addr netip.Addr) *dnstap.SocketFamily {
+	if addr.Unmap().Is4() {
+		return dnstap.SocketFamily_INET.Enum()
+	}
+	return dnstap.SocketFamily_INET6.Enum()
+}
+
+func socketProtocol(transport string) *dnstap.SocketProtocol {
+	switch transport {
+	case "udp":
+		return dnstap.SocketProtocol_UDP.Enum()
+	case "tcp":
+		return dnstap.SocketProtocol_TCP.Enum()
+	case "tls":
+		return dnstap.SocketProtocol_DOT.Enum()
+	case "https":
+		return dnstap.SocketProtocol_DOH.Enum()
+	default:
+		return nil
+	}
+}
+
+func timestamp(t time.Time) (*uint64, *uint32) {
+	return proto.Uint64(uint64(t.Unix())), proto.Uint32(uint32(t.Nanosecond()))
+}
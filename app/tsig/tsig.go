@@ -0,0 +1,289 @@
+package tsig
+
+import (
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"time"
+)
+
+// Algorithms supported for TSIG keys
+const (
+	HMACSHA256 = "hmac-sha256"
+	HMACSHA512 = "hmac-sha512"
+)
+
+const (
+	// fudge is the clock skew allowed between the signer and the verifier
+	fudge = 300
+	// maxUnsigned is how many messages of a stream may follow each other
+	// unsigned (RFC 8945 5.3.1)
+	maxUnsigned = 99
+)
+
+var algorithms = map[string]func() hash.Hash{
+	HMACSHA256: sha256.New,
+	HMACSHA512: sha512.New,
+}
+
+// Key is a secret shared with a client or another server
+type Key struct {
+	Name      string
+	Algorithm string
+	secret    []byte
+}
+
+// Keyring holds the configured keys by name
+type Keyring map[string]*Key
+
+// NewKeyring builds a Keyring from its configuration
+func NewKeyring(configs []config.TSIGKeyConfig) (Keyring, error) {
+	ring := make(Keyring)
+	for _, c := range configs {
+		name := dns.CanonicalName(c.Name)
+		if name == "" {
+			return nil, errors.New("TSIG key without a name")
+		}
+		if ring[name] != nil {
+			return nil, fmt.Errorf("TSIG key %q defined twice", c.Name)
+		}
+		algorithm := dns.CanonicalName(c.Algorithm)
+		if algorithms[algorithm] == nil {
+			return nil, fmt.Errorf("TSIG key %q: unsupported algorithm %q", c.Name, c.Algorithm)
+		}
+		secret, err := base64.StdEncoding.DecodeString(c.Secret)
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("TSIG key %q: secret is not valid base64", c.Name)
+		}
+		ring[name] = &Key{Name: name, Algorithm: algorithm, secret: secret}
+	}
+	return ring, nil
+}
+
+// Session follows one signed exchange, a request and its responses, each
+// MAC covering the one before it (RFC 8945 5.3). A nil Session stands for
+// an unsigned exchange.
+type Session struct {
+	key        *Key
+	name       string // key name and algorithm as sent, even when unknown
+	algorithm  string
+	mac        []byte // last MAC signed or verified
+	pending    []byte // messages received unsigned since that MAC
+	unsigned   int
+	responses  int
+	timeSigned uint64 // of the request
+	// Error is the TSIG error found verifying the request, if any
+	Error _type.ResponseCode
+}
+
+// Verify checks the TSIG record of a request. Unsigned requests get a nil
+// Session; requests failing verification get one carrying the TSIG error,
+// so that the error response is signed as RFC 8945 requires.
+func (ring Keyring) Verify(wire []byte, now time.Time) (*Session, error) {
+	stripped, rr, err := dns.StripTSIG(wire)
+	if err != nil || rr == nil {
+		return nil, err
+	}
+	t, ok := rr.Data.(*dns.TSIG)
+	if !ok {
+		return nil, errors.New("malformed TSIG record")
+	}
+	s := &Session{
+		name:       dns.CanonicalName(rr.Name),
+		algorithm:  dns.CanonicalName(t.Algorithm),
+		timeSigned: t.TimeSigned,
+	}
+	if key := ring[s.name]; key != nil && key.Algorithm == s.algorithm {
+		s.key = key
+		s.Error = s.check(stripped, t, now)
+	} else {
+		s.Error = _type.RCodeBadKey
+	}
+	return s, nil
+}
+
+// Sign signs a request with the key, and returns the session its responses
+// are verified with. A nil key leaves the request unsigned.
+func (k *Key) Sign(query []byte, now time.Time) ([]byte, *Session, error) {
+	if k == nil {
+		return query, nil, nil
+	}
+	s := &Session{key: k, name: k.Name, algorithm: k.Algorithm}
+	t := s.newRecord(query, now)
+	t.MAC = s.digest(query, t)
+	s.mac = t.MAC
+	signed, err := s.append(query, t)
+	return signed, s, err
+}
+
+// Key returns the key a request was verified with, nil when it failed
+func (s *Session) Key() *Key {
+	if s == nil || s.Error != _type.RCodeNoError {
+		return nil
+	}
+	return s.key
+}
+
+// Name returns the name of the key the request claims to be signed with
+func (s *Session) Name() string {
+	if s == nil {
+		return ""
+	}
+	return s.name
+}
+
+// Overhead returns an upper bound of the bytes a TSIG record adds to a
+// response
+func (s *Session) Overhead() int {
+	if s == nil {
+		return 0
+	}
+	// Owner, type, class, TTL and RDLENGTH, then the RDATA with the longest
+	// MAC and the server time of BADTIME responses
+	return len(s.name) + 2 + 10 + len(s.algorithm) + 2 + 16 + sha512.Size + 6
+}
+
+// Sign signs the next response of the exchange. Responses to requests with
+// an unknown key or a bad MAC carry the error without being signed.
+func (s *Session) Sign(msg []byte, now time.Time) ([]byte, error) {
+	if s == nil {
+		return msg, nil
+	}
+	t := s.newRecord(msg, now)
+	switch s.Error {
+	case _type.RCodeBadKey, _type.RCodeBadSig:
+	case _type.RCodeBadTime:
+		// The time of the server goes in the other data for the client to
+		// see how far apart the clocks are
+		t.TimeSigned = s.timeSigned
+		t.OtherData = uint48(uint64(now.Unix()))
+		fallthrough
+	default:
+		t.MAC = s.digest(msg, t)
+		s.mac = t.MAC
+		s.responses++
+	}
+	return s.append(msg, t)
+}
+
+// Verify checks the next response of the exchange. After the first one,
+// messages of a stream may come unsigned and are covered by the next MAC.
+func (s *Session) Verify(wire []byte, now time.Time) error {
+	if s == nil {
+		return nil
+	}
+	stripped, rr, err := dns.StripTSIG(wire)
+	if err != nil {
+		return err
+	}
+	if rr == nil {
+		if s.responses == 0 || s.unsigned == maxUnsigned {
+			return errors.New("response is not signed")
+		}
+		s.pending = append(s.pending, wire...)
+		s.unsigned++
+		return nil
+	}
+	t, ok := rr.Data.(*dns.TSIG)
+	switch {
+	case !ok:
+		return errors.New("malformed TSIG record")
+	case dns.CanonicalName(rr.Name) != s.name || dns.CanonicalName(t.Algorithm) != s.algorithm:
+		return errors.New("response signed with another key")
+	case t.Error != 0:
+		return fmt.Errorf("TSIG error %s", _type.ResponseCode(t.Error))
+	}
+	if rcode := s.check(stripped, t, now); rcode != _type.RCodeNoError {
+		return fmt.Errorf("TSIG verification failed: %s", rcode)
+	}
+	s.responses++
+	return nil
+}
+
+// Complete reports whether the last response verified was signed, as the
+// last message of a stream must be
+func (s *Session) Complete() bool {
+	return s == nil || s.unsigned == 0
+}
+
+// check verifies the MAC and the time of a message stripped of its TSIG
+// record, and chains the session to it
+func (s *Session) check(stripped []byte, t *dns.TSIG, now time.Time) _type.ResponseCode {
+	binary.BigEndian.PutUint16(stripped, t.OriginalID)
+	if !hmac.Equal(s.digest(stripped, t), t.MAC) {
+		return _type.RCodeBadSig
+	}
+	s.mac = t.MAC
+	s.pending = nil
+	s.unsigned = 0
+	signedAt := int64(t.TimeSigned)
+	if delta := now.Unix() - signedAt; delta > int64(t.Fudge) || -delta > int64(t.Fudge) {
+		return _type.RCodeBadTime
+	}
+	return _type.RCodeNoError
+}
+
+// digest computes the MAC of a message (RFC 8945 4.3): the previous MAC of
+// the exchange, the message, then the TSIG variables, reduced to the timers
+// after the first response
+func (s *Session) digest(msg []byte, t *dns.TSIG) []byte {
+	h := hmac.New(algorithms[s.key.Algorithm], s.key.secret)
+	if s.mac != nil {
+		h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(s.mac))))
+		h.Write(s.mac)
+	}
+	h.Write(s.pending)
+	h.Write(msg)
+
+	var vars []byte
+	if s.responses == 0 {
+		name, _ := dns.PackName(s.name)
+		algorithm, _ := dns.PackName(s.algorithm)
+		vars = append(vars, name...)
+		vars = binary.BigEndian.AppendUint16(vars, uint16(_type.ClassANY))
+		vars = binary.BigEndian.AppendUint32(vars, 0)
+		vars = append(vars, algorithm...)
+	}
+	vars = append(vars, uint48(t.TimeSigned)...)
+	vars = binary.BigEndian.AppendUint16(vars, t.Fudge)
+	if s.responses == 0 {
+		vars = binary.BigEndian.AppendUint16(vars, t.Error)
+		vars = binary.BigEndian.AppendUint16(vars, uint16(len(t.OtherData)))
+		vars = append(vars, t.OtherData...)
+	}
+	h.Write(vars)
+	return h.Sum(nil)
+}
+
+// newRecord returns the TSIG data of a message about to be signed
+func (s *Session) newRecord(msg []byte, now time.Time) *dns.TSIG {
+	return &dns.TSIG{
+		Algorithm:  s.algorithm,
+		TimeSigned: uint64(now.Unix()),
+		Fudge:      fudge,
+		OriginalID: binary.BigEndian.Uint16(msg),
+		Error:      uint16(s.Error),
+	}
+}
+
+// append adds the TSIG record to an encoded message
+func (s *Session) append(msg []byte, t *dns.TSIG) ([]byte, error) {
+	return dns.AppendRecord(msg, &dns.Record{
+		Name:  s.name,
+		Type:  _type.TypeTSIG,
+		Class: _type.ClassANY,
+		Data:  t,
+	})
+}
+
+func uint48(v uint64) []byte {
+	return []byte{byte(v >> 40), byte(v >> 32), byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
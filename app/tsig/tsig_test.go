@@ -0,0 +1,146 @@
+package tsig
+
+import (
+	"com.sentry.dev/app/config"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"testing"
+	"time"
+)
+
+var now = time.Unix(1700000000, 0)
+
+func newKeyring(t *testing.T) Keyring {
+	t.Helper()
+	ring, err := NewKeyring([]config.TSIGKeyConfig{
+		{Name: "transfer.example.", Algorithm: "hmac-sha256", Secret: "c2VjcmV0LXRyYW5zZmVyLWtleQ=="},
+		{Name: "update.example", Algorithm: "HMAC-SHA512.", Secret: "c2VjcmV0LXVwZGF0ZS1rZXk="},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+func pack(t *testing.T, id uint16, response bool) []byte {
+	t.Helper()
+	msg := &dns.Message{
+		Header: &dns.Header{ID: id, QueryResponse: response},
+		Questions: []*dns.Question{{
+			Name:  &dns.Addr{String: "example"},
+			Type:  _type.TypeAXFR,
+			Class: _type.ClassIN,
+		}},
+	}
+	wire, err := msg.Pack(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wire
+}
+
+func TestNewKeyringRejectsBadKeys(t *testing.T) {
+	for _, c := range []config.TSIGKeyConfig{
+		{Algorithm: HMACSHA256, Secret: "c2VjcmV0"},
+		{Name: "k", Algorithm: "hmac-md5", Secret: "c2VjcmV0"},
+		{Name: "k", Algorithm: HMACSHA256, Secret: "not base64"},
+		{Name: "k", Algorithm: HMACSHA256},
+	} {
+		if _, err := NewKeyring([]config.TSIGKeyConfig{c}); err == nil {
+			t.Errorf("key %+v accepted", c)
+		}
+	}
+	dup := config.TSIGKeyConfig{Name: "k", Algorithm: HMACSHA256, Secret: "c2VjcmV0"}
+	if _, err := NewKeyring([]config.TSIGKeyConfig{dup, dup}); err == nil {
+		t.Error("key defined twice accepted")
+	}
+}
+
+func TestSignedExchange(t *testing.T) {
+	ring := newKeyring(t)
+	for name, key := range ring {
+		query, client, err := key.Sign(pack(t, 1, false), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server, err := ring.Verify(query, now.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if server.Key() != key || server.Name() != name {
+			t.Fatalf("%s: request verified with key %v, error %s", name, server.Key(), server.Error)
+		}
+		// A stream of responses, the second one unsigned
+		for i, sign := range []bool{true, false, true} {
+			resp := pack(t, 1, true)
+			if sign {
+				if resp, err = server.Sign(resp, now); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				// The next MAC of the sender covers the message
+				server.pending = append(server.pending, resp...)
+			}
+			if err := client.Verify(resp, now); err != nil {
+				t.Fatalf("%s: response %d: %v", name, i, err)
+			}
+			if client.Complete() != sign {
+				t.Errorf("%s: response %d: complete %v", name, i, !sign)
+			}
+		}
+	}
+}
+
+func TestVerifyRequestErrors(t *testing.T) {
+	ring := newKeyring(t)
+	key := ring["transfer.example"]
+
+	unsigned, err := ring.Verify(pack(t, 1, false), now)
+	if err != nil || unsigned != nil {
+		t.Errorf("unsigned request: session %v, error %v", unsigned, err)
+	}
+
+	other := &Key{Name: "other", Algorithm: HMACSHA256, secret: []byte("secret")}
+	query, _, _ := other.Sign(pack(t, 1, false), now)
+	if s, _ := ring.Verify(query, now); s.Error != _type.RCodeBadKey || s.Key() != nil {
+		t.Errorf("unknown key: error %s", s.Error)
+	}
+
+	query, _, _ = key.Sign(pack(t, 1, false), now)
+	query[len(pack(t, 1, false))-1] ^= 1
+	if s, _ := ring.Verify(query, now); s.Error != _type.RCodeBadSig {
+		t.Errorf("altered request: error %s", s.Error)
+	}
+
+	query, _, _ = key.Sign(pack(t, 1, false), now)
+	if s, _ := ring.Verify(query, now.Add(time.Hour)); s.Error != _type.RCodeBadTime {
+		t.Errorf("request signed an hour earlier: error %s", s.Error)
+	}
+}
+
+func TestVerifyResponseErrors(t *testing.T) {
+	ring := newKeyring(t)
+	key := ring["transfer.example"]
+
+	_, client, _ := key.Sign(pack(t, 1, false), now)
+	if err := client.Verify(pack(t, 1, true), now); err == nil {
+		t.Error("unsigned first response accepted")
+	}
+
+	query, client, _ := key.Sign(pack(t, 1, false), now)
+	server, _ := ring.Verify(query, now)
+	resp, _ := server.Sign(pack(t, 1, true), now)
+	resp[2] ^= 0x04
+	if err := client.Verify(resp, now); err == nil {
+		t.Error("altered response accepted")
+	}
+
+	// The error of a request with a bad MAC comes back unsigned
+	query, client, _ = key.Sign(pack(t, 1, false), now)
+	query[len(query)-1] ^= 1
+	server, _ = ring.Verify(query, now)
+	resp, _ = server.Sign(pack(t, 1, true), now)
+	if err := client.Verify(resp, now); err == nil {
+		t.Error("BADSIG response accepted")
+	}
+}
@@ -0,0 +1,89 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+)
+
+const dnsMessage = "application/dns-message"
+
+// doh forwards queries over HTTPS (RFC 8484) with POST requests. The HTTP
+// transport keeps connections open and multiplexes queries over HTTP/2.
+type doh struct {
+	url    string
+	addr   netip.AddrPort
+	client *http.Client
+}
+
+func newDoH(u *url.URL, tlsConfig *tls.Config, poolSize int) *doh {
+	return &doh{
+		url:  u.String(),
+		addr: literalAddr(hostPort(u, "443")),
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:     tlsConfig,
+				ForceAttemptHTTP2:   true,
+				MaxIdleConnsPerHost: poolSize,
+				IdleConnTimeout:     idleTimeout,
+			},
+		},
+	}
+}
+
+// Exchange posts query with a zero ID, as RFC 8484 4.1 recommends for
+// caching, and gives the response the ID of the query back
+func (u *doh) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) < 2 {
+		return nil, errors.New("query too short")
+	}
+	id := binary.BigEndian.Uint16(query)
+	body := bytes.Clone(query)
+	binary.BigEndian.PutUint16(body, 0)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessage)
+	req.Header.Set("Accept", dnsMessage)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream answered HTTP %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Type") != dnsMessage {
+		return nil, errors.New("upstream answered " + resp.Header.Get("Content-Type"))
+	}
+	msg, err := io.ReadAll(io.LimitReader(resp.Body, messageLimit))
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) < 2 {
+		return nil, errors.New("response too short")
+	}
+	binary.BigEndian.PutUint16(msg, id)
+	return msg, nil
+}
+
+func (u *doh) Transport() string {
+	return TransportHTTPS
+}
+
+func (u *doh) Addr() netip.AddrPort {
+	return u.addr
+}
+
+func (u *doh) String() string {
+	return u.url
+}
@@ -0,0 +1,141 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// dot forwards queries over TLS (RFC 7858), one query at a time on each
+// connection. Connections are kept open between queries, up to the pool
+// size.
+type dot struct {
+	url      string
+	address  string
+	dialer   *tls.Dialer
+	poolSize int
+
+	mu   sync.Mutex
+	idle []*pooledConn
+}
+
+type pooledConn struct {
+	net.Conn
+	usedAt time.Time
+}
+
+func newDoT(rawURL, address string, tlsConfig *tls.Config, poolSize int) *dot {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{"dot"}
+	return &dot{
+		url:      rawURL,
+		address:  address,
+		dialer:   &tls.Dialer{Config: tlsConfig},
+		poolSize: poolSize,
+	}
+}
+
+// Exchange sends query on a pooled connection. A pooled connection may have
+// been closed by the upstream in the meantime, in which case the query is
+// sent again on another one.
+func (u *dot) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) < 2 {
+		return nil, errors.New("query too short")
+	}
+	for {
+		conn, reused, err := u.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := roundTrip(ctx, conn, query)
+		if err == nil {
+			u.put(conn)
+			return resp, nil
+		}
+		conn.Close()
+		if !reused || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+}
+
+func (u *dot) Transport() string {
+	return TransportTLS
+}
+
+func (u *dot) Addr() netip.AddrPort {
+	return literalAddr(u.address)
+}
+
+func (u *dot) String() string {
+	return u.url
+}
+
+// get returns the most recently used idle connection, or dials a new one
+func (u *dot) get(ctx context.Context) (*pooledConn, bool, error) {
+	u.mu.Lock()
+	for len(u.idle) > 0 {
+		conn := u.idle[len(u.idle)-1]
+		u.idle = u.idle[:len(u.idle)-1]
+		if time.Since(conn.usedAt) < idleTimeout {
+			u.mu.Unlock()
+			return conn, true, nil
+		}
+		conn.Close()
+	}
+	u.mu.Unlock()
+
+	conn, err := u.dialer.DialContext(ctx, "tcp", u.address)
+	if err != nil {
+		return nil, false, err
+	}
+	return &pooledConn{Conn: conn}, false, nil
+}
+
+// put returns a connection to the pool, or closes it when the pool is full
+func (u *dot) put(conn *pooledConn) {
+	conn.usedAt = time.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.idle) >= u.poolSize {
+		conn.Close()
+		return
+	}
+	u.idle = append(u.idle, conn)
+}
+
+// roundTrip writes the length-prefixed query and reads its response
+func roundTrip(ctx context.Context, conn net.Conn, query []byte) ([]byte, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(idleTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(buf, uint16(len(query)))
+	copy(buf[2:], query)
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	if len(resp) < 2 || binary.BigEndian.Uint16(resp) != binary.BigEndian.Uint16(query) {
+		return nil, errors.New("response does not match the query")
+	}
+	return resp, nil
+}
@@ -0,0 +1,89 @@
+package upstream
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// udpMessageLimit is the largest response read over UDP
+const udpMessageLimit = 4096
+
+// truncated is the TC bit of the header flags
+const truncated = 1 << 9
+
+// plain forwards queries in clear over UDP, and again over TCP when the
+// answer is truncated, as to the servers of private domains
+type plain struct {
+	url     string
+	address string
+}
+
+func newPlain(rawURL, address string) *plain {
+	return &plain{url: rawURL, address: address}
+}
+
+// NewPlain returns the upstream of a server queried in clear at address
+func NewPlain(address string) Upstream {
+	return newPlain(address, address)
+}
+
+func (u *plain) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) < 2 {
+		return nil, errors.New("query too short")
+	}
+	resp, err := u.exchangeUDP(ctx, query)
+	if err != nil || binary.BigEndian.Uint16(resp[2:])&truncated == 0 {
+		return resp, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", u.address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return roundTrip(ctx, conn, query)
+}
+
+// exchangeUDP sends query and waits for the response with its ID,
+// ignoring anything else arriving on the socket
+func (u *plain) exchangeUDP(ctx context.Context, query []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", u.address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, udpMessageLimit)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 12 && binary.BigEndian.Uint16(buf) == binary.BigEndian.Uint16(query) {
+			return buf[:n], nil
+		}
+	}
+}
+
+func (u *plain) Transport() string {
+	return TransportUDP
+}
+
+func (u *plain) Addr() netip.AddrPort {
+	return literalAddr(u.address)
+}
+
+func (u *plain) String() string {
+	return u.url
+}
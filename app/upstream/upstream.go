@@ -0,0 +1,153 @@
+package upstream
+
+import (
+	"com.sentry.dev/app/config"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Transports of the upstreams, as labelled by dnstap
+const (
+	TransportUDP   = "udp"
+	TransportTLS   = "tls"
+	TransportHTTPS = "https"
+)
+
+const (
+	// defaultPoolSize is how many idle connections are kept per upstream
+	defaultPoolSize = 4
+	// idleTimeout closes pooled connections left unused for longer, before
+	// the resolver at the other end does
+	idleTimeout = 30 * time.Second
+	// messageLimit is the largest response read from an upstream
+	messageLimit = 65535
+)
+
+// Upstream is a resolver queries are forwarded to
+type Upstream interface {
+	// Exchange sends an encoded query and returns the encoded response
+	Exchange(ctx context.Context, query []byte) ([]byte, error)
+	// Transport returns TransportUDP, TransportTLS or TransportHTTPS
+	Transport() string
+	// Addr returns the address of the upstream, unset when it is named
+	// by host name
+	Addr() netip.AddrPort
+	String() string
+}
+
+// New builds the upstream of a tls://host[:port] or https://host/path URL,
+// or of a plain udp://host[:port] URL, the scheme being optional for the
+// latter
+func New(c config.UpstreamConfig) (Upstream, error) {
+	rawURL := c.URL
+	if addr, err := netip.ParseAddr(rawURL); err == nil {
+		rawURL = "udp://" + net.JoinHostPort(addr.String(), "53")
+	} else if !strings.Contains(rawURL, "://") {
+		rawURL = "udp://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("upstream %q has no host", c.URL)
+	}
+	if u.Scheme == "udp" {
+		return newPlain(c.URL, hostPort(u, "53")), nil
+	}
+	tlsConfig, err := newTLSConfig(c, u.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("upstream %q: %w", c.URL, err)
+	}
+	poolSize := c.PoolSize
+	if poolSize <= 0 {
+		poolSize = defaultPoolSize
+	}
+	switch u.Scheme {
+	case "tls":
+		return newDoT(c.URL, hostPort(u, "853"), tlsConfig, poolSize), nil
+	case "https":
+		return newDoH(u, tlsConfig, poolSize), nil
+	default:
+		return nil, fmt.Errorf("upstream %q: scheme must be udp, tls or https", c.URL)
+	}
+}
+
+// newTLSConfig verifies the upstream certificate against the server name,
+// or against the pinned keys when there are any
+func newTLSConfig(c config.UpstreamConfig, host string) (*tls.Config, error) {
+	serverName := c.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	tlsConfig := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+		// Sessions are resumed when a pooled connection is replaced
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	if len(c.PinSHA256) == 0 {
+		return tlsConfig, nil
+	}
+	var pins [][]byte
+	for _, pin := range c.PinSHA256 {
+		digest, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("pin %q is not a base64 SHA-256 digest", pin)
+		}
+		pins = append(pins, digest)
+	}
+	// A pinned key stands in for the chain of trust: only the key of the
+	// server certificate is checked, so self-signed certificates work too
+	tlsConfig.InsecureSkipVerify = true
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("upstream sent no certificate")
+		}
+		if !matchesPin(state.PeerCertificates[0], pins) {
+			return errors.New("upstream certificate matches no pinned key")
+		}
+		return nil
+	}
+	return tlsConfig, nil
+}
+
+// matchesPin reports whether the SHA-256 of the certificate public key
+// (RFC 7469 2.4) is one of pins
+func matchesPin(cert *x509.Certificate, pins [][]byte) bool {
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	for _, pin := range pins {
+		if subtle.ConstantTimeCompare(digest[:], pin) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// hostPort returns the host and port of u, the port defaulting to port
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// literalAddr returns the address of host:port when the host is an IP
+func literalAddr(address string) netip.AddrPort {
+	addr, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	return addr
+}
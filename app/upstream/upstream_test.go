@@ -0,0 +1,283 @@
+package upstream
+
+import (
+	"com.sentry.dev/app/config"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// query is a bare header with ID 0x1234
+var query = []byte{0x12, 0x34, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+// answer turns a query into its response
+func answer(query []byte) []byte {
+	resp := append([]byte(nil), query...)
+	resp[2] |= 0x80
+	return resp
+}
+
+func pin(cert *x509.Certificate) string {
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// selfSigned returns a certificate for 127.0.0.1 signed by its own key
+func selfSigned(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// dotServer is a DNS over TLS stand-in answering every query, on
+// connections it closes after one answer when oneShot is set
+type dotServer struct {
+	addr        string
+	cert        tls.Certificate
+	connections atomic.Int32
+	oneShot     bool
+}
+
+func newDoTServer(t *testing.T, oneShot bool) *dotServer {
+	t.Helper()
+	s := &dotServer{cert: selfSigned(t), oneShot: oneShot}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{s.cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s.addr = ln.Addr().String()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.connections.Add(1)
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *dotServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		resp := answer(msg)
+		out := binary.BigEndian.AppendUint16(nil, uint16(len(resp)))
+		if _, err := conn.Write(append(out, resp...)); err != nil || s.oneShot {
+			return
+		}
+	}
+}
+
+func exchange(t *testing.T, u Upstream) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := u.Exchange(ctx, query)
+	if err == nil && string(resp) != string(answer(query)) {
+		t.Errorf("response %v to %v", resp, query)
+	}
+	return err
+}
+
+func TestNewRejectsBadUpstreams(t *testing.T) {
+	for _, c := range []config.UpstreamConfig{
+		{URL: "tls://"},
+		{URL: "ftp://192.0.2.1"},
+		{URL: "tls://192.0.2.1", PinSHA256: []string{"not base64"}},
+		{URL: "https://192.0.2.1/dns-query", PinSHA256: []string{base64.StdEncoding.EncodeToString([]byte("short"))}},
+	} {
+		if _, err := New(c); err == nil {
+			t.Errorf("upstream %+v accepted", c)
+		}
+	}
+}
+
+func TestDoTPinnedKey(t *testing.T) {
+	server := newDoTServer(t, false)
+	other := selfSigned(t)
+	for _, c := range []struct {
+		name string
+		pins []string
+		ok   bool
+	}{
+		{"pinned key", []string{pin(server.cert.Leaf)}, true},
+		{"one of the pinned keys", []string{pin(other.Leaf), pin(server.cert.Leaf)}, true},
+		{"wrong pin", []string{pin(other.Leaf)}, false},
+		// self-signed, so not trusted without a pin
+		{"no pin", nil, false},
+	} {
+		u, err := New(config.UpstreamConfig{URL: "tls://" + server.addr, PinSHA256: c.pins})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := exchange(t, u); (err == nil) != c.ok {
+			t.Errorf("%s: error %v", c.name, err)
+		}
+	}
+}
+
+func TestDoTReusesConnections(t *testing.T) {
+	server := newDoTServer(t, false)
+	u, err := New(config.UpstreamConfig{URL: "tls://" + server.addr, PinSHA256: []string{pin(server.cert.Leaf)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if err := exchange(t, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := server.connections.Load(); n != 1 {
+		t.Errorf("%d connections for queries in a row, want 1", n)
+	}
+}
+
+func TestDoTRetriesStaleConnection(t *testing.T) {
+	server := newDoTServer(t, true)
+	u, err := New(config.UpstreamConfig{URL: "tls://" + server.addr, PinSHA256: []string{pin(server.cert.Leaf)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		// the second query goes first on the connection the server closed
+		if err := exchange(t, u); err != nil {
+			t.Fatalf("query %d: %v", i, err)
+		}
+	}
+	if n := server.connections.Load(); n != 2 {
+		t.Errorf("%d connections, want 2", n)
+	}
+}
+
+func TestDoHStatus(t *testing.T) {
+	var status atomic.Int32
+	var contentType atomic.Value
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dnsMessage || binary.BigEndian.Uint16(body) != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", contentType.Load().(string))
+		w.WriteHeader(int(status.Load()))
+		w.Write(answer(body))
+	}))
+	defer srv.Close()
+	u, err := New(config.UpstreamConfig{URL: srv.URL + "/dns-query", PinSHA256: []string{pin(srv.Certificate())}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		status      int
+		contentType string
+		ok          bool
+	}{
+		{http.StatusOK, dnsMessage, true},
+		{http.StatusOK, "text/html", false},
+		{http.StatusServiceUnavailable, dnsMessage, false},
+		{http.StatusNotFound, "text/plain", false},
+	} {
+		status.Store(int32(c.status))
+		contentType.Store(c.contentType)
+		// exchange checks the ID given back to the response
+		if err := exchange(t, u); (err == nil) != c.ok {
+			t.Errorf("HTTP %d %s: error %v", c.status, c.contentType, err)
+		}
+	}
+
+	wrongPin, err := New(config.UpstreamConfig{URL: srv.URL + "/dns-query", PinSHA256: []string{pin(selfSigned(t).Leaf)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exchange(t, wrongPin); err == nil {
+		t.Error("server with a key not pinned accepted")
+	}
+}
+
+func TestPlainRetriesTruncatedOverTCP(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// a stray response comes first, then the truncated one
+			stray := answer(buf[:n])
+			stray[1]++
+			udp.WriteTo(stray, addr)
+			resp := answer(buf[:n])
+			resp[2] |= truncated >> 8
+			udp.WriteTo(resp, addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			go (&dotServer{}).serve(conn)
+		}
+	}()
+
+	u, err := New(config.UpstreamConfig{URL: udp.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exchange(t, u); err != nil {
+		t.Fatal(err)
+	}
+}
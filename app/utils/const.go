@@ -1,6 +1,10 @@
 package utils
 
 const (
-	KnownHost = "known_host"
-	BlackList = "black_list"
+	KnownHost   = "known_host"
+	ReverseHost = "reverse_host"
+	BlackList   = "black_list"
+	BlockList   = "block_list"
+	AllowList   = "allow_list"
+	Cache       = "cache"
 )
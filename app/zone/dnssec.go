@@ -0,0 +1,418 @@
+package zone
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"com.sentry.dev/app/dnssec"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSignatureValidity is how long the signatures made on the fly
+	// hold unless configured
+	DefaultSignatureValidity = 7 * 24 * time.Hour
+	// signatureSkew backdates signatures for validators whose clock is late
+	signatureSkew = time.Hour
+	// maxSignatures bounds the signatures kept for reuse
+	maxSignatures = 10000
+)
+
+// Signer signs the answers of a zone on the fly. Names and types are denied
+// with NSEC or NSEC3 white lies covering only the name asked for, so that
+// the zone cannot be walked (RFC 4470, RFC 7129 appendix B).
+type Signer struct {
+	keys     []*dnssec.Key
+	nsec3    bool
+	validity time.Duration
+
+	mu     sync.Mutex
+	cache  map[string]*signatures
+	states map[*dnssec.Key]string
+}
+
+// signatures are the RRSIG records of an RRset, made by the keys listed
+type signatures struct {
+	records []*dns.Record
+	keys    string
+	refresh time.Time
+}
+
+// NewSigner returns a signer of the keys of a zone, denying names with NSEC3
+// records when nsec3 is set
+func NewSigner(keys []*dnssec.Key, nsec3 bool, validity time.Duration) *Signer {
+	if validity <= 0 {
+		validity = DefaultSignatureValidity
+	}
+	return &Signer{
+		keys:     keys,
+		nsec3:    nsec3,
+		validity: validity,
+		cache:    make(map[string]*signatures),
+		states:   make(map[*dnssec.Key]string),
+	}
+}
+
+// SetSigner signs the answers of the zone, and of its later versions, with
+// signer
+func (z *Zone) SetSigner(signer *Signer) {
+	z.signer = signer
+}
+
+// node returns the RRsets at name, those of the apex of a signed zone
+// joined by its DNSKEY and NSEC3PARAM RRsets
+func (z *Zone) node(name string) (rrsets, bool) {
+	sets, ok := z.nodes[name]
+	if !ok || name != z.Origin || z.signer == nil {
+		return sets, ok
+	}
+	apex := make(rrsets, len(sets)+2)
+	for t, set := range sets {
+		apex[t] = set
+	}
+	soa := sets[_type.TypeSOA][0]
+	keys := slices.Clip(sets[_type.TypeDNSKEY])
+	now := time.Now()
+	for _, k := range z.signer.keys {
+		if k.Published(now) {
+			keys = append(keys, &dns.Record{Name: soa.Name, Type: _type.TypeDNSKEY, Class: soa.Class, TTL: soa.TTL, Data: k.DNSKEY})
+		}
+	}
+	apex[_type.TypeDNSKEY] = keys
+	if z.signer.nsec3 {
+		apex[_type.TypeNSEC3PARAM] = []*dns.Record{{
+			Name:  soa.Name,
+			Type:  _type.TypeNSEC3PARAM,
+			Class: soa.Class,
+			Data:  &dns.NSEC3PARAM{HashAlgorithm: dnssec.HashSHA1},
+		}}
+	}
+	return apex, true
+}
+
+// withSignatures returns an RRset followed by its signatures
+func (s *Signer) withSignatures(z *Zone, set []*dns.Record) []*dns.Record {
+	return append(slices.Clip(set), s.sign(z, set, set[0].Name, set[0].TTL)...)
+}
+
+// sign returns the signatures of an RRset of the zone by the keys active
+// for its type, named owner with ttl as answered. Signatures are reused
+// until half of their validity has passed.
+func (s *Signer) sign(z *Zone, set []*dns.Record, owner string, ttl uint32) []*dns.Record {
+	now := time.Now()
+	keys := s.active(set[0].Type, now)
+	var tags strings.Builder
+	for _, k := range keys {
+		fmt.Fprint(&tags, k.Tag, " ")
+	}
+	var id strings.Builder
+	id.WriteString(z.Origin)
+	for _, r := range set {
+		id.WriteByte('\n')
+		id.WriteString(r.String())
+	}
+
+	s.mu.Lock()
+	s.logRollover(z, now)
+	entry, ok := s.cache[id.String()]
+	s.mu.Unlock()
+	if !ok || entry.keys != tags.String() || now.After(entry.refresh) {
+		entry = &signatures{keys: tags.String(), refresh: now.Add(s.validity / 2)}
+		for _, k := range keys {
+			sig, err := dnssec.Sign(set, k, z.Origin, now.Add(-signatureSkew), now.Add(s.validity))
+			if err != nil {
+				fmt.Println("Error signing", set[0].Type, "of", dns.Fqdn(set[0].Name)+":", err)
+				continue
+			}
+			entry.records = append(entry.records, sig)
+		}
+		s.mu.Lock()
+		if len(s.cache) >= maxSignatures {
+			s.cache = make(map[string]*signatures)
+		}
+		s.cache[id.String()] = entry
+		s.mu.Unlock()
+	}
+
+	sigs := make([]*dns.Record, len(entry.records))
+	for i, sig := range entry.records {
+		copied := *sig
+		copied.Name, copied.TTL = owner, ttl
+		sigs[i] = &copied
+	}
+	return sigs
+}
+
+// active returns the keys signing the RRsets of type t at now: the KSKs
+// sign the DNSKEY RRset and the ZSKs the others, each standing in for the
+// other while it has no active key
+func (s *Signer) active(t _type.RecordType, now time.Time) []*dnssec.Key {
+	var ksk, zsk []*dnssec.Key
+	for _, k := range s.keys {
+		if !k.Active(now) {
+			continue
+		}
+		if k.KSK {
+			ksk = append(ksk, k)
+		}
+		if k.ZSK {
+			zsk = append(zsk, k)
+		}
+	}
+	if (t == _type.TypeDNSKEY && len(ksk) > 0) || len(zsk) == 0 {
+		return ksk
+	}
+	return zsk
+}
+
+// logRollover reports the keys that changed state since the last answer
+func (s *Signer) logRollover(z *Zone, now time.Time) {
+	for _, k := range s.keys {
+		var state string
+		switch {
+		case k.Active(now):
+			state = "active"
+		case k.Published(now) && now.Before(k.Activate):
+			state = "published"
+		case k.Published(now):
+			state = "retired"
+		case now.Before(k.Publish):
+			state = "pending"
+		default:
+			state = "removed"
+		}
+		if previous, ok := s.states[k]; ok && previous != state {
+			fmt.Println("Key", k.Tag, "of zone", dns.Fqdn(z.Origin), "is now", state)
+		}
+		s.states[k] = state
+	}
+}
+
+// delegation returns the signed DS RRset of a delegation, or the proof
+// that it has none
+func (s *Signer) delegation(z *Zone, cut string) []*dns.Record {
+	sets := z.nodes[cut]
+	if ds := sets[_type.TypeDS]; len(ds) > 0 {
+		return s.withSignatures(z, ds)
+	}
+	return s.matching(z, cut, sets)
+}
+
+// nameError proves that name does not exist, nor the wildcard of its
+// closest encloser
+func (s *Signer) nameError(z *Zone, name string) []*dns.Record {
+	ce := z.closestEncloser(name)
+	wildcard := joinName("*", ce)
+	if s.nsec3 {
+		sets, _ := z.node(ce)
+		proof := s.matching(z, ce, sets)
+		proof = append(proof, s.nsec3Covering(z, nextCloser(name, ce))...)
+		return append(proof, s.nsec3Covering(z, wildcard)...)
+	}
+	proof := s.nsecCovering(z, name, nextCloser(name, ce))
+	return append(proof, s.nsecCovering(z, wildcard, wildcard)...)
+}
+
+// noData proves that name has no RRset of the type asked for, or that the
+// wildcard at source answering for it has none
+func (s *Signer) noData(z *Zone, name, source string) []*dns.Record {
+	sets, _ := z.node(source)
+	if source == name {
+		return s.matching(z, name, sets)
+	}
+	ce := dns.Parent(source)
+	var proof []*dns.Record
+	if s.nsec3 {
+		ceSets, _ := z.node(ce)
+		proof = s.matching(z, ce, ceSets)
+	}
+	proof = append(proof, s.expansion(z, name, ce)...)
+	return append(proof, s.matching(z, source, sets)...)
+}
+
+// expansion proves that name, answered from the wildcard of its closest
+// encloser ce, does not exist itself
+func (s *Signer) expansion(z *Zone, name, ce string) []*dns.Record {
+	if s.nsec3 {
+		return s.nsec3Covering(z, nextCloser(name, ce))
+	}
+	return s.nsecCovering(z, name, nextCloser(name, ce))
+}
+
+// matching returns the NSEC or NSEC3 record of an existing name, listing
+// the types of its RRsets
+func (s *Signer) matching(z *Zone, name string, sets rrsets) []*dns.Record {
+	types := s.types(z, name, sets)
+	if s.nsec3 {
+		h := dnssec.HashName(name, 0, nil)
+		return s.nsec3Record(z, h, step(h, 1), types)
+	}
+	next := joinName("\x00", name)
+	if wireLength(next) > 255 {
+		_, next = z.neighbours(name)
+	}
+	return s.nsecRecord(z, name, next, types)
+}
+
+// nsecCovering returns an NSEC record covering the missing name and no
+// other name of the zone. Its span runs from right before nc, the ancestor
+// of name one label below its closest encloser, to right after the names
+// below nc, so that validators find the closest encloser from either end.
+func (s *Signer) nsecCovering(z *Zone, name, nc string) []*dns.Record {
+	prev, next := z.neighbours(name)
+	owner := predecessor(nc)
+	types := []_type.RecordType{_type.TypeRRSIG, _type.TypeNSEC}
+	if owner == "" || dnssec.Compare(owner, prev) <= 0 {
+		owner = prev
+		sets, _ := z.node(prev)
+		types = s.types(z, prev, sets)
+	}
+	if label, parent, _ := strings.Cut(nc, "."); len(label) < 63 && wireLength(nc) < 255 {
+		next = joinName(label+"\x00", parent)
+	}
+	return s.nsecRecord(z, owner, next, types)
+}
+
+// nsec3Covering returns an NSEC3 record covering the hash of the missing
+// name and no other hash
+func (s *Signer) nsec3Covering(z *Zone, name string) []*dns.Record {
+	h := dnssec.HashName(name, 0, nil)
+	return s.nsec3Record(z, step(h, -1), step(h, 1), nil)
+}
+
+// types lists the types of the RRsets at name for the bitmap of its NSEC
+// or NSEC3 record. The parent side of a delegation only holds its NS and
+// DS RRsets, and an NSEC3 record only lists RRSIG for signed RRsets.
+func (s *Signer) types(z *Zone, name string, sets rrsets) []_type.RecordType {
+	delegation := name != z.Origin && len(sets[_type.TypeNS]) > 0
+	var types []_type.RecordType
+	signed := false
+	for t, set := range sets {
+		if len(set) == 0 || (delegation && t != _type.TypeNS && t != _type.TypeDS) {
+			continue
+		}
+		types = append(types, t)
+		signed = signed || !delegation || t == _type.TypeDS
+	}
+	if signed || !s.nsec3 {
+		types = append(types, _type.TypeRRSIG)
+	}
+	if !s.nsec3 {
+		types = append(types, _type.TypeNSEC)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// nsecRecord returns a signed NSEC record, with the TTL of negative answers
+// (RFC 9077)
+func (s *Signer) nsecRecord(z *Zone, owner, next string, types []_type.RecordType) []*dns.Record {
+	soa := z.negativeSOA()
+	return s.withSignatures(z, []*dns.Record{{
+		Name:  owner,
+		Type:  _type.TypeNSEC,
+		Class: soa.Class,
+		TTL:   soa.TTL,
+		Data:  &dns.NSEC{NextDomain: next, Types: types},
+	}})
+}
+
+// nsec3Record returns a signed NSEC3 record, hashed without salt nor extra
+// iterations (RFC 9276)
+func (s *Signer) nsec3Record(z *Zone, owner, next []byte, types []_type.RecordType) []*dns.Record {
+	soa := z.negativeSOA()
+	return s.withSignatures(z, []*dns.Record{{
+		Name:  joinName(strings.ToLower(dns.Base32Hex.EncodeToString(owner)), z.Origin),
+		Type:  _type.TypeNSEC3,
+		Class: soa.Class,
+		TTL:   soa.TTL,
+		Data:  &dns.NSEC3{HashAlgorithm: dnssec.HashSHA1, NextHashed: next, Types: types},
+	}})
+}
+
+// closestEncloser returns the closest existing ancestor of a missing name
+func (z *Zone) closestEncloser(name string) string {
+	for name != z.Origin {
+		name = dns.Parent(name)
+		if _, ok := z.nodes[name]; ok {
+			break
+		}
+	}
+	return name
+}
+
+// neighbours returns the names of the zone right before and after name in
+// canonical order, the latter wrapping around to the origin
+func (z *Zone) neighbours(name string) (string, string) {
+	prev, next := z.Origin, ""
+	for candidate := range z.nodes {
+		switch c := dnssec.Compare(candidate, name); {
+		case c < 0 && dnssec.Compare(candidate, prev) > 0:
+			prev = candidate
+		case c > 0 && (next == "" || dnssec.Compare(candidate, next) < 0):
+			next = candidate
+		}
+	}
+	if next == "" {
+		next = z.Origin
+	}
+	return prev, next
+}
+
+// nextCloser returns the ancestor of name one label below ce
+func nextCloser(name, ce string) string {
+	labels := dns.SplitName(name)
+	return strings.Join(labels[len(labels)-len(dns.SplitName(ce))-1:], ".")
+}
+
+// predecessor returns a name close before name in canonical order, with
+// the last octet of its first label decremented and the label padded with
+// '~' (RFC 4471 3.1), or "" when there is no simple one
+func predecessor(name string) string {
+	label, parent, _ := strings.Cut(name, ".")
+	last := label[len(label)-1]
+	switch {
+	case last == 0:
+		if len(label) == 1 {
+			return ""
+		}
+		return joinName(label[:len(label)-1], parent)
+	case last > 0x80:
+		return ""
+	case last == '[':
+		// uppercase letters sort as lowercase ones
+		last = '@'
+	case last == '/':
+		last = '-'
+	default:
+		last--
+	}
+	label = label[:len(label)-1] + string([]byte{last})
+	room := min(63, 254-wireLength(parent)) - len(label)
+	return joinName(label+strings.Repeat("~", max(room, 0)), parent)
+}
+
+// wireLength is the length of a name in wire form
+func wireLength(name string) int {
+	if name == "" {
+		return 1
+	}
+	return len(name) + 2
+}
+
+// step returns hash incremented by delta, wrapping around
+func step(hash []byte, delta int) []byte {
+	out := slices.Clone(hash)
+	for i := len(out) - 1; i >= 0; i-- {
+		v := int(out[i]) + delta
+		out[i] = byte(v)
+		if v >= 0 && v <= 0xFF {
+			break
+		}
+		delta = v >> 8
+	}
+	return out
+}
@@ -0,0 +1,194 @@
+package zone
+
+import (
+	"bufio"
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// maxJournalDeltas bounds the deltas kept in a journal. Secondaries further
+// behind fall back to a full transfer.
+const maxJournalDeltas = 100
+
+// Delta is the difference between two versions of a zone
+type Delta struct {
+	From    *dns.Record // SOA of the older version
+	To      *dns.Record // SOA of the newer version
+	Removed []*dns.Record
+	Added   []*dns.Record
+}
+
+// Records returns the delta in IXFR order: the old SOA, the removed records,
+// the new SOA and the added records (RFC 1995)
+func (d *Delta) Records() []*dns.Record {
+	records := make([]*dns.Record, 0, len(d.Removed)+len(d.Added)+2)
+	records = append(records, d.From)
+	records = append(records, d.Removed...)
+	records = append(records, d.To)
+	return append(records, d.Added...)
+}
+
+// Journal keeps the successive deltas of a zone in a master file, written
+// in IXFR order, so that incremental transfers survive restarts
+type Journal struct {
+	path   string
+	mu     sync.Mutex
+	deltas []*Delta
+}
+
+// OpenJournal reads the journal at path, which may not exist yet
+func OpenJournal(path string) (*Journal, error) {
+	j := &Journal{path: path}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	records, err := ParseRecords(path, "")
+	if err != nil {
+		return nil, err
+	}
+	if j.deltas, err = SplitDeltas(records); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return j, nil
+}
+
+// SplitDeltas cuts records in IXFR order into deltas, each SOA switching
+// between removed and added records
+func SplitDeltas(records []*dns.Record) ([]*Delta, error) {
+	var (
+		deltas  []*Delta
+		current *Delta
+	)
+	for _, r := range records {
+		switch {
+		case r.Type == _type.TypeSOA && (current == nil || current.To != nil):
+			if current != nil && serial(current.To) != serial(r) {
+				return nil, fmt.Errorf("delta from serial %d does not follow serial %d", serial(r), serial(current.To))
+			}
+			current = &Delta{From: r}
+			deltas = append(deltas, current)
+		case r.Type == _type.TypeSOA:
+			current.To = r
+		case current == nil:
+			return nil, errors.New("journal does not start with a SOA record")
+		case current.To == nil:
+			current.Removed = append(current.Removed, r)
+		default:
+			current.Added = append(current.Added, r)
+		}
+	}
+	if current != nil && current.To == nil {
+		return nil, errors.New("journal ends in the middle of a delta")
+	}
+	return deltas, nil
+}
+
+// Serial returns the serial reached by the last delta, if any
+func (j *Journal) Serial() (uint32, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.deltas) == 0 {
+		return 0, false
+	}
+	return serial(j.deltas[len(j.deltas)-1].To), true
+}
+
+// Since returns the deltas leading from serial to the latest version. It
+// reports false when the journal does not reach back to serial.
+func (j *Journal) Since(from uint32) ([]*Delta, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if n := len(j.deltas); n > 0 && serial(j.deltas[n-1].To) == from {
+		return nil, true
+	}
+	for i, d := range j.deltas {
+		if serial(d.From) == from {
+			return append([]*Delta(nil), j.deltas[i:]...), true
+		}
+	}
+	return nil, false
+}
+
+// Append records a new delta, dropping the oldest ones beyond
+// maxJournalDeltas. The journal is left as it was when writing fails.
+func (j *Journal) Append(d *Delta) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	deltas := append(j.deltas[:len(j.deltas):len(j.deltas)], d)
+	if len(deltas) > maxJournalDeltas {
+		deltas = deltas[len(deltas)-maxJournalDeltas:]
+		if err := j.rewrite(deltas); err != nil {
+			return err
+		}
+		j.deltas = deltas
+		return nil
+	}
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err = writeDeltas(f, []*Delta{d}); err != nil {
+		return err
+	}
+	j.deltas = deltas
+	return nil
+}
+
+// Reset drops every delta, when the zone changed in a way the journal cannot
+// describe
+func (j *Journal) Reset() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.deltas = nil
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// rewrite replaces the journal file with deltas
+func (j *Journal) rewrite(deltas []*Delta) error {
+	tmp := j.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = writeDeltas(f, deltas); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
+
+// writeDeltas writes deltas in master file format and closes f
+func writeDeltas(f *os.File, deltas []*Delta) error {
+	w := bufio.NewWriter(f)
+	for _, d := range deltas {
+		for _, r := range d.Records() {
+			if _, err := fmt.Fprintln(w, r); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// serial returns the serial of a SOA record
+func serial(soa *dns.Record) uint32 {
+	return soa.Data.(*dns.SOA).Serial
+}
+
+// SerialLess reports whether serial a comes before b in RFC 1982 serial
+// number arithmetic
+func SerialLess(a, b uint32) bool {
+	return a != b && b-a < 1<<31
+}
@@ -0,0 +1,176 @@
+package zone
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSerialLess(t *testing.T) {
+	for _, c := range []struct {
+		a, b uint32
+		want bool
+	}{
+		{1, 2, true},
+		{2, 1, false},
+		{7, 7, false},
+		// RFC 1982 3.2: the serial wraps around
+		{4294967295, 0, true},
+		{4294967295, 5, true},
+		{5, 4294967295, false},
+		{0, 1<<31 - 1, true},
+		// a and b half the space apart are not ordered either way
+		{0, 1 << 31, false},
+		{1 << 31, 0, false},
+	} {
+		if got := SerialLess(c.a, c.b); got != c.want {
+			t.Errorf("%d < %d: %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func soaRecord(serial uint32) *dns.Record {
+	return &dns.Record{Name: "example", Type: _type.TypeSOA, Class: _type.ClassIN, TTL: 300, Data: &dns.SOA{
+		MName: "ns.example", RName: "hostmaster.example", Serial: serial, Refresh: 3600, Retry: 600, Expire: 86400, Minimum: 60,
+	}}
+}
+
+func delta(from, to uint32, added ...*dns.Record) *Delta {
+	return &Delta{From: soaRecord(from), To: soaRecord(to), Added: added}
+}
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.jnl")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := j.Serial(); ok {
+		t.Error("new journal has a serial")
+	}
+	host := &dns.Record{Name: "host.example", Type: _type.TypeA, Class: _type.ClassIN, TTL: 300, Data: a("192.0.2.1")}
+	// The serial wraps around between the last two deltas
+	for _, d := range []*Delta{delta(4294967294, 4294967295), delta(4294967295, 0, host), delta(0, 1)} {
+		if err := j.Append(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reopened from the file, the journal holds the same deltas
+	reopened, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, j := range []*Journal{j, reopened} {
+		if last, ok := j.Serial(); !ok || last != 1 {
+			t.Errorf("journal ends at %d, %v", last, ok)
+		}
+		for _, c := range []struct {
+			from   uint32
+			deltas int
+			ok     bool
+		}{
+			{4294967294, 3, true},
+			{4294967295, 2, true},
+			{0, 1, true},
+			{1, 0, true},
+			{4294967293, 0, false},
+			{2, 0, false},
+		} {
+			deltas, ok := j.Since(c.from)
+			if len(deltas) != c.deltas || ok != c.ok {
+				t.Errorf("since %d: %d deltas, %v", c.from, len(deltas), ok)
+			}
+		}
+	}
+	if deltas, _ := reopened.Since(4294967295); len(deltas[0].Added) != 1 || deltas[0].Added[0].String() != host.String() {
+		t.Errorf("delta read back adds %v", deltas[0].Added)
+	}
+
+	if err := j.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("reset journal file: %v", err)
+	}
+}
+
+func TestJournalKeepsTheLatestDeltas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.jnl")
+	j, _ := OpenJournal(path)
+	for i := range uint32(maxJournalDeltas + 5) {
+		if err := j.Append(delta(i, i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reopened, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reopened.Since(4); ok {
+		t.Error("dropped delta still in the journal")
+	}
+	if deltas, ok := reopened.Since(5); !ok || len(deltas) != maxJournalDeltas {
+		t.Errorf("since 5: %d deltas, %v", len(deltas), ok)
+	}
+}
+
+func TestSplitDeltasErrors(t *testing.T) {
+	host := &dns.Record{Name: "host.example", Type: _type.TypeA, Class: _type.ClassIN, TTL: 300, Data: a("192.0.2.1")}
+	for name, records := range map[string][]*dns.Record{
+		"no SOA first":      {host, soaRecord(1), soaRecord(2)},
+		"unfinished delta":  {soaRecord(1), host},
+		"gap between delta": {soaRecord(1), soaRecord(2), soaRecord(3), soaRecord(4)},
+	} {
+		if _, err := SplitDeltas(records); err == nil {
+			t.Errorf("%s: split", name)
+		}
+	}
+}
+
+func TestLoadReplaysJournal(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "example.zone", "$TTL 300\n@ SOA ns hostmaster 10 3600 600 86400 60\n  NS ns\nns A 192.0.2.1\n")
+	journal := filepath.Join(dir, "example.jnl")
+	z, err := Load(file, "example.", journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := &dns.Record{Name: "host.example", Type: _type.TypeA, Class: _type.ClassIN, TTL: 300, Data: a("192.0.2.9")}
+	fresh, err := NewZone("example.", append(z.Records()[1:], soaRecord(11), host))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.Replace(fresh); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is still at serial 10, the journal brings it to 11
+	reloaded, err := Load(file, "example.", journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zoneSerial(reloaded) != 11 || len(reloaded.Lookup("host.example", _type.TypeA, false).Answer) != 1 {
+		t.Errorf("reloaded at serial %d, want 11 with the change", zoneSerial(reloaded))
+	}
+
+	// A journal the file has moved past is started afresh
+	newer := writeFile(t, dir, "newer.zone", "$TTL 300\n@ SOA ns hostmaster 50 3600 600 86400 60\n")
+	if z, err = Load(newer, "example.", journal); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := z.Changes(10); ok {
+		t.Error("changes from the old journal kept")
+	}
+}
+
+func a(ip string) dns.RData {
+	return &dns.A{IP: net.ParseIP(ip).To4()}
+}
+
+func zoneSerial(z *Zone) uint32 {
+	return z.SOA().Data.(*dns.SOA).Serial
+}
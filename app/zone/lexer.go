@@ -0,0 +1,155 @@
+package zone
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// token is a single field of a master file entry
+type token struct {
+	text   string
+	quoted bool
+}
+
+// entry is one logical line of a master file, with parentheses joined
+type entry struct {
+	tokens     []token
+	blankOwner bool
+	line       int
+}
+
+// lex splits master file content into entries, dropping comments
+func lex(data string) ([]entry, error) {
+	var (
+		entries []entry
+		current entry
+		field   strings.Builder
+		inField bool
+		depth   int
+		line    = 1
+	)
+	endField := func() {
+		if inField {
+			current.tokens = append(current.tokens, token{text: field.String()})
+			field.Reset()
+			inField = false
+		}
+	}
+	endEntry := func() {
+		if len(current.tokens) > 0 {
+			entries = append(entries, current)
+		}
+		current = entry{line: line}
+	}
+	current.line = line
+	lineStart := true
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if lineStart && depth == 0 {
+			current.blankOwner = c == ' ' || c == '\t'
+		}
+		lineStart = false
+
+		switch {
+		case c == '\n':
+			endField()
+			line++
+			if depth == 0 {
+				endEntry()
+			}
+			lineStart = true
+		case c == ' ' || c == '\t' || c == '\r':
+			endField()
+		case c == ';':
+			endField()
+			for i+1 < len(data) && data[i+1] != '\n' {
+				i++
+			}
+		case c == '(':
+			endField()
+			depth++
+		case c == ')':
+			endField()
+			if depth == 0 {
+				return nil, errors.New("line " + strconv.Itoa(line) + ": unbalanced parenthesis")
+			}
+			depth--
+		case c == '"':
+			endField()
+			var quoted strings.Builder
+			closed := false
+			for i++; i < len(data); i++ {
+				if data[i] == '\\' && i+1 < len(data) {
+					quoted.WriteByte(data[i])
+					i++
+					quoted.WriteByte(data[i])
+					continue
+				}
+				if data[i] == '"' {
+					closed = true
+					break
+				}
+				if data[i] == '\n' {
+					line++
+				}
+				quoted.WriteByte(data[i])
+			}
+			if !closed {
+				return nil, errors.New("line " + strconv.Itoa(line) + ": unterminated string")
+			}
+			current.tokens = append(current.tokens, token{text: quoted.String(), quoted: true})
+		case c == '\\' && i+1 < len(data):
+			field.WriteByte(c)
+			i++
+			field.WriteByte(data[i])
+			inField = true
+		default:
+			field.WriteByte(c)
+			inField = true
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced parenthesis at end of file")
+	}
+	endField()
+	endEntry()
+	return entries, nil
+}
+
+// unescape resolves \X and \DDD escapes
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			sb.WriteByte(s[i])
+			continue
+		}
+		if i+1 >= len(s) {
+			return "", errors.New("dangling escape in " + s)
+		}
+		if isDigit(s[i+1]) {
+			if i+3 >= len(s) || !isDigit(s[i+2]) || !isDigit(s[i+3]) {
+				return "", errors.New("invalid escape in " + s)
+			}
+			n, _ := strconv.Atoi(s[i+1 : i+4])
+			if n > 255 {
+				return "", errors.New("invalid escape in " + s)
+			}
+			sb.WriteByte(byte(n))
+			i += 3
+			continue
+		}
+		sb.WriteByte(s[i+1])
+		i++
+	}
+	return sb.String(), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
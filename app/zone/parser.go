@@ -0,0 +1,602 @@
+package zone
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth bounds nested $INCLUDE directives
+const maxIncludeDepth = 8
+
+// ParseFile reads a zone from an RFC 1035 master file
+func ParseFile(path string, origin string) (*Zone, error) {
+	records, err := ParseRecords(path, origin)
+	if err != nil {
+		return nil, err
+	}
+	return NewZone(origin, records)
+}
+
+// ParseRecords reads the records of a master file, in file order
+func ParseRecords(path string, origin string) ([]*dns.Record, error) {
+	p := &parser{
+		origin:    dns.CanonicalName(origin),
+		lastClass: _type.ClassIN,
+	}
+	if err := p.parseFile(path, 0); err != nil {
+		return nil, err
+	}
+	return p.records, nil
+}
+
+type parser struct {
+	origin        string
+	defaultTTL    uint32
+	hasDefaultTTL bool
+	lastOwner     string
+	hasLastOwner  bool
+	lastTTL       uint32
+	hasLastTTL    bool
+	lastClass     _type.RecordClass
+	records       []*dns.Record
+}
+
+func (p *parser) parseFile(path string, depth int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	entries, err := lex(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, e := range entries {
+		if err = p.parseEntry(e, path, depth); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, e.line, err)
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseEntry(e entry, path string, depth int) error {
+	first := e.tokens[0]
+	if !e.blankOwner && !first.quoted && strings.HasPrefix(first.text, "$") {
+		return p.parseDirective(e.tokens, path, depth)
+	}
+
+	fields := e.tokens
+	owner := p.lastOwner
+	if !e.blankOwner {
+		name, err := p.name(fields[0])
+		if err != nil {
+			return err
+		}
+		owner = name
+		fields = fields[1:]
+	} else if !p.hasLastOwner {
+		return errors.New("no owner name for record")
+	}
+	p.lastOwner, p.hasLastOwner = owner, true
+
+	var (
+		ttl    uint32
+		hasTTL bool
+		class  = p.lastClass
+	)
+	for len(fields) > 0 && !fields[0].quoted {
+		if c, ok := _type.ParseRecordClass(strings.ToUpper(fields[0].text)); ok {
+			class = c
+		} else if t, ok := parseTTL(fields[0].text); ok && !hasTTL {
+			ttl, hasTTL = t, true
+		} else {
+			break
+		}
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return errors.New("missing record type")
+	}
+	rrType, ok := _type.ParseRecordType(strings.ToUpper(fields[0].text))
+	if !ok {
+		return fmt.Errorf("unknown record type %q", fields[0].text)
+	}
+	data, err := p.rdata(rrType, fields[1:])
+	if err != nil {
+		return fmt.Errorf("%s record: %w", rrType, err)
+	}
+
+	switch {
+	case hasTTL:
+		p.lastTTL, p.hasLastTTL = ttl, true
+	case p.hasDefaultTTL:
+		ttl = p.defaultTTL
+	case p.hasLastTTL:
+		ttl = p.lastTTL
+	case rrType == _type.TypeSOA:
+		ttl = data.(*dns.SOA).Minimum
+	default:
+		return errors.New("no TTL specified and no $TTL in effect")
+	}
+	p.lastClass = class
+
+	p.records = append(p.records, &dns.Record{
+		Name:  owner,
+		Type:  rrType,
+		Class: class,
+		TTL:   ttl,
+		Data:  data,
+	})
+	return nil
+}
+
+func (p *parser) parseDirective(fields []token, path string, depth int) error {
+	switch strings.ToUpper(fields[0].text) {
+	case "$ORIGIN":
+		if len(fields) != 2 {
+			return errors.New("$ORIGIN takes one name")
+		}
+		origin, err := p.name(fields[1])
+		if err != nil {
+			return err
+		}
+		p.origin = origin
+	case "$TTL":
+		if len(fields) != 2 {
+			return errors.New("$TTL takes one value")
+		}
+		ttl, ok := parseTTL(fields[1].text)
+		if !ok {
+			return fmt.Errorf("invalid TTL %q", fields[1].text)
+		}
+		p.defaultTTL, p.hasDefaultTTL = ttl, true
+	case "$INCLUDE":
+		if len(fields) < 2 || len(fields) > 3 {
+			return errors.New("$INCLUDE takes a file name and an optional origin")
+		}
+		if depth >= maxIncludeDepth {
+			return errors.New("$INCLUDE nested too deeply")
+		}
+		file := fields[1].text
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		// The origin set by or within an included file does not leak back (RFC 1035 5.1)
+		saved := p.origin
+		if len(fields) == 3 {
+			origin, err := p.name(fields[2])
+			if err != nil {
+				return err
+			}
+			p.origin = origin
+		}
+		err := p.parseFile(file, depth+1)
+		p.origin = saved
+		return err
+	default:
+		return fmt.Errorf("unknown directive %s", fields[0].text)
+	}
+	return nil
+}
+
+// name resolves a possibly relative domain name against the current origin
+func (p *parser) name(t token) (string, error) {
+	text := t.text
+	if text == "@" {
+		return p.origin, nil
+	}
+	absolute := strings.HasSuffix(text, ".") && !strings.HasSuffix(text, `\.`)
+	if absolute {
+		text = strings.TrimSuffix(text, ".")
+	}
+	var labels []string
+	if text != "" {
+		for _, label := range splitEscaped(text) {
+			unescaped, err := unescape(label)
+			if err != nil {
+				return "", err
+			}
+			if unescaped == "" || len(unescaped) > 63 {
+				return "", fmt.Errorf("invalid label in %q", t.text)
+			}
+			if strings.Contains(unescaped, ".") {
+				return "", fmt.Errorf("escaped dots are not supported in %q", t.text)
+			}
+			labels = append(labels, unescaped)
+		}
+	}
+	name := strings.Join(labels, ".")
+	if !absolute && p.origin != "" {
+		if name == "" {
+			return p.origin, nil
+		}
+		name += "." + p.origin
+	}
+	if len(name) > 253 {
+		return "", fmt.Errorf("name too long: %q", t.text)
+	}
+	return name, nil
+}
+
+// splitEscaped splits a name on the dots that are not escaped
+func splitEscaped(s string) []string {
+	var labels []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '.':
+			labels = append(labels, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(labels, s[start:])
+}
+
+func (p *parser) rdata(rrType _type.RecordType, fields []token) (dns.RData, error) {
+	if len(fields) > 0 && !fields[0].quoted && fields[0].text == `\#` {
+		return p.genericRData(rrType, fields[1:])
+	}
+	r := &rdataReader{parser: p, fields: fields}
+	var data dns.RData
+	switch rrType {
+	case _type.TypeA:
+		ip := net.ParseIP(r.next()).To4()
+		if ip == nil {
+			return nil, errors.New("invalid IPv4 address")
+		}
+		data = &dns.A{IP: ip}
+	case _type.TypeAAAA:
+		ip := net.ParseIP(r.next())
+		if ip == nil || ip.To4() != nil {
+			return nil, errors.New("invalid IPv6 address")
+		}
+		data = &dns.AAAA{IP: ip}
+	case _type.TypeNS:
+		data = &dns.NS{Host: r.name()}
+	case _type.TypeCNAME:
+		data = &dns.CNAME{Target: r.name()}
+	case _type.TypeDNAME:
+		data = &dns.DNAME{Target: r.name()}
+	case _type.TypePTR:
+		data = &dns.PTR{Target: r.name()}
+	case _type.TypeMX:
+		data = &dns.MX{Preference: r.uint16(), Exchange: r.name()}
+	case _type.TypeSOA:
+		data = &dns.SOA{
+			MName:   r.name(),
+			RName:   r.name(),
+			Serial:  r.uint32(),
+			Refresh: r.ttl(),
+			Retry:   r.ttl(),
+			Expire:  r.ttl(),
+			Minimum: r.ttl(),
+		}
+	case _type.TypeTXT:
+		txt := &dns.TXT{}
+		for len(r.fields) > 0 && r.err == nil {
+			txt.Strings = append(txt.Strings, r.characterString())
+		}
+		if len(txt.Strings) == 0 {
+			return nil, errors.New("missing text")
+		}
+		data = txt
+	case _type.TypeHINFO:
+		data = &dns.HINFO{CPU: r.characterString(), OS: r.characterString()}
+	case _type.TypeMINFO:
+		data = &dns.MINFO{RMailBx: r.name(), EMailBx: r.name()}
+	case _type.TypeSRV:
+		data = &dns.SRV{Priority: r.uint16(), Weight: r.uint16(), Port: r.uint16(), Target: r.name()}
+	case _type.TypeCAA:
+		data = &dns.CAA{Flag: r.uint8(), Tag: r.next(), Value: r.characterString()}
+	case _type.TypeDS:
+		data = &dns.DS{KeyTag: r.uint16(), Algorithm: r.uint8(), DigestType: r.uint8(), Digest: r.hex()}
+	case _type.TypeDNSKEY:
+		data = &dns.DNSKEY{Flags: r.uint16(), Protocol: r.uint8(), Algorithm: r.uint8(), PublicKey: r.base64()}
+	case _type.TypeRRSIG:
+		data = &dns.RRSIG{
+			TypeCovered: r.recordType(),
+			Algorithm:   r.uint8(),
+			Labels:      r.uint8(),
+			OriginalTTL: r.uint32(),
+			Expiration:  r.signatureTime(),
+			Inception:   r.signatureTime(),
+			KeyTag:      r.uint16(),
+			SignerName:  r.name(),
+			Signature:   r.base64(),
+		}
+	case _type.TypeNSEC:
+		data = &dns.NSEC{NextDomain: r.name(), Types: r.types()}
+	case _type.TypeNSEC3:
+		data = &dns.NSEC3{
+			HashAlgorithm: r.uint8(),
+			Flags:         r.uint8(),
+			Iterations:    r.uint16(),
+			Salt:          r.salt(),
+			NextHashed:    r.base32Hex(),
+			Types:         r.types(),
+		}
+	case _type.TypeNSEC3PARAM:
+		data = &dns.NSEC3PARAM{HashAlgorithm: r.uint8(), Flags: r.uint8(), Iterations: r.uint16(), Salt: r.salt()}
+	default:
+		return nil, errors.New(`unsupported type, use the \# generic format`)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.fields) > 0 {
+		return nil, fmt.Errorf("unexpected %q", r.fields[0].text)
+	}
+	return data, nil
+}
+
+// genericRData parses the RFC 3597 form: \# <length> <hex>...
+func (p *parser) genericRData(rrType _type.RecordType, fields []token) (dns.RData, error) {
+	if dns.IsKnownType(rrType) {
+		return nil, errors.New(`the \# generic format is only supported for unknown types`)
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("missing rdata length")
+	}
+	length, err := strconv.Atoi(fields[0].text)
+	if err != nil || length < 0 || length > 0xFFFF {
+		return nil, fmt.Errorf("invalid rdata length %q", fields[0].text)
+	}
+	var hexData strings.Builder
+	for _, f := range fields[1:] {
+		hexData.WriteString(f.text)
+	}
+	raw, err := hex.DecodeString(hexData.String())
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != length {
+		return nil, fmt.Errorf("rdata is %d bytes, expected %d", len(raw), length)
+	}
+	return &dns.Unknown{Data: raw}, nil
+}
+
+// rdataReader consumes rdata fields, keeping the first error
+type rdataReader struct {
+	parser *parser
+	fields []token
+	err    error
+}
+
+func (r *rdataReader) next() string {
+	if r.err != nil {
+		return ""
+	}
+	if len(r.fields) == 0 {
+		r.err = errors.New("missing rdata field")
+		return ""
+	}
+	t := r.fields[0]
+	r.fields = r.fields[1:]
+	return t.text
+}
+
+func (r *rdataReader) name() string {
+	if r.err != nil {
+		return ""
+	}
+	if len(r.fields) == 0 {
+		r.err = errors.New("missing name")
+		return ""
+	}
+	name, err := r.parser.name(r.fields[0])
+	r.fields = r.fields[1:]
+	if err != nil {
+		r.err = err
+	}
+	return name
+}
+
+func (r *rdataReader) number(bits int) uint64 {
+	text := r.next()
+	if r.err != nil {
+		return 0
+	}
+	n, err := strconv.ParseUint(text, 10, bits)
+	if err != nil {
+		r.err = fmt.Errorf("invalid number %q", text)
+	}
+	return n
+}
+
+func (r *rdataReader) uint8() uint8 {
+	return uint8(r.number(8))
+}
+
+func (r *rdataReader) uint16() uint16 {
+	return uint16(r.number(16))
+}
+
+func (r *rdataReader) uint32() uint32 {
+	return uint32(r.number(32))
+}
+
+func (r *rdataReader) ttl() uint32 {
+	text := r.next()
+	if r.err != nil {
+		return 0
+	}
+	ttl, ok := parseTTL(text)
+	if !ok {
+		r.err = fmt.Errorf("invalid time value %q", text)
+	}
+	return ttl
+}
+
+func (r *rdataReader) characterString() string {
+	text := r.next()
+	if r.err != nil {
+		return ""
+	}
+	s, err := unescape(text)
+	if err != nil {
+		r.err = err
+		return ""
+	}
+	if len(s) > 255 {
+		r.err = errors.New("character string longer than 255 bytes")
+	}
+	return s
+}
+
+// rest joins the remaining fields, as base64 and hex data may be split
+// across several
+func (r *rdataReader) rest() string {
+	if r.err != nil {
+		return ""
+	}
+	if len(r.fields) == 0 {
+		r.err = errors.New("missing rdata field")
+		return ""
+	}
+	var sb strings.Builder
+	for _, f := range r.fields {
+		sb.WriteString(f.text)
+	}
+	r.fields = nil
+	return sb.String()
+}
+
+func (r *rdataReader) hex() []byte {
+	text := r.rest()
+	if r.err != nil {
+		return nil
+	}
+	data, err := hex.DecodeString(text)
+	if err != nil {
+		r.err = fmt.Errorf("invalid hex data %q", text)
+	}
+	return data
+}
+
+func (r *rdataReader) base64() []byte {
+	text := r.rest()
+	if r.err != nil {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		r.err = fmt.Errorf("invalid base64 data %q", text)
+	}
+	return data
+}
+
+// salt reads the hex salt of NSEC3 records, "-" for none
+func (r *rdataReader) salt() []byte {
+	text := r.next()
+	if r.err != nil || text == "-" {
+		return nil
+	}
+	data, err := hex.DecodeString(text)
+	if err != nil {
+		r.err = fmt.Errorf("invalid salt %q", text)
+	}
+	return data
+}
+
+func (r *rdataReader) base32Hex() []byte {
+	text := r.next()
+	if r.err != nil {
+		return nil
+	}
+	data, err := dns.Base32Hex.DecodeString(strings.ToUpper(text))
+	if err != nil {
+		r.err = fmt.Errorf("invalid hashed name %q", text)
+	}
+	return data
+}
+
+func (r *rdataReader) recordType() _type.RecordType {
+	text := r.next()
+	if r.err != nil {
+		return 0
+	}
+	t, ok := _type.ParseRecordType(strings.ToUpper(text))
+	if !ok {
+		r.err = fmt.Errorf("unknown type %q", text)
+	}
+	return t
+}
+
+// types reads the type list of NSEC and NSEC3 records, which may be empty
+func (r *rdataReader) types() []_type.RecordType {
+	var types []_type.RecordType
+	for len(r.fields) > 0 && r.err == nil {
+		types = append(types, r.recordType())
+	}
+	return types
+}
+
+func (r *rdataReader) signatureTime() uint32 {
+	text := r.next()
+	if r.err != nil {
+		return 0
+	}
+	t, err := dns.ParseSignatureTime(text)
+	if err != nil {
+		r.err = err
+	}
+	return t
+}
+
+// parseTTL parses a TTL given in seconds or with units, such as 1h30m
+func parseTTL(s string) (uint32, bool) {
+	if s == "" || !isDigit(s[0]) {
+		return 0, false
+	}
+	var total, current uint64
+	hasUnit := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isDigit(c) {
+			current = current*10 + uint64(c-'0')
+			if current > 0xFFFFFFFF {
+				return 0, false
+			}
+			continue
+		}
+		var unit uint64
+		switch c | 0x20 {
+		case 's':
+			unit = 1
+		case 'm':
+			unit = 60
+		case 'h':
+			unit = 3600
+		case 'd':
+			unit = 86400
+		case 'w':
+			unit = 604800
+		default:
+			return 0, false
+		}
+		if i == 0 || !isDigit(s[i-1]) {
+			return 0, false
+		}
+		total += current * unit
+		current = 0
+		hasUnit = true
+	}
+	if hasUnit && isDigit(s[len(s)-1]) {
+		return 0, false
+	}
+	total += current
+	if total > 0xFFFFFFFF {
+		return 0, false
+	}
+	return uint32(total), true
+}
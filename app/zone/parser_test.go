@@ -0,0 +1,147 @@
+package zone
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseRecords(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "hosts.inc", `
+host   A     192.0.2.10
+`)
+	path := writeFile(t, dir, "example.zone", `
+$TTL 1h
+@       IN SOA ns1 hostmaster (
+                2024010101 ; serial
+                3600       ; refresh
+                600 86400
+                300 )
+        NS     ns1
+        NS     ns2.example.net.
+        MX     10 mail
+ns1     A      192.0.2.1
+        AAAA   2001:db8::1
+mail 300 A     192.0.2.2
+www     CNAME  @
+txt     TXT    "two words" plain "semi;colon"
+$ORIGIN sub.example.
+deep    A      192.0.2.4
+$INCLUDE hosts.inc lan.example.
+after   A      192.0.2.5
+`)
+	records, err := ParseRecords(path, "example.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range records {
+		got = append(got, strings.Join(strings.Fields(r.String()), " "))
+	}
+	want := []string{
+		"example. 3600 IN SOA ns1.example. hostmaster.example. 2024010101 3600 600 86400 300",
+		"example. 3600 IN NS ns1.example.",
+		"example. 3600 IN NS ns2.example.net.",
+		"example. 3600 IN MX 10 mail.example.",
+		"ns1.example. 3600 IN A 192.0.2.1",
+		"ns1.example. 3600 IN AAAA 2001:db8::1",
+		"mail.example. 300 IN A 192.0.2.2",
+		"www.example. 3600 IN CNAME example.",
+		`txt.example. 3600 IN TXT "two words" "plain" "semi;colon"`,
+		"deep.sub.example. 3600 IN A 192.0.2.4",
+		"host.lan.example. 3600 IN A 192.0.2.10",
+		"after.sub.example. 3600 IN A 192.0.2.5",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("records:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseRecordsErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"no TTL":            "example. IN A 192.0.2.1\n",
+		"no owner":          "  3600 IN A 192.0.2.1\n",
+		"unknown type":      "example. 3600 IN BOGUS data\n",
+		"bad address":       "example. 3600 IN A 192.0.2\n",
+		"open parenthesis":  "example. 3600 IN SOA ns hm ( 1 2 3 4 5\n",
+		"unknown directive": "$GENERATE 1-2 host$ A 192.0.2.$\n",
+		"include loop":      "$INCLUDE loop.zone\n",
+		"escaped dot":       "esc\\.aped 3600 IN A 192.0.2.1\n",
+	} {
+		path := writeFile(t, dir, "loop.zone", data)
+		if _, err := ParseRecords(path, "example."); err == nil {
+			t.Errorf("%s: parsed", name)
+		} else if !strings.Contains(err.Error(), "loop.zone:") {
+			t.Errorf("%s: error %q does not name the file", name, err)
+		}
+	}
+}
+
+func TestParseFileLookup(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "example.zone", `
+$TTL 300
+@        SOA   ns hostmaster 1 3600 600 86400 60
+         NS    ns
+ns       A     192.0.2.1
+www      A     192.0.2.2
+alias    CNAME www
+*.wild   TXT   "any"
+child    NS    ns.child
+ns.child A     192.0.2.53
+`)
+	z, err := ParseFile(path, "example.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name   string
+		qtype  _type.RecordType
+		rcode  _type.ResponseCode
+		aa     bool
+		answer []_type.RecordType
+		auth   []_type.RecordType
+	}{
+		{"www.example", _type.TypeA, _type.RCodeNoError, true, []_type.RecordType{_type.TypeA}, nil},
+		{"alias.example", _type.TypeA, _type.RCodeNoError, true, []_type.RecordType{_type.TypeCNAME, _type.TypeA}, nil},
+		{"host.wild.example", _type.TypeTXT, _type.RCodeNoError, true, []_type.RecordType{_type.TypeTXT}, nil},
+		{"www.example", _type.TypeMX, _type.RCodeNoError, true, nil, []_type.RecordType{_type.TypeSOA}},
+		{"missing.example", _type.TypeA, _type.RCodeNXDomain, true, nil, []_type.RecordType{_type.TypeSOA}},
+		{"host.child.example", _type.TypeA, _type.RCodeNoError, false, nil, []_type.RecordType{_type.TypeNS}},
+	} {
+		res := z.Lookup(c.name, c.qtype, false)
+		if res.RCode != c.rcode || res.Authoritative != c.aa ||
+			!sameTypes(res.Answer, c.answer) || !sameTypes(res.Authority, c.auth) {
+			t.Errorf("%s %s: rcode %s, aa %v, answer %v, authority %v",
+				c.name, c.qtype, res.RCode, res.Authoritative, res.Answer, res.Authority)
+		}
+	}
+	if res := z.Lookup("host.child.example", _type.TypeA, false); len(res.Additional) != 1 {
+		t.Errorf("referral with %d glue records, want 1", len(res.Additional))
+	}
+}
+
+func sameTypes(records []*dns.Record, types []_type.RecordType) bool {
+	if len(records) != len(types) {
+		return false
+	}
+	for i, r := range records {
+		if r.Type != types[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package zone
+
+import (
+	"com.sentry.dev/app/dns"
+	"sync"
+)
+
+// Store holds the zones served authoritatively, by origin
+type Store struct {
+	mu    sync.RWMutex
+	zones map[string]*Zone
+}
+
+func NewStore() *Store {
+	return &Store{zones: make(map[string]*Zone)}
+}
+
+// Put adds a zone, replacing any zone with the same origin
+func (s *Store) Put(z *Zone) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zones[z.Origin] = z
+}
+
+// Delete stops serving the zone with the given origin
+func (s *Store) Delete(origin string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.zones, dns.CanonicalName(origin))
+}
+
+// Get returns the zone with exactly the given origin
+func (s *Store) Get(origin string) *Zone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.zones[dns.CanonicalName(origin)]
+}
+
+// Find returns the most specific zone containing name, or nil
+func (s *Store) Find(name string) *Zone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.zones) == 0 {
+		return nil
+	}
+	name = dns.CanonicalName(name)
+	for {
+		if z, ok := s.zones[name]; ok {
+			return z
+		}
+		if name == "" {
+			return nil
+		}
+		name = dns.Parent(name)
+	}
+}
+
+// Zones returns every zone of the store
+func (s *Store) Zones() []*Zone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	zones := make([]*Zone, 0, len(s.zones))
+	for _, z := range s.zones {
+		zones = append(zones, z)
+	}
+	return zones
+}
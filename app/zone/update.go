@@ -0,0 +1,236 @@
+package zone
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+)
+
+// Update applies a dynamic update (RFC 2136) to the zone: the prerequisites
+// are checked, then the updates applied all at once. The new version, with
+// its serial increased unless the update set one, is recorded in the
+// journal. The zone itself is returned when the update changed nothing or
+// failed with the returned response code.
+func (z *Zone) Update(prerequisites, updates []*dns.Record) (*Zone, _type.ResponseCode, error) {
+	records := z.Records()
+	soa := records[0]
+	work := make(map[string]rrsets)
+	for _, r := range records {
+		name := dns.CanonicalName(r.Name)
+		if work[name] == nil {
+			work[name] = make(rrsets)
+		}
+		work[name][r.Type] = append(work[name][r.Type], r)
+	}
+
+	if rcode := z.checkPrerequisites(work, prerequisites, soa.Class); rcode != _type.RCodeNoError {
+		return z, rcode, nil
+	}
+	if rcode := z.prescan(updates, soa.Class); rcode != _type.RCodeNoError {
+		return z, rcode, nil
+	}
+	for _, r := range updates {
+		z.applyUpdate(work, r, soa.Class)
+	}
+
+	fresh, err := z.fromSets(work)
+	if err != nil {
+		return z, _type.RCodeServFail, err
+	}
+	d := diff(z, fresh)
+	if len(d.Removed) == 0 && len(d.Added) == 0 && serial(d.From) == serial(d.To) {
+		return z, _type.RCodeNoError, nil
+	}
+	if !SerialLess(serial(soa), serial(fresh.SOA())) {
+		bumped := *fresh.SOA()
+		data := *bumped.Data.(*dns.SOA)
+		data.Serial++
+		bumped.Data = &data
+		work[z.Origin][_type.TypeSOA] = []*dns.Record{&bumped}
+		if fresh, err = z.fromSets(work); err != nil {
+			return z, _type.RCodeServFail, err
+		}
+	}
+	if fresh, err = z.Replace(fresh); err != nil {
+		return z, _type.RCodeServFail, err
+	}
+	return fresh, _type.RCodeNoError, nil
+}
+
+// checkPrerequisites evaluates the prerequisite section (RFC 2136 3.2)
+func (z *Zone) checkPrerequisites(work map[string]rrsets, prerequisites []*dns.Record, class _type.RecordClass) _type.ResponseCode {
+	// Value-dependent prerequisites must match whole RRsets
+	expected := make(map[string]rrsets)
+	for _, r := range prerequisites {
+		if r.TTL != 0 {
+			return _type.RCodeFormErr
+		}
+		name := dns.CanonicalName(r.Name)
+		if !dns.IsSubDomain(name, z.Origin) {
+			return _type.RCodeNotZone
+		}
+		sets := work[name]
+		switch r.Class {
+		case _type.ClassANY:
+			if !isEmpty(r) {
+				return _type.RCodeFormErr
+			}
+			if r.Type == _type.TypeANY && len(sets) == 0 {
+				return _type.RCodeNXDomain
+			}
+			if r.Type != _type.TypeANY && len(sets[r.Type]) == 0 {
+				return _type.RCodeNXRRSet
+			}
+		case _type.ClassNONE:
+			if !isEmpty(r) {
+				return _type.RCodeFormErr
+			}
+			if r.Type == _type.TypeANY && len(sets) > 0 {
+				return _type.RCodeYXDomain
+			}
+			if r.Type != _type.TypeANY && len(sets[r.Type]) > 0 {
+				return _type.RCodeYXRRSet
+			}
+		case class:
+			if expected[name] == nil {
+				expected[name] = make(rrsets)
+			}
+			expected[name][r.Type] = append(expected[name][r.Type], r)
+		default:
+			return _type.RCodeFormErr
+		}
+	}
+	for name, sets := range expected {
+		for t, set := range sets {
+			if !sameData(set, work[name][t]) {
+				return _type.RCodeNXRRSet
+			}
+		}
+	}
+	return _type.RCodeNoError
+}
+
+// prescan validates the update section before anything is applied
+// (RFC 2136 3.4.1)
+func (z *Zone) prescan(updates []*dns.Record, class _type.RecordClass) _type.ResponseCode {
+	for _, r := range updates {
+		if !dns.IsSubDomain(r.Name, z.Origin) {
+			return _type.RCodeNotZone
+		}
+		meta := r.Type == _type.TypeAXFR || r.Type == _type.TypeIXFR
+		switch r.Class {
+		case class:
+			if meta || r.Type == _type.TypeANY || isEmpty(r) {
+				return _type.RCodeFormErr
+			}
+		case _type.ClassANY:
+			if r.TTL != 0 || !isEmpty(r) || meta {
+				return _type.RCodeFormErr
+			}
+		case _type.ClassNONE:
+			if r.TTL != 0 || meta || r.Type == _type.TypeANY {
+				return _type.RCodeFormErr
+			}
+		default:
+			return _type.RCodeFormErr
+		}
+	}
+	return _type.RCodeNoError
+}
+
+// applyUpdate applies one record of the update section (RFC 2136 3.4.2).
+// The SOA and NS records at the apex cannot be deleted this way.
+func (z *Zone) applyUpdate(work map[string]rrsets, r *dns.Record, class _type.RecordClass) {
+	name := dns.CanonicalName(r.Name)
+	apex := name == z.Origin
+	sets := work[name]
+
+	switch r.Class {
+	case class:
+		if sets == nil {
+			sets = make(rrsets)
+			work[name] = sets
+		}
+		switch {
+		case r.Type == _type.TypeCNAME && len(sets) > 0 && len(sets[_type.TypeCNAME]) == 0:
+		case r.Type != _type.TypeCNAME && len(sets[_type.TypeCNAME]) > 0:
+		case r.Type == _type.TypeSOA:
+			if apex && SerialLess(serial(sets[_type.TypeSOA][0]), r.Data.(*dns.SOA).Serial) {
+				sets[_type.TypeSOA] = []*dns.Record{r}
+			}
+		case r.Type == _type.TypeCNAME:
+			sets[_type.TypeCNAME] = []*dns.Record{r}
+		default:
+			sets[r.Type] = append(removeData(sets[r.Type], r), r)
+		}
+	case _type.ClassANY:
+		if r.Type != _type.TypeANY {
+			if !apex || (r.Type != _type.TypeSOA && r.Type != _type.TypeNS) {
+				delete(sets, r.Type)
+			}
+			break
+		}
+		for t := range sets {
+			if !apex || (t != _type.TypeSOA && t != _type.TypeNS) {
+				delete(sets, t)
+			}
+		}
+	case _type.ClassNONE:
+		if apex && r.Type == _type.TypeSOA {
+			break
+		}
+		remaining := removeData(sets[r.Type], r)
+		if apex && r.Type == _type.TypeNS && len(remaining) == 0 {
+			break
+		}
+		if len(remaining) == 0 {
+			delete(sets, r.Type)
+		} else {
+			sets[r.Type] = remaining
+		}
+	}
+	if len(sets) == 0 {
+		delete(work, name)
+	}
+}
+
+// fromSets builds the next version of the zone from its records by name
+func (z *Zone) fromSets(work map[string]rrsets) (*Zone, error) {
+	var records []*dns.Record
+	for _, sets := range work {
+		for _, set := range sets {
+			records = append(records, set...)
+		}
+	}
+	return NewZone(z.Origin, records)
+}
+
+// isEmpty reports whether a record carries no RDATA, as dynamic update
+// uses to name whole RRsets
+func isEmpty(r *dns.Record) bool {
+	unknown, ok := r.Data.(*dns.Unknown)
+	return ok && len(unknown.Data) == 0
+}
+
+// sameData reports whether two RRsets hold the same data, TTLs aside
+func sameData(a, b []*dns.Record) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, r := range a {
+		if len(removeData(b, r)) != len(b)-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// removeData returns set without the records holding the data of r
+func removeData(set []*dns.Record, r *dns.Record) []*dns.Record {
+	var kept []*dns.Record
+	for _, existing := range set {
+		if existing.Data.String() != r.Data.String() {
+			kept = append(kept, existing)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,192 @@
+package zone
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"path/filepath"
+	"testing"
+)
+
+const updateZone = `
+$TTL 300
+@      SOA   ns hostmaster 10 3600 600 86400 60
+       NS    ns
+ns     A     192.0.2.1
+www    A     192.0.2.2
+       A     192.0.2.3
+alias  CNAME www
+`
+
+// loadUpdateZone loads updateZone with a journal, returning the path of the
+// journal
+func loadUpdateZone(t *testing.T) (*Zone, string) {
+	t.Helper()
+	dir := t.TempDir()
+	journal := filepath.Join(dir, "example.jnl")
+	z, err := Load(writeFile(t, dir, "example.zone", updateZone), "example.", journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z, journal
+}
+
+func rr(name string, class _type.RecordClass, rrType _type.RecordType, ttl uint32, data dns.RData) *dns.Record {
+	if data == nil {
+		data = &dns.Unknown{}
+	}
+	return &dns.Record{Name: name, Type: rrType, Class: class, TTL: ttl, Data: data}
+}
+
+func TestUpdatePrerequisites(t *testing.T) {
+	z, _ := loadUpdateZone(t)
+	for _, c := range []struct {
+		name          string
+		prerequisites []*dns.Record
+		want          _type.ResponseCode
+	}{
+		{"name in use", []*dns.Record{rr("www.example", _type.ClassANY, _type.TypeANY, 0, nil)}, _type.RCodeNoError},
+		{"name in use, missing", []*dns.Record{rr("missing.example", _type.ClassANY, _type.TypeANY, 0, nil)}, _type.RCodeNXDomain},
+		{"name not in use", []*dns.Record{rr("missing.example", _type.ClassNONE, _type.TypeANY, 0, nil)}, _type.RCodeNoError},
+		{"name not in use, present", []*dns.Record{rr("WWW.example.", _type.ClassNONE, _type.TypeANY, 0, nil)}, _type.RCodeYXDomain},
+		{"RRset exists", []*dns.Record{rr("www.example", _type.ClassANY, _type.TypeA, 0, nil)}, _type.RCodeNoError},
+		{"RRset exists, missing", []*dns.Record{rr("www.example", _type.ClassANY, _type.TypeMX, 0, nil)}, _type.RCodeNXRRSet},
+		{"RRset does not exist", []*dns.Record{rr("www.example", _type.ClassNONE, _type.TypeMX, 0, nil)}, _type.RCodeNoError},
+		{"RRset does not exist, present", []*dns.Record{rr("www.example", _type.ClassNONE, _type.TypeA, 0, nil)}, _type.RCodeYXRRSet},
+		{"RRset exists with its values", []*dns.Record{
+			rr("www.example", _type.ClassIN, _type.TypeA, 0, a("192.0.2.3")),
+			rr("www.example", _type.ClassIN, _type.TypeA, 0, a("192.0.2.2")),
+		}, _type.RCodeNoError},
+		{"RRset exists with part of its values", []*dns.Record{
+			rr("www.example", _type.ClassIN, _type.TypeA, 0, a("192.0.2.2")),
+		}, _type.RCodeNXRRSet},
+		{"RRset exists with other values", []*dns.Record{
+			rr("www.example", _type.ClassIN, _type.TypeA, 0, a("192.0.2.2")),
+			rr("www.example", _type.ClassIN, _type.TypeA, 0, a("192.0.2.3")),
+			rr("www.example", _type.ClassIN, _type.TypeA, 0, a("192.0.2.4")),
+		}, _type.RCodeNXRRSet},
+		{"outside of the zone", []*dns.Record{rr("www.example.net", _type.ClassANY, _type.TypeANY, 0, nil)}, _type.RCodeNotZone},
+		{"with a TTL", []*dns.Record{rr("www.example", _type.ClassANY, _type.TypeA, 300, nil)}, _type.RCodeFormErr},
+		{"with data", []*dns.Record{rr("www.example", _type.ClassANY, _type.TypeA, 0, a("192.0.2.2"))}, _type.RCodeFormErr},
+	} {
+		// A failed prerequisite leaves the zone as it was
+		add := []*dns.Record{rr("new.example", _type.ClassIN, _type.TypeA, 300, a("192.0.2.9"))}
+		updated, rcode, err := z.Update(c.prerequisites, add)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rcode != c.want {
+			t.Errorf("%s: %s, want %s", c.name, rcode, c.want)
+		}
+		if changed := updated != z; changed != (c.want == _type.RCodeNoError) {
+			t.Errorf("%s: zone changed %v", c.name, changed)
+		}
+	}
+}
+
+func TestUpdatePrescan(t *testing.T) {
+	z, _ := loadUpdateZone(t)
+	for _, c := range []struct {
+		name   string
+		update *dns.Record
+		want   _type.ResponseCode
+	}{
+		{"outside of the zone", rr("www.example.net", _type.ClassIN, _type.TypeA, 300, a("192.0.2.9")), _type.RCodeNotZone},
+		{"add without data", rr("www.example", _type.ClassIN, _type.TypeA, 300, nil), _type.RCodeFormErr},
+		{"add of type ANY", rr("www.example", _type.ClassIN, _type.TypeANY, 300, a("192.0.2.9")), _type.RCodeFormErr},
+		{"delete of an RRset with data", rr("www.example", _type.ClassANY, _type.TypeA, 0, a("192.0.2.2")), _type.RCodeFormErr},
+		{"delete with a TTL", rr("www.example", _type.ClassNONE, _type.TypeA, 300, a("192.0.2.2")), _type.RCodeFormErr},
+		{"transfer type", rr("www.example", _type.ClassANY, _type.TypeAXFR, 0, nil), _type.RCodeFormErr},
+		{"unknown class", rr("www.example", _type.RecordClass(3), _type.TypeA, 300, a("192.0.2.9")), _type.RCodeFormErr},
+	} {
+		// Nothing is applied when one update is wrong
+		updates := []*dns.Record{rr("new.example", _type.ClassIN, _type.TypeA, 300, a("192.0.2.9")), c.update}
+		updated, rcode, err := z.Update(nil, updates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rcode != c.want || updated != z {
+			t.Errorf("%s: %s, want %s, zone changed %v", c.name, rcode, c.want, updated != z)
+		}
+	}
+}
+
+func TestUpdateApply(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		updates []*dns.Record
+		// the A records of www and new, and whether the apex keeps its NS
+		www, new int
+		apexNS   bool
+		serial   uint32
+	}{
+		{"add", []*dns.Record{rr("www.example", _type.ClassIN, _type.TypeA, 300, a("192.0.2.4"))}, 3, 0, true, 11},
+		{"add what is there", []*dns.Record{rr("www.example", _type.ClassIN, _type.TypeA, 300, a("192.0.2.2"))}, 2, 0, true, 10},
+		{"add a name", []*dns.Record{rr("new.example", _type.ClassIN, _type.TypeA, 300, a("192.0.2.9"))}, 2, 1, true, 11},
+		{"delete a record", []*dns.Record{rr("www.example", _type.ClassNONE, _type.TypeA, 0, a("192.0.2.2"))}, 1, 0, true, 11},
+		{"delete an RRset", []*dns.Record{rr("www.example", _type.ClassANY, _type.TypeA, 0, nil)}, 0, 0, true, 11},
+		{"delete a name", []*dns.Record{rr("www.example", _type.ClassANY, _type.TypeANY, 0, nil)}, 0, 0, true, 11},
+		{"delete then add", []*dns.Record{
+			rr("www.example", _type.ClassANY, _type.TypeA, 0, nil),
+			rr("www.example", _type.ClassIN, _type.TypeA, 300, a("192.0.2.9")),
+		}, 1, 0, true, 11},
+		// The SOA and NS of the apex cannot be deleted
+		{"delete the apex", []*dns.Record{rr("example", _type.ClassANY, _type.TypeANY, 0, nil)}, 2, 0, true, 10},
+		{"delete the apex NS", []*dns.Record{rr("example", _type.ClassNONE, _type.TypeNS, 0, &dns.NS{Host: "ns.example"})}, 2, 0, true, 10},
+		// An alias cannot get other data
+		{"add to an alias", []*dns.Record{rr("alias.example", _type.ClassIN, _type.TypeA, 300, a("192.0.2.9"))}, 2, 0, true, 10},
+		// A serial set by the update is kept, an older one ignored
+		{"set the serial", []*dns.Record{rr("example", _type.ClassIN, _type.TypeSOA, 300, &dns.SOA{
+			MName: "ns.example", RName: "hostmaster.example", Serial: 20, Refresh: 3600, Retry: 600, Expire: 86400, Minimum: 60,
+		})}, 2, 0, true, 20},
+		{"set an older serial", []*dns.Record{rr("example", _type.ClassIN, _type.TypeSOA, 300, &dns.SOA{
+			MName: "ns.example", RName: "hostmaster.example", Serial: 9, Refresh: 3600, Retry: 600, Expire: 86400, Minimum: 60,
+		})}, 2, 0, true, 10},
+	} {
+		z, _ := loadUpdateZone(t)
+		updated, rcode, err := z.Update(nil, c.updates)
+		if err != nil || rcode != _type.RCodeNoError {
+			t.Fatalf("%s: %s, %v", c.name, rcode, err)
+		}
+		www := updated.Lookup("www.example", _type.TypeA, false)
+		added := updated.Lookup("new.example", _type.TypeA, false)
+		ns := updated.Lookup("example", _type.TypeNS, false)
+		if len(www.Answer) != c.www || len(added.Answer) != c.new || (len(ns.Answer) > 0) != c.apexNS || zoneSerial(updated) != c.serial {
+			t.Errorf("%s: www %d, new %d, apex NS %d, serial %d", c.name, len(www.Answer), len(added.Answer), len(ns.Answer), zoneSerial(updated))
+		}
+	}
+}
+
+func TestUpdateJournal(t *testing.T) {
+	z, path := loadUpdateZone(t)
+	updated, _, err := z.Update(nil, []*dns.Record{rr("www.example", _type.ClassNONE, _type.TypeA, 0, a("192.0.2.2"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, _, err = updated.Update(nil, []*dns.Record{rr("new.example", _type.ClassIN, _type.TypeA, 300, a("192.0.2.9"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deltas, ok := updated.Changes(10)
+	if !ok || len(deltas) != 2 {
+		t.Fatalf("changes since 10: %d, %v", len(deltas), ok)
+	}
+	if first := deltas[0]; serial(first.From) != 10 || serial(first.To) != 11 || len(first.Removed) != 1 || len(first.Added) != 0 {
+		t.Errorf("first delta %d to %d, %d removed, %d added", serial(first.From), serial(first.To), len(first.Removed), len(first.Added))
+	}
+	if second := deltas[1]; serial(second.From) != 11 || serial(second.To) != 12 || len(second.Removed) != 0 || len(second.Added) != 1 {
+		t.Errorf("second delta %d to %d, %d removed, %d added", serial(second.From), serial(second.To), len(second.Removed), len(second.Added))
+	}
+
+	// The journal on disk holds the same changes
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deltas, ok := journal.Since(11); !ok || len(deltas) != 1 {
+		t.Errorf("journal changes since 11: %d, %v", len(deltas), ok)
+	}
+	if last, ok := journal.Serial(); !ok || last != 12 {
+		t.Errorf("journal ends at %d, %v", last, ok)
+	}
+}
@@ -0,0 +1,32 @@
+package zone
+
+import (
+	"bufio"
+	"com.sentry.dev/app/dns"
+	"fmt"
+	"os"
+	"time"
+)
+
+// WriteFile saves the zone as a master file with absolute names, replacing
+// the file at path atomically
+func WriteFile(path string, z *Zone) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "; zone %s written by MyDNS on %s\n", dns.Fqdn(z.Origin), time.Now().UTC().Format(time.RFC3339))
+	for _, r := range z.Records() {
+		fmt.Fprintln(w, r)
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
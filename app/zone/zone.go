@@ -0,0 +1,496 @@
+package zone
+
+import (
+	"com.sentry.dev/app/dns"
+	_type "com.sentry.dev/app/dns/type"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// maxChainLength bounds the CNAME chains followed inside a zone
+const maxChainLength = 8
+
+// rrsets holds the records of one owner name by type; empty for empty
+// non-terminals
+type rrsets map[_type.RecordType][]*dns.Record
+
+// Zone is the authoritative data of a zone, indexed by canonical owner name
+type Zone struct {
+	Origin string
+
+	mu      sync.RWMutex
+	nodes   map[string]rrsets
+	journal *Journal
+	signer  *Signer
+}
+
+// NewZone builds a zone from its records, which must hold exactly one SOA
+// at the origin. Records outside of the zone are skipped.
+func NewZone(origin string, records []*dns.Record) (*Zone, error) {
+	z := &Zone{
+		Origin: dns.CanonicalName(origin),
+		nodes:  make(map[string]rrsets),
+	}
+	z.nodes[z.Origin] = make(rrsets)
+	for _, r := range records {
+		if !dns.IsSubDomain(r.Name, z.Origin) {
+			fmt.Println("Ignoring out of zone record:", r)
+			continue
+		}
+		z.add(r)
+	}
+	soa := z.nodes[z.Origin][_type.TypeSOA]
+	if len(soa) != 1 {
+		return nil, errors.New("zone " + dns.Fqdn(z.Origin) + " must have exactly one SOA record at its origin")
+	}
+	for name, sets := range z.nodes {
+		if len(sets[_type.TypeCNAME]) > 0 && len(sets) > 1 {
+			return nil, errors.New("CNAME and other data at " + dns.Fqdn(name))
+		}
+	}
+	return z, nil
+}
+
+// Load reads a zone from its master file and opens its journal, if a path
+// is given. Changes journaled after the version of the file, such as
+// dynamic updates, are replayed; a journal that does not reach the serial
+// of the file is started afresh.
+func Load(path string, origin string, journalPath string) (*Zone, error) {
+	z, err := ParseFile(path, origin)
+	if err != nil || journalPath == "" {
+		return z, err
+	}
+	journal, err := OpenJournal(journalPath)
+	if err != nil {
+		return nil, err
+	}
+	z.journal = journal
+	last, ok := journal.Serial()
+	if !ok {
+		return z, nil
+	}
+	deltas, ok := journal.Since(serial(z.SOA()))
+	if !ok {
+		fmt.Printf("Journal of zone %s ends at serial %d, zone file is at %d; starting a new journal\n",
+			dns.Fqdn(z.Origin), last, serial(z.SOA()))
+		return z, journal.Reset()
+	}
+	if len(deltas) == 0 {
+		return z, nil
+	}
+	if z, err = z.apply(deltas, false); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Replayed %d changes from the journal of zone %s, now at serial %d\n",
+		len(deltas), dns.Fqdn(z.Origin), last)
+	return z, nil
+}
+
+// AttachJournal opens the journal at path for the zone. A journal that does
+// not end at the serial of the zone is started afresh.
+func (z *Zone) AttachJournal(path string) error {
+	journal, err := OpenJournal(path)
+	if err != nil {
+		return err
+	}
+	if last, ok := journal.Serial(); ok && last != serial(z.SOA()) {
+		fmt.Printf("Journal of zone %s ends at serial %d, zone is at %d; starting a new journal\n",
+			dns.Fqdn(z.Origin), last, serial(z.SOA()))
+		if err = journal.Reset(); err != nil {
+			return err
+		}
+	}
+	z.journal = journal
+	return nil
+}
+
+// Reload reads the master file of the zone again and returns the new
+// version, see Replace. A file whose serial did not increase is left
+// unloaded, so that dynamic updates are not lost.
+func (z *Zone) Reload(path string) (*Zone, error) {
+	fresh, err := ParseFile(path, z.Origin)
+	if err != nil {
+		return nil, err
+	}
+	current, next := serial(z.SOA()), serial(fresh.SOA())
+	if current == next {
+		if d := diff(z, fresh); len(d.Removed) > 0 || len(d.Added) > 0 {
+			return nil, fmt.Errorf("the file changed but its serial %d did not", next)
+		}
+		return z, nil
+	}
+	if !SerialLess(current, next) {
+		return nil, fmt.Errorf("serial %d of the file is not above the loaded serial %d", next, current)
+	}
+	return z.Replace(fresh)
+}
+
+// Replace returns fresh as the next version of the zone, recording the
+// changes in the journal when the serial increased. The zone itself is
+// returned when nothing changed.
+func (z *Zone) Replace(fresh *Zone) (*Zone, error) {
+	fresh.journal = z.journal
+	fresh.signer = z.signer
+	delta := diff(z, fresh)
+	if len(delta.Removed) == 0 && len(delta.Added) == 0 && serial(delta.From) == serial(delta.To) {
+		return z, nil
+	}
+	if z.journal == nil {
+		return fresh, nil
+	}
+	if !SerialLess(serial(delta.From), serial(delta.To)) {
+		fmt.Printf("Serial of zone %s did not increase (%d to %d); starting a new journal\n",
+			dns.Fqdn(z.Origin), serial(delta.From), serial(delta.To))
+		return fresh, z.journal.Reset()
+	}
+	return fresh, z.journal.Append(delta)
+}
+
+// Apply returns the version of the zone reached by applying deltas in
+// order, recording them in the journal
+func (z *Zone) Apply(deltas []*Delta) (*Zone, error) {
+	return z.apply(deltas, true)
+}
+
+func (z *Zone) apply(deltas []*Delta, record bool) (*Zone, error) {
+	records := z.Records()
+	current := records[0]
+	set := make(map[string]*dns.Record, len(records))
+	for _, r := range records[1:] {
+		set[r.String()] = r
+	}
+	for _, d := range deltas {
+		if serial(d.From) != serial(current) {
+			return nil, fmt.Errorf("delta from serial %d does not apply to serial %d", serial(d.From), serial(current))
+		}
+		for _, r := range d.Removed {
+			delete(set, r.String())
+		}
+		for _, r := range d.Added {
+			set[r.String()] = r
+		}
+		current = d.To
+	}
+
+	updated := []*dns.Record{current}
+	for _, r := range set {
+		updated = append(updated, r)
+	}
+	fresh, err := NewZone(z.Origin, updated)
+	if err != nil {
+		return nil, err
+	}
+	fresh.journal = z.journal
+	fresh.signer = z.signer
+	if z.journal != nil && record {
+		for _, d := range deltas {
+			if err = z.journal.Append(d); err != nil {
+				return fresh, err
+			}
+		}
+	}
+	return fresh, nil
+}
+
+// Changes returns the deltas leading from serial to the current version,
+// or false when the journal does not reach back that far
+func (z *Zone) Changes(from uint32) ([]*Delta, bool) {
+	if z.journal == nil {
+		return nil, serial(z.SOA()) == from
+	}
+	return z.journal.Since(from)
+}
+
+// diff returns the records removed and added between two versions of a zone
+func diff(old, new *Zone) *Delta {
+	oldRecords, newRecords := old.Records(), new.Records()
+	delta := &Delta{From: oldRecords[0], To: newRecords[0]}
+
+	index := func(records []*dns.Record) map[string]*dns.Record {
+		set := make(map[string]*dns.Record, len(records))
+		for _, r := range records[1:] {
+			set[r.String()] = r
+		}
+		return set
+	}
+	oldSet, newSet := index(oldRecords), index(newRecords)
+	for _, r := range oldRecords[1:] {
+		if _, ok := newSet[r.String()]; !ok {
+			delta.Removed = append(delta.Removed, r)
+		}
+	}
+	for _, r := range newRecords[1:] {
+		if _, ok := oldSet[r.String()]; !ok {
+			delta.Added = append(delta.Added, r)
+		}
+	}
+	return delta
+}
+
+// add inserts a record, creating the empty non-terminals above it
+func (z *Zone) add(r *dns.Record) {
+	name := dns.CanonicalName(r.Name)
+	sets, ok := z.nodes[name]
+	if !ok {
+		sets = make(rrsets)
+		z.nodes[name] = sets
+		for parent := dns.Parent(name); parent != z.Origin && dns.IsSubDomain(parent, z.Origin); parent = dns.Parent(parent) {
+			if _, ok = z.nodes[parent]; ok {
+				break
+			}
+			z.nodes[parent] = make(rrsets)
+		}
+	}
+	for _, existing := range sets[r.Type] {
+		if existing.Data.String() == r.Data.String() {
+			return
+		}
+	}
+	sets[r.Type] = append(sets[r.Type], r)
+}
+
+// SOA returns the SOA record of the zone
+func (z *Zone) SOA() *dns.Record {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.nodes[z.Origin][_type.TypeSOA][0]
+}
+
+// Records returns every record of the zone, SOA first and the rest ordered
+// by name and type
+func (z *Zone) Records() []*dns.Record {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	names := make([]string, 0, len(z.nodes))
+	for name := range z.nodes {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, compareNames)
+
+	records := []*dns.Record{z.nodes[z.Origin][_type.TypeSOA][0]}
+	for _, name := range names {
+		for _, set := range sortedSets(z.nodes[name]) {
+			if set[0].Type == _type.TypeSOA {
+				continue
+			}
+			records = append(records, set...)
+		}
+	}
+	return records
+}
+
+// Result is the authoritative answer to a question
+type Result struct {
+	RCode         _type.ResponseCode
+	Authoritative bool
+	Answer        []*dns.Record
+	Authority     []*dns.Record
+	Additional    []*dns.Record
+}
+
+// Lookup answers a question for a name inside the zone, with the DNSSEC
+// records of a signed zone when dnssecOK is set
+func (z *Zone) Lookup(qname string, qtype _type.RecordType, dnssecOK bool) *Result {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	res := &Result{Authoritative: true}
+	z.answer(res, qname, qtype, dnssecOK && z.signer != nil, make(map[string]bool))
+	return res
+}
+
+func (z *Zone) answer(res *Result, qname string, qtype _type.RecordType, sign bool, visited map[string]bool) {
+	name := dns.CanonicalName(qname)
+	visited[name] = true
+
+	// The DS RRset of a delegation is answered by this side of the cut
+	// (RFC 4035 3.1.4.1)
+	if cut := z.findCut(name); cut != "" && (cut != name || qtype != _type.TypeDS) {
+		if len(res.Answer) == 0 {
+			res.Authoritative = false
+		}
+		ns := z.nodes[cut][_type.TypeNS]
+		res.Authority = append(res.Authority, ns...)
+		if sign {
+			res.Authority = append(res.Authority, z.signer.delegation(z, cut)...)
+		}
+		res.Additional = append(res.Additional, z.addresses(ns)...)
+		return
+	}
+
+	sets, exists := z.node(name)
+	source := name
+	if !exists {
+		source = z.findWildcard(name)
+		if source == "" {
+			res.RCode = _type.RCodeNXDomain
+			z.negative(res, sign)
+			if sign {
+				res.Authority = append(res.Authority, z.signer.nameError(z, name)...)
+			}
+			return
+		}
+		sets = synthesize(z.nodes[source], qname)
+	}
+
+	var answer [][]*dns.Record
+	cname := sets[_type.TypeCNAME]
+	switch {
+	case len(cname) > 0 && qtype != _type.TypeCNAME && qtype != _type.TypeANY:
+		answer = [][]*dns.Record{cname[:1]}
+	case qtype == _type.TypeANY:
+		answer = sortedSets(sets)
+	case len(sets[qtype]) > 0:
+		answer = [][]*dns.Record{sets[qtype]}
+	}
+	if len(answer) == 0 {
+		z.negative(res, sign)
+		if sign {
+			res.Authority = append(res.Authority, z.signer.noData(z, name, source)...)
+		}
+		return
+	}
+	for _, set := range answer {
+		res.Answer = append(res.Answer, set...)
+		if sign {
+			// A synthesized answer carries the signatures of the wildcard
+			signed, _ := z.node(source)
+			res.Answer = append(res.Answer, z.signer.sign(z, signed[set[0].Type], qname, set[0].TTL)...)
+		}
+	}
+	if sign && source != name {
+		res.Authority = append(res.Authority, z.signer.expansion(z, name, dns.Parent(source))...)
+	}
+
+	if len(cname) > 0 && qtype != _type.TypeCNAME && qtype != _type.TypeANY {
+		target := dns.CanonicalName(cname[0].Data.(*dns.CNAME).Target)
+		if len(visited) < maxChainLength && !visited[target] && dns.IsSubDomain(target, z.Origin) {
+			z.answer(res, target, qtype, sign, visited)
+		}
+		return
+	}
+	for _, set := range answer {
+		res.Additional = append(res.Additional, z.addresses(set)...)
+	}
+}
+
+// findCut returns the topmost delegation point between the origin and name,
+// or "" when name is not delegated away
+func (z *Zone) findCut(name string) string {
+	labels := dns.SplitName(name)
+	depth := len(labels) - len(dns.SplitName(z.Origin))
+	for i := depth - 1; i >= 0; i-- {
+		candidate := strings.Join(labels[i:], ".")
+		if sets, ok := z.nodes[candidate]; ok && len(sets[_type.TypeNS]) > 0 {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// findWildcard returns the name of the wildcard at the closest encloser of
+// a name that does not exist (RFC 4592), "" when there is none
+func (z *Zone) findWildcard(name string) string {
+	for encloser := dns.Parent(name); dns.IsSubDomain(encloser, z.Origin); encloser = dns.Parent(encloser) {
+		if _, ok := z.nodes[encloser]; ok {
+			if wildcard := joinName("*", encloser); z.nodes[wildcard] != nil {
+				return wildcard
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// synthesize copies wildcard records to the queried owner name
+func synthesize(sets rrsets, owner string) rrsets {
+	synthesized := make(rrsets, len(sets))
+	for t, set := range sets {
+		for _, r := range set {
+			copied := *r
+			copied.Name = owner
+			synthesized[t] = append(synthesized[t], &copied)
+		}
+	}
+	return synthesized
+}
+
+// addresses returns the in-zone A and AAAA records of the hosts named by
+// NS, MX and SRV records, for the additional section
+func (z *Zone) addresses(records []*dns.Record) []*dns.Record {
+	var additional []*dns.Record
+	for _, r := range records {
+		var host string
+		switch data := r.Data.(type) {
+		case *dns.NS:
+			host = data.Host
+		case *dns.MX:
+			host = data.Exchange
+		case *dns.SRV:
+			host = data.Target
+		default:
+			continue
+		}
+		if sets, ok := z.nodes[dns.CanonicalName(host)]; ok {
+			additional = append(additional, sets[_type.TypeA]...)
+			additional = append(additional, sets[_type.TypeAAAA]...)
+		}
+	}
+	return additional
+}
+
+// negative adds the SOA of a negative answer to its authority section,
+// signed when sign is set
+func (z *Zone) negative(res *Result, sign bool) {
+	soa := z.negativeSOA()
+	res.Authority = append(res.Authority, soa)
+	if sign {
+		res.Authority = append(res.Authority, z.signer.sign(z, z.nodes[z.Origin][_type.TypeSOA], soa.Name, soa.TTL)...)
+	}
+}
+
+// negativeSOA returns the SOA for the authority section of a negative
+// answer, its TTL capped by the SOA minimum (RFC 2308)
+func (z *Zone) negativeSOA() *dns.Record {
+	soa := *z.nodes[z.Origin][_type.TypeSOA][0]
+	soa.TTL = min(soa.TTL, soa.Data.(*dns.SOA).Minimum)
+	return &soa
+}
+
+func sortedSets(sets rrsets) [][]*dns.Record {
+	types := make([]_type.RecordType, 0, len(sets))
+	for t := range sets {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	ordered := make([][]*dns.Record, 0, len(types))
+	for _, t := range types {
+		if len(sets[t]) > 0 {
+			ordered = append(ordered, sets[t])
+		}
+	}
+	return ordered
+}
+
+// compareNames orders names by their labels from the right, so that a name
+// comes right before the names below it
+func compareNames(a, b string) int {
+	la, lb := dns.SplitName(a), dns.SplitName(b)
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+func joinName(label, name string) string {
+	if name == "" {
+		return label
+	}
+	return label + "." + name
+}